@@ -101,6 +101,9 @@ var BatchUpdateInterval = GetOrDefault("BATCH_UPDATE_INTERVAL", 5)
 var RelayTimeout = GetOrDefault("RELAY_TIMEOUT", 600)   // unit is second
 var ConnectTimeout = GetOrDefault("CONNECT_TIMEOUT", 5) // unit is second
 
+// Claude 渠道建立连接失败时的重试次数
+var NetworkRetryTimes = GetOrDefault("NETWORK_RETRY_TIMES", 2)
+
 const (
 	RequestIdKey = "X-Oneapi-Request-Id"
 )
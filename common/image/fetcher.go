@@ -0,0 +1,96 @@
+package image
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 获取非 http(s) 地址的文件，如 gs://、s3:// 等对象存储，返回 mime 类型和原始字节，mime 类型为空时按内容识别
+type Fetcher interface {
+	Fetch(url string) (mimeType string, data []byte, err error)
+}
+
+// 按 scheme 注册的 Fetcher，默认没有注册，非 http(s) 地址全部拒绝
+var (
+	fetchersLock sync.RWMutex
+	fetchers     = make(map[string]Fetcher)
+)
+
+func init() {
+	// 设置 IMAGE_FILE_ROOT 时允许读取该目录下的 file:// 文件，只应在可信环境中开启
+	if root := os.Getenv("IMAGE_FILE_ROOT"); root != "" {
+		RegisterFetcher("file", NewFileFetcher(root))
+	}
+}
+
+// 注册 scheme 对应的 Fetcher，fetcher 为 nil 时取消注册
+func RegisterFetcher(scheme string, fetcher Fetcher) {
+	fetchersLock.Lock()
+	defer fetchersLock.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if fetcher == nil {
+		delete(fetchers, scheme)
+		return
+	}
+	fetchers[scheme] = fetcher
+}
+
+func getFetcher(rawURL string) Fetcher {
+	scheme, _, ok := strings.Cut(rawURL, "://")
+	if !ok {
+		return nil
+	}
+
+	fetchersLock.RLock()
+	defer fetchersLock.RUnlock()
+	return fetchers[strings.ToLower(scheme)]
+}
+
+// 使用注册的 Fetcher 获取文件，返回 base64 编码的数据
+func fetchWithFetcher(fetcher Fetcher, rawURL string) (mimeType string, data string, err error) {
+	mimeType, raw, err := fetcher.Fetch(rawURL)
+	if err != nil {
+		return
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(raw)
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+
+	return strings.TrimSpace(mimeType), base64.StdEncoding.EncodeToString(raw), nil
+}
+
+type fileFetcher struct {
+	root string
+}
+
+// 读取 root 目录下的 file:// 文件，不允许访问目录之外的文件
+func NewFileFetcher(root string) Fetcher {
+	return &fileFetcher{root: filepath.Clean(root)}
+}
+
+func (f *fileFetcher) Fetch(rawURL string) (string, []byte, error) {
+	fileURL, err := url.Parse(rawURL)
+	if err != nil || fileURL.Scheme != "file" || (fileURL.Host != "" && fileURL.Host != "localhost") {
+		return "", nil, errors.New("invalid file link")
+	}
+
+	path := filepath.Clean(filepath.FromSlash(fileURL.Path))
+	relative, err := filepath.Rel(f.root, path)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", nil, errors.New("file link is outside the allowed directory")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return "", data, nil
+}
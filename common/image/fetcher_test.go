@@ -0,0 +1,82 @@
+package image_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	img "one-api/common/image"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockFetcher struct {
+	urls []string
+}
+
+func (f *mockFetcher) Fetch(url string) (string, []byte, error) {
+	f.urls = append(f.urls, url)
+	if url == "mock://bucket/missing.png" {
+		return "", nil, errors.New("object not found")
+	}
+	data, _ := base64.StdEncoding.DecodeString(testPNG)
+	return "", data, nil
+}
+
+const testPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR4nGP4z8DwHwAFAAH/iZk9HQAAAABJRU5ErkJggg=="
+
+func TestGetImageFromUrlFetcher(t *testing.T) {
+	// 未注册时拒绝
+	_, _, err := img.GetImageFromUrl("mock://bucket/image.png")
+	assert.Error(t, err)
+	_, _, err = img.GetImageFromUrl("s3://bucket/image.png")
+	assert.Error(t, err)
+	_, _, err = img.GetImageFromUrl("file:///etc/passwd")
+	assert.Error(t, err)
+
+	fetcher := &mockFetcher{}
+	img.RegisterFetcher("mock", fetcher)
+	defer img.RegisterFetcher("mock", nil)
+
+	// 未返回 mime 类型时按内容识别
+	mimeType, data, err := img.GetImageFromUrl("mock://bucket/image.png")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", mimeType)
+	assert.Equal(t, testPNG, data)
+	assert.Equal(t, []string{"mock://bucket/image.png"}, fetcher.urls)
+
+	_, _, err = img.GetImageFromUrl("mock://bucket/missing.png")
+	assert.EqualError(t, err, "object not found")
+
+	// 取消注册后再次拒绝
+	img.RegisterFetcher("mock", nil)
+	_, _, err = img.GetImageFromUrl("mock://bucket/image.png")
+	assert.Error(t, err)
+}
+
+func TestFileFetcher(t *testing.T) {
+	root := t.TempDir()
+	data, _ := base64.StdEncoding.DecodeString(testPNG)
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "image.png"), data, 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "notes.txt"), []byte("hello"), 0o644))
+
+	img.RegisterFetcher("file", img.NewFileFetcher(root))
+	defer img.RegisterFetcher("file", nil)
+
+	mimeType, encoded, err := img.GetImageFromUrl("file://" + filepath.ToSlash(filepath.Join(root, "image.png")))
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", mimeType)
+	assert.Equal(t, testPNG, encoded)
+
+	// 不是图片
+	_, _, err = img.GetImageFromUrl("file://" + filepath.ToSlash(filepath.Join(root, "notes.txt")))
+	assert.Error(t, err)
+
+	// 不允许访问目录之外的文件
+	_, _, err = img.GetImageFromUrl("file://" + filepath.ToSlash(filepath.Join(root, "..", "image.png")))
+	assert.EqualError(t, err, "file link is outside the allowed directory")
+	_, _, err = img.GetImageFromUrl("file:///etc/passwd")
+	assert.EqualError(t, err, "file link is outside the allowed directory")
+}
@@ -4,15 +4,20 @@ import (
 	"bytes"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"image"
 	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
+	"image/jpeg"
+	"image/png"
+	"math"
 	"net/http"
 	"regexp"
 	"strings"
 	"sync"
 
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
 	_ "golang.org/x/image/webp"
 )
 
@@ -44,19 +49,25 @@ func GetImageSizeFromUrl(url string) (width int, height int, err error) {
 	return img.Width, img.Height, nil
 }
 
-func GetImageFromUrl(url string) (mimeType string, data string, err error) {
+var dataURLPattern = regexp.MustCompile(`^data:(image/[a-zA-Z0-9.+-]+);base64,(.+)$`)
 
-	if strings.HasPrefix(url, "data:image/") {
-		dataURLPattern := regexp.MustCompile(`data:image/([^;]+);base64,(.*)`)
+func GetImageFromUrl(url string) (mimeType string, data string, err error) {
+	// data URI 直接解析，不需要请求网络
+	if strings.HasPrefix(url, "data:") {
+		return getImageFromDataURL(url)
+	}
 
-		matches := dataURLPattern.FindStringSubmatch(url)
-		if len(matches) == 3 && matches[2] != "" {
-			mimeType = "image/" + matches[1]
-			data = matches[2]
-			return
+	// 其他 scheme 需要注册对应的 Fetcher
+	if fetcher := getFetcher(url); fetcher != nil {
+		mimeType, data, err = fetchWithFetcher(fetcher, url)
+		if err == nil && !strings.HasPrefix(mimeType, "image/") {
+			err = errors.New("invalid image link")
 		}
+		return
+	}
 
-		err = errors.New("image base64 decode failed")
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		err = errors.New("invalid image link")
 		return
 	}
 
@@ -82,6 +93,68 @@ func GetImageFromUrl(url string) (mimeType string, data string, err error) {
 	return
 }
 
+func getImageFromDataURL(url string) (mimeType string, data string, err error) {
+	matches := dataURLPattern.FindStringSubmatch(url)
+	if len(matches) != 3 {
+		err = errors.New("image base64 decode failed")
+		return
+	}
+
+	if _, decodeErr := base64.StdEncoding.DecodeString(matches[2]); decodeErr != nil {
+		err = errors.New("image base64 decode failed")
+		return
+	}
+
+	return matches[1], matches[2], nil
+}
+
+var fileDataURLPattern = regexp.MustCompile(`^data:([a-zA-Z0-9.+-]+/[a-zA-Z0-9.+-]+);base64,(.+)$`)
+
+// 获取任意类型的文件，返回 mime 类型和 base64 编码的数据
+func GetFileFromUrl(url string) (mimeType string, data string, err error) {
+	if strings.HasPrefix(url, "data:") {
+		matches := fileDataURLPattern.FindStringSubmatch(url)
+		if len(matches) != 3 {
+			err = errors.New("file base64 decode failed")
+			return
+		}
+		if _, decodeErr := base64.StdEncoding.DecodeString(matches[2]); decodeErr != nil {
+			err = errors.New("file base64 decode failed")
+			return
+		}
+		return matches[1], matches[2], nil
+	}
+
+	if fetcher := getFetcher(url); fetcher != nil {
+		return fetchWithFetcher(fetcher, url)
+	}
+
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		err = errors.New("invalid file link")
+		return
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("failed to fetch file, status code: %d", resp.StatusCode)
+		return
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	_, err = buffer.ReadFrom(resp.Body)
+	if err != nil {
+		return
+	}
+	mimeType, _, _ = strings.Cut(resp.Header.Get("Content-Type"), ";")
+	mimeType = strings.TrimSpace(mimeType)
+	data = base64.StdEncoding.EncodeToString(buffer.Bytes())
+	return
+}
+
 var (
 	reg = regexp.MustCompile(`data:image/([^;]+);base64,`)
 )
@@ -116,3 +189,111 @@ func GetImageSize(image string) (width int, height int, err error) {
 	}
 	return GetImageSizeFromUrl(image)
 }
+
+// 将 base64 编码的图片重新编码为 png，用于上游不支持的图片格式（如 bmp、tiff）
+func TranscodeToPNG(data string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(decoded))
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+var ErrImageTooLarge = errors.New("image is too large")
+
+// 图片超过限制时按比例缩小，maxDimension 为最长边的像素，maxBytes 为 base64 编码后的长度，0 表示不限制
+// 缩小后 jpeg 仍编码为 jpeg，其他格式编码为 png，仍超过限制时返回 ErrImageTooLarge
+func ResizeImage(mimeType, data string, maxDimension, maxBytes int) (string, string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", "", err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(decoded))
+	if err != nil {
+		return "", "", err
+	}
+
+	if withinImageLimits(config.Width, config.Height, len(data), maxDimension, maxBytes) {
+		return mimeType, data, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(decoded))
+	if err != nil {
+		return "", "", err
+	}
+
+	scale := 1.0
+	if longest := math.Max(float64(config.Width), float64(config.Height)); maxDimension > 0 && longest > float64(maxDimension) {
+		scale = float64(maxDimension) / longest
+	}
+
+	// 超过字节限制时按面积比例估算缩小比例
+	if maxBytes > 0 && len(data) > maxBytes {
+		scale = math.Min(scale, math.Sqrt(float64(maxBytes)/float64(len(data))))
+	}
+
+	if mimeType != "image/jpeg" {
+		mimeType = "image/png"
+	}
+
+	// 编码后的大小无法精确估算，仍超过限制时继续缩小
+	for attempt := 0; attempt < 3; attempt++ {
+		width := int(math.Max(1, math.Round(float64(config.Width)*scale)))
+		height := int(math.Max(1, math.Round(float64(config.Height)*scale)))
+		data, err = encodeImage(scaleImage(img, width, height), mimeType)
+		if err != nil {
+			return "", "", err
+		}
+
+		if withinImageLimits(width, height, len(data), maxDimension, maxBytes) {
+			return mimeType, data, nil
+		}
+		scale *= math.Sqrt(float64(maxBytes)/float64(len(data))) * 0.9
+	}
+
+	return "", "", ErrImageTooLarge
+}
+
+func withinImageLimits(width, height, size, maxDimension, maxBytes int) bool {
+	if maxDimension > 0 && (width > maxDimension || height > maxDimension) {
+		return false
+	}
+	return maxBytes <= 0 || size <= maxBytes
+}
+
+func scaleImage(img image.Image, width, height int) image.Image {
+	if img.Bounds().Dx() == width && img.Bounds().Dy() == height {
+		return img
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Over, nil)
+	return scaled
+}
+
+func encodeImage(img image.Image, mimeType string) (string, error) {
+	var buffer bytes.Buffer
+	var err error
+	if mimeType == "image/jpeg" {
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buffer, img)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
@@ -1,12 +1,16 @@
 package image_test
 
 import (
+	"bytes"
 	"encoding/base64"
 	"image"
+	"image/color"
 	_ "image/gif"
 	_ "image/jpeg"
+	"image/png"
 	_ "image/png"
 	"io"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
@@ -15,6 +19,7 @@ import (
 	img "one-api/common/image"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/bmp"
 	_ "golang.org/x/image/webp"
 )
 
@@ -200,3 +205,91 @@ func TestGetImageFromUrl(t *testing.T) {
 	_, _, err = img.GetImageFromUrl(encodedBase64)
 	assert.Error(t, err)
 }
+
+func TestGetImageFromDataURL(t *testing.T) {
+	png := "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR4nGP4z8DwHwAFAAH/iZk9HQAAAABJRU5ErkJggg=="
+
+	mimeType, data, err := img.GetImageFromUrl("data:image/png;base64," + png)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", mimeType)
+	assert.Equal(t, png, data)
+
+	_, _, err = img.GetImageFromUrl("data:image/png;base64,not-base64!!")
+	assert.Error(t, err)
+
+	_, _, err = img.GetImageFromUrl("data:text/plain;base64,aGVsbG8=")
+	assert.Error(t, err)
+
+	_, _, err = img.GetImageFromUrl("ftp://example.com/image.png")
+	assert.Error(t, err)
+}
+
+func TestTranscodeToPNG(t *testing.T) {
+	source := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	source.Set(1, 1, color.RGBA{R: 255, A: 255})
+	var buffer bytes.Buffer
+	assert.NoError(t, bmp.Encode(&buffer, source))
+
+	data, err := img.TranscodeToPNG(base64.StdEncoding.EncodeToString(buffer.Bytes()))
+	assert.NoError(t, err)
+
+	decoded, _ := base64.StdEncoding.DecodeString(data)
+	transcoded, format, err := image.Decode(bytes.NewReader(decoded))
+	assert.NoError(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, source.Bounds(), transcoded.Bounds())
+	r, _, _, _ := transcoded.At(1, 1).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+
+	_, err = img.TranscodeToPNG(base64.StdEncoding.EncodeToString([]byte("<svg></svg>")))
+	assert.Error(t, err)
+}
+
+func encodeTestPNG(t *testing.T, width, height int, noisy bool) string {
+	source := image.NewRGBA(image.Rect(0, 0, width, height))
+	random := rand.New(rand.NewSource(1))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			if noisy {
+				source.Set(x, y, color.RGBA{R: uint8(random.Intn(256)), G: uint8(random.Intn(256)), B: uint8(random.Intn(256)), A: 255})
+			} else {
+				source.Set(x, y, color.RGBA{R: 255, A: 255})
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	assert.NoError(t, png.Encode(&buffer, source))
+	return base64.StdEncoding.EncodeToString(buffer.Bytes())
+}
+
+func TestResizeImage(t *testing.T) {
+	small := encodeTestPNG(t, 40, 20, false)
+	mimeType, data, err := img.ResizeImage("image/png", small, 100, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", mimeType)
+	assert.Equal(t, small, data)
+
+	// 按最长边缩小并保持宽高比
+	large := encodeTestPNG(t, 400, 200, false)
+	mimeType, data, err = img.ResizeImage("image/png", large, 100, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", mimeType)
+	width, height, err := img.GetImageSizeFromBase64(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, width)
+	assert.Equal(t, 50, height)
+
+	// 超过字节数限制时继续缩小
+	noisy := encodeTestPNG(t, 200, 200, true)
+	_, data, err = img.ResizeImage("image/png", noisy, 0, len(noisy)/4)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(data), len(noisy)/4)
+	width, height, _ = img.GetImageSizeFromBase64(data)
+	assert.Less(t, width, 200)
+	assert.Equal(t, width, height)
+
+	// 缩小后仍然无法满足限制
+	_, _, err = img.ResizeImage("image/png", noisy, 0, 10)
+	assert.ErrorIs(t, err, img.ErrImageTooLarge)
+}
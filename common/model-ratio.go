@@ -93,6 +93,9 @@ func init() {
 		"claude-2.1":               {[]float64{4, 12}, ChannelTypeAnthropic},
 		"claude-3-opus-20240229":   {[]float64{7.5, 22.5}, ChannelTypeAnthropic},
 		"claude-3-sonnet-20240229": {[]float64{1.3, 3.9}, ChannelTypeAnthropic},
+		// Voyage embeddings $0.06/million tokens, $0.02/million tokens
+		"voyage-3":      {[]float64{0.03, 0.03}, ChannelTypeAnthropic},
+		"voyage-3-lite": {[]float64{0.01, 0.01}, ChannelTypeAnthropic},
 
 		// ￥0.004 / 1k tokens ￥0.008 / 1k tokens
 		"ERNIE-Speed": {[]float64{0.2857, 0.5714}, ChannelTypeBaidu},
@@ -0,0 +1,44 @@
+package requester_test
+
+import (
+	"net/http"
+	"one-api/common/requester"
+	_ "one-api/common/test/init"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// AWS Signature Version 4 测试套件中的 get-vanilla 和 post-x-www-form-urlencoded 用例
+func TestSignAWSRequest(t *testing.T) {
+	credentials := requester.AWSCredentials{
+		AccessKeyId:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	requester.SignAWSRequest(req, nil, credentials, "us-east-1", "service", now)
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", req.Header.Get("Authorization"))
+
+	body := []byte("Param1=value1")
+	req, _ = http.NewRequest(http.MethodPost, "https://example.amazonaws.com/", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	requester.SignAWSRequest(req, body, credentials, "us-east-1", "service", now)
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a", req.Header.Get("Authorization"))
+}
+
+func TestSignAWSRequestSessionToken(t *testing.T) {
+	credentials := requester.AWSCredentials{
+		AccessKeyId:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		SessionToken:    "session-token",
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "https://bedrock-runtime.us-east-1.amazonaws.com/model/anthropic.claude-v2%3A1/invoke", nil)
+	requester.SignAWSRequest(req, []byte("{}"), credentials, "us-east-1", "bedrock", time.Now())
+	assert.Equal(t, "session-token", req.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,")
+}
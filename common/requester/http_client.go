@@ -2,11 +2,16 @@ package requester
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"one-api/common"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/proxy"
@@ -78,3 +83,98 @@ func init() {
 		HTTPClient.Timeout = time.Duration(common.RelayTimeout) * time.Second
 	}
 }
+
+// 创建使用指定超时时间的客户端，与 HTTPClient 共用连接池，timeout 为 0 时不限制总时长
+func NewHTTPClientWithTimeout(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: HTTPClient.Transport,
+		Timeout:   timeout,
+	}
+}
+
+const networkRetryBaseDelay = 200 * time.Millisecond
+
+// 建立连接失败（包括连接被拒绝）时请求一定没有发出，可以重新发送
+// 连接被重置或提前关闭时上游可能已经收到请求，重试会重复生成和计费，不认为可以重试
+func IsConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// 发送请求，建立连接失败时按带抖动的指数退避重试，最多 NetworkRetryTimes 次
+func DoWithNetworkRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if err == nil || attempt >= common.NetworkRetryTimes || !IsConnectionError(err) {
+			return resp, err
+		}
+
+		// 请求体无法重新生成时不能重试
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			req.Body = body
+		}
+
+		delay := networkRetryBaseDelay << attempt
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		common.SysLog(fmt.Sprintf("request %s failed: %s, retrying in %s", req.URL.Host, err.Error(), delay))
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+var ErrIdleTimeout = errors.New("stream idle timeout")
+
+// 超过 timeout 没有读到数据时关闭响应体
+type idleTimeoutBody struct {
+	body     io.ReadCloser
+	timer    *time.Timer
+	timeout  time.Duration
+	timedOut int32
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if atomic.LoadInt32(&b.timedOut) == 1 {
+		return n, ErrIdleTimeout
+	}
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.body.Close()
+}
+
+// 为流式响应设置读取空闲超时
+func SetIdleTimeout(resp *http.Response, timeout time.Duration) {
+	body := &idleTimeoutBody{
+		body:    resp.Body,
+		timeout: timeout,
+	}
+	body.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&body.timedOut, 1)
+		body.body.Close()
+	})
+	resp.Body = body
+}
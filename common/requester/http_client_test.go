@@ -0,0 +1,98 @@
+package requester_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"one-api/common"
+	"one-api/common/requester"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 前 failures 次请求返回 err，默认为连接被拒绝，之后返回 200，并记录每次收到的请求体
+type failingTransport struct {
+	failures int
+	err      error
+	calls    int
+	bodies   []string
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls++
+	body, _ := io.ReadAll(req.Body)
+	t.bodies = append(t.bodies, string(body))
+	if t.calls <= t.failures {
+		if t.err != nil {
+			return nil, t.err
+		}
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"ok":true}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestDoWithNetworkRetry(t *testing.T) {
+	transport := &failingTransport{failures: 1}
+	client := &http.Client{Transport: transport}
+
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/v1/messages", strings.NewReader(`{"model":"claude"}`))
+	resp, err := requester.DoWithNetworkRetry(client, req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, transport.calls)
+	// 重试时重新发送完整的请求体
+	assert.Equal(t, []string{`{"model":"claude"}`, `{"model":"claude"}`}, transport.bodies)
+}
+
+func TestDoWithNetworkRetryExhausted(t *testing.T) {
+	transport := &failingTransport{failures: common.NetworkRetryTimes + 1}
+	client := &http.Client{Transport: transport}
+
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/v1/messages", strings.NewReader(`{}`))
+	_, err := requester.DoWithNetworkRetry(client, req)
+	assert.ErrorIs(t, err, syscall.ECONNREFUSED)
+	assert.Equal(t, common.NetworkRetryTimes+1, transport.calls)
+}
+
+func TestDoWithNetworkRetryCanceled(t *testing.T) {
+	transport := &failingTransport{failures: 1}
+	client := &http.Client{Transport: transport}
+
+	// 请求已取消时不再重试
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://example.com/v1/messages", strings.NewReader(`{}`))
+	_, err := requester.DoWithNetworkRetry(client, req)
+	assert.Error(t, err)
+	assert.LessOrEqual(t, transport.calls, 1)
+}
+
+func TestDoWithNetworkRetryRequestSent(t *testing.T) {
+	// 连接被重置或提前关闭时上游可能已经收到请求，不能重试
+	for _, err := range []error{
+		&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+		&net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)},
+		io.ErrUnexpectedEOF,
+	} {
+		transport := &failingTransport{failures: 1, err: err}
+		client := &http.Client{Transport: transport}
+
+		req, _ := http.NewRequest(http.MethodPost, "http://example.com/v1/messages", strings.NewReader(`{}`))
+		_, sendErr := requester.DoWithNetworkRetry(client, req)
+		assert.Error(t, sendErr)
+		assert.Equal(t, 1, transport.calls)
+	}
+}
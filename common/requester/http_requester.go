@@ -18,11 +18,16 @@ import (
 
 type HttpErrorHandler func(*http.Response) *types.OpenAIError
 
+// 收到响应后的回调，用于读取限额等响应头
+type HttpResponseHandler func(*http.Response)
+
 type HTTPRequester struct {
 	requestBuilder    RequestBuilder
 	CreateFormBuilder func(io.Writer) FormBuilder
 	ErrorHandler      HttpErrorHandler
+	ResponseHandler   HttpResponseHandler
 	proxyAddr         string
+	context           context.Context
 }
 
 // NewHTTPRequester 创建一个新的 HTTPRequester 实例。
@@ -47,18 +52,35 @@ type requestOptions struct {
 
 type requestOption func(*requestOptions)
 
+// 调用响应回调，包括失败的响应
+func (r *HTTPRequester) HandleResponse(resp *http.Response) {
+	if r.ResponseHandler != nil {
+		r.ResponseHandler(resp)
+	}
+}
+
+// 设置请求的上下文，上下文取消时中断请求
+func (r *HTTPRequester) SetContext(ctx context.Context) {
+	r.context = ctx
+}
+
 func (r *HTTPRequester) getContext() context.Context {
+	ctx := r.context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	if r.proxyAddr == "" {
-		return context.Background()
+		return ctx
 	}
 
 	// 如果是以 socks5:// 开头的地址，那么使用 socks5 代理
 	if strings.HasPrefix(r.proxyAddr, "socks5://") {
-		return context.WithValue(context.Background(), ProxySock5AddrKey, r.proxyAddr)
+		return context.WithValue(ctx, ProxySock5AddrKey, r.proxyAddr)
 	}
 
 	// 否则使用 http 代理
-	return context.WithValue(context.Background(), ProxyHTTPAddrKey, r.proxyAddr)
+	return context.WithValue(ctx, ProxyHTTPAddrKey, r.proxyAddr)
 
 }
 
@@ -85,6 +107,7 @@ func (r *HTTPRequester) SendRequest(req *http.Request, response any, outputResp
 	if err != nil {
 		return nil, common.ErrorWrapper(err, "http_request_failed", http.StatusInternalServerError)
 	}
+	r.HandleResponse(resp)
 
 	if !outputResp {
 		defer resp.Body.Close()
@@ -121,6 +144,7 @@ func (r *HTTPRequester) SendRequestRaw(req *http.Request) (*http.Response, *type
 	if err != nil {
 		return nil, common.ErrorWrapper(err, "http_request_failed", http.StatusInternalServerError)
 	}
+	r.HandleResponse(resp)
 
 	// 处理响应
 	if r.IsFailureStatusCode(resp) {
@@ -144,6 +168,7 @@ func RequestStream[T streamable](requester *HTTPRequester, resp *http.Response,
 
 		DataChan: make(chan T),
 		ErrChan:  make(chan error),
+		done:     make(chan struct{}),
 	}
 
 	return stream, nil
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"net/http"
+	"sync"
+	"sync/atomic"
 )
 
 var StreamClosed = []byte("stream_closed")
@@ -20,6 +22,13 @@ type StreamReaderInterface[T streamable] interface {
 	Close()
 }
 
+// 支持断点续传的流，返回第一条数据的事件 id，之后的数据依次加一
+type StreamEventIdInterface interface {
+	FirstEventId() int
+}
+
+// DataChan 和 ErrChan 不带缓冲，客户端读取慢时发送会阻塞，上游的读取随之暂停，
+// 内存中最多只有一条待发送的数据和 bufio 的读缓冲
 type streamReader[T streamable] struct {
 	reader   *bufio.Reader
 	response *http.Response
@@ -28,9 +37,15 @@ type streamReader[T streamable] struct {
 
 	DataChan chan T
 	ErrChan  chan error
+
+	// processLines 退出时关闭
+	done      chan struct{}
+	started   int32
+	closeOnce sync.Once
 }
 
 func (stream *streamReader[T]) Recv() (<-chan T, <-chan error) {
+	atomic.StoreInt32(&stream.started, 1)
 	go stream.processLines()
 
 	return stream.DataChan, stream.ErrChan
@@ -38,6 +53,8 @@ func (stream *streamReader[T]) Recv() (<-chan T, <-chan error) {
 
 //nolint:gocognit
 func (stream *streamReader[T]) processLines() {
+	defer close(stream.done)
+
 	for {
 		rawLine, readErr := stream.reader.ReadBytes('\n')
 		if readErr != nil {
@@ -61,6 +78,23 @@ func (stream *streamReader[T]) processLines() {
 	}
 }
 
+// 关闭后客户端不再读取，丢弃剩余的数据，避免 processLines 阻塞在发送上无法退出
 func (stream *streamReader[T]) Close() {
 	stream.response.Body.Close()
+	if atomic.LoadInt32(&stream.started) == 0 {
+		return
+	}
+
+	stream.closeOnce.Do(func() {
+		go func() {
+			for {
+				select {
+				case <-stream.DataChan:
+				case <-stream.ErrChan:
+				case <-stream.done:
+					return
+				}
+			}
+		}()
+	})
 }
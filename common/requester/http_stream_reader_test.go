@@ -0,0 +1,89 @@
+package requester_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common/requester"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 不断生成 SSE 数据行，记录已被读取的字节数
+type lineGenerator struct {
+	line   int
+	read   int64
+	closed int32
+}
+
+func (g *lineGenerator) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&g.closed) == 1 {
+		return 0, errors.New("body closed")
+	}
+	g.line++
+	n := copy(p, fmt.Sprintf("data: %d\n", g.line))
+	atomic.AddInt64(&g.read, int64(n))
+	return n, nil
+}
+
+func (g *lineGenerator) Close() error {
+	atomic.StoreInt32(&g.closed, 1)
+	return nil
+}
+
+func TestStreamBackpressure(t *testing.T) {
+	body := &lineGenerator{}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       body,
+	}
+
+	var handled int32
+	handler := func(rawLine *[]byte, dataChan chan string, errChan chan error) {
+		atomic.AddInt32(&handled, 1)
+		dataChan <- string(*rawLine)
+	}
+
+	goroutines := runtime.NumGoroutine()
+	stream, errWithCode := requester.RequestStream[string](requester.NewHTTPRequester("", nil), resp, handler)
+	assert.Nil(t, errWithCode)
+
+	// 客户端读取很慢，上游读取需要随之暂停
+	dataChan, _ := stream.Recv()
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, fmt.Sprintf("data: %d", i), <-dataChan)
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// 已读取 3 条，最多还有 1 条阻塞在发送上，读取的数据不超过 bufio 的缓冲
+	assert.LessOrEqual(t, atomic.LoadInt32(&handled), int32(4))
+	assert.LessOrEqual(t, atomic.LoadInt64(&body.read), int64(4096+64))
+
+	// 客户端断开后不再读取，处理协程也需要退出
+	stream.Close()
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+}
+
+func TestStreamCloseWithoutRecv(t *testing.T) {
+	body := &lineGenerator{}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(body),
+	}
+
+	stream, errWithCode := requester.RequestStream[string](requester.NewHTTPRequester("", nil), resp, func(rawLine *[]byte, dataChan chan string, errChan chan error) {})
+	assert.Nil(t, errWithCode)
+	stream.Close()
+	assert.Equal(t, int64(0), atomic.LoadInt64(&body.read))
+}
@@ -31,6 +31,22 @@ func OpenAICheck(w http.ResponseWriter, r *http.Request) bool {
 	return true
 }
 
+func ClaudeCheck(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("x-api-key") != GetTestToken() {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func GeminiCheck(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("x-goog-api-key") != GetTestToken() {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
 func (ts *ServerTest) RegisterHandler(path string, handler handler) {
 	// to make the registered paths friendlier to a regex match in the route handler
 	// in OpenAITestServer
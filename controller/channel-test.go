@@ -47,6 +47,15 @@ func testChannel(channel *model.Channel, request types.ChatCompletionRequest) (e
 
 	request.Model = newModelName
 
+	// 支持健康检查的渠道只发送最小的请求
+	if tester, ok := provider.(providers_base.ChannelTestInterface); ok {
+		result := tester.TestChannel(request.Model)
+		if !result.Success {
+			return errors.New(result.Error.Message), &result.Error.OpenAIError
+		}
+		return nil, nil
+	}
+
 	chatProvider, ok := provider.(providers_base.ChatInterface)
 	if !ok {
 		return errors.New("channel not implemented"), nil
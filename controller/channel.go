@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"one-api/providers/claude"
 	"strconv"
 	"strings"
 
@@ -201,3 +202,12 @@ func BatchDelModelChannels(c *gin.Context) {
 		"message": "更新成功",
 	})
 }
+
+// Claude 渠道的熔断状态
+func GetChannelCircuitBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    claude.GetCircuitBreakerStatus(),
+	})
+}
@@ -1,6 +1,7 @@
 package relay
 
 import (
+	"net/http"
 	"one-api/types"
 
 	providersBase "one-api/providers/base"
@@ -24,6 +25,12 @@ type RelayBaseInterface interface {
 	getOriginalModel() string
 	getModelName() string
 	getContext() *gin.Context
+	isStream() bool
+}
+
+// 透传请求的上游错误响应，需要原样返回给客户端
+type upstreamErrorInterface interface {
+	getUpstreamError() *http.Response
 }
 
 func (r *relayBase) setProvider(modelName string) error {
@@ -51,3 +58,7 @@ func (r *relayBase) getOriginalModel() string {
 func (r *relayBase) getModelName() string {
 	return r.modelName
 }
+
+func (r *relayBase) isStream() bool {
+	return false
+}
@@ -8,6 +8,7 @@ import (
 	"one-api/common/requester"
 	providersBase "one-api/providers/base"
 	"one-api/types"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -37,6 +38,10 @@ func (r *relayChat) setRequest() error {
 	return nil
 }
 
+func (r *relayChat) isStream() bool {
+	return r.chatRequest.Stream
+}
+
 func (r *relayChat) getPromptTokens() (int, error) {
 	return common.CountTokenMessages(r.chatRequest.Messages, r.modelName), nil
 }
@@ -51,6 +56,12 @@ func (r *relayChat) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
 
 	r.chatRequest.Model = r.modelName
 
+	if dryRun, _ := strconv.ParseBool(r.c.GetHeader("x-relay-dry-run")); dryRun {
+		err = r.dryRun(chatProvider)
+		done = err != nil
+		return
+	}
+
 	if r.chatRequest.Stream {
 		var response requester.StreamReaderInterface[string]
 		response, err = chatProvider.CreateChatCompletionStream(&r.chatRequest)
@@ -74,3 +85,21 @@ func (r *relayChat) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
 
 	return
 }
+
+// 只返回转换后的上游请求，不发送也不计费
+func (r *relayChat) dryRun(chatProvider providersBase.ChatInterface) *types.OpenAIErrorWithStatusCode {
+	dryRunProvider, ok := chatProvider.(providersBase.ChatDryRunInterface)
+	if !ok {
+		return common.StringErrorWrapper("channel does not support dry run", "dry_run_not_supported", http.StatusBadRequest)
+	}
+
+	request, errWithCode := dryRunProvider.ConvertChatRequest(&r.chatRequest)
+	if errWithCode != nil {
+		return errWithCode
+	}
+
+	// 用量清零，预扣的额度会被退回
+	*chatProvider.GetUsage() = types.Usage{}
+
+	return responseJsonClient(r.c, request)
+}
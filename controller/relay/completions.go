@@ -37,6 +37,10 @@ func (r *relayCompletions) setRequest() error {
 	return nil
 }
 
+func (r *relayCompletions) isStream() bool {
+	return r.request.Stream
+}
+
 func (r *relayCompletions) getPromptTokens() (int, error) {
 	return common.CountTokenInput(r.request.Prompt, r.modelName), nil
 }
@@ -34,6 +34,7 @@ func Relay(c *gin.Context) {
 
 	channel := relay.getProvider().GetChannel()
 	go processChannelRelayError(c.Request.Context(), channel.Id, channel.Name, apiErr)
+	setFallbackChannels(c, relay.getProvider())
 
 	retryTimes := common.RetryTimes
 	if done || !shouldRetry(c, apiErr.StatusCode) {
@@ -55,21 +56,24 @@ func Relay(c *gin.Context) {
 			return
 		}
 		go processChannelRelayError(c.Request.Context(), channel.Id, channel.Name, apiErr)
+		setFallbackChannels(c, relay.getProvider())
 		if done || !shouldRetry(c, apiErr.StatusCode) {
 			break
 		}
 	}
 
 	if apiErr != nil {
+		if upstream, ok := relay.(upstreamErrorInterface); ok && upstream.getUpstreamError() != nil {
+			responsePassthrough(c, upstream.getUpstreamError(), false)
+			return
+		}
+
 		requestId := c.GetString(common.RequestIdKey)
 		if apiErr.StatusCode == http.StatusTooManyRequests {
 			apiErr.OpenAIError.Message = "当前分组上游负载已饱和，请稍后再试"
 		}
 		apiErr.OpenAIError.Message = common.MessageWithRequestId(apiErr.OpenAIError.Message, requestId)
-		c.JSON(apiErr.StatusCode, gin.H{
-			"error": apiErr.OpenAIError,
-		})
-
+		responseError(c, apiErr, relay.isStream())
 	}
 }
 
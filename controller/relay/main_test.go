@@ -0,0 +1,168 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/common/test"
+	"one-api/model"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// httptest.ResponseRecorder 没有实现 c.Stream 需要的 CloseNotify
+type streamRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r *streamRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+// 启动模拟的 Claude 上游，返回指向它的渠道
+func setupClaudeChannel(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) *model.Channel {
+	server := test.NewTestServer()
+	ts := server.TestServer(func(w http.ResponseWriter, r *http.Request) bool {
+		return test.ClaudeCheck(w, r)
+	})
+	ts.Start()
+	t.Cleanup(ts.Close)
+	server.RegisterHandler("/v1/messages", handler)
+
+	channel := test.GetChannel(common.ChannelTypeAnthropic, ts.URL, "", "", "")
+	return &channel
+}
+
+// 使用内存数据库创建用户、令牌和渠道，返回指定了该渠道的请求上下文
+func setupRelayTest(t *testing.T, channel *model.Channel, path, body string) (*gin.Context, *httptest.ResponseRecorder) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&model.Channel{}, &model.Token{}, &model.User{}, &model.Log{}))
+
+	originalDB, redisEnabled := model.DB, common.RedisEnabled
+	model.DB, common.RedisEnabled = db, false
+	t.Cleanup(func() {
+		model.DB, common.RedisEnabled = originalDB, redisEnabled
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+
+	user := &model.User{Username: "relay", Password: "password", Quota: 100000000, Status: common.UserStatusEnabled}
+	assert.Nil(t, db.Create(user).Error)
+	token := &model.Token{UserId: user.Id, Key: "relay-test", Name: "relay", UnlimitedQuota: true}
+	assert.Nil(t, db.Create(token).Error)
+	channel.Status = common.ChannelStatusEnabled
+	assert.Nil(t, db.Create(channel).Error)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(&streamRecorder{w})
+	c.Request = httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("id", user.Id)
+	c.Set("token_id", token.Id)
+	c.Set("token_name", token.Name)
+	c.Set("group", "default")
+	c.Set("specific_channel_id", channel.Id)
+	return c, w
+}
+
+func TestRelayStreamSetupError(t *testing.T) {
+	requested := false
+	channel := setupClaudeChannel(t, func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	})
+
+	// logprobs 不支持，请求转换失败，不会请求上游
+	c, w := setupRelayTest(t, channel, "/v1/chat/completions", `{"model":"claude-3-5-sonnet-20241022","stream":true,"logprobs":true,"messages":[{"role":"user","content":"Hello!"}]}`)
+	Relay(c)
+
+	assert.False(t, requested)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	streamError := getStreamError(t, w.Body.String())
+	assert.Equal(t, "unsupported_parameter", streamError.Code)
+	assert.Contains(t, streamError.Message, "logprobs")
+}
+
+func TestRelayStreamMidStreamError(t *testing.T) {
+	channel := setupClaudeChannel(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}` + "\n\n"))
+		w.Write([]byte("event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}` + "\n\n"))
+		w.Write([]byte("event: error\n" + `data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}` + "\n\n"))
+	})
+
+	c, w := setupRelayTest(t, channel, "/v1/chat/completions", `{"model":"claude-3-5-sonnet-20241022","stream":true,"messages":[{"role":"user","content":"Hello!"}]}`)
+	Relay(c)
+
+	// 已经返回了内容，错误以 OpenAI 的错误对象追加在流的末尾
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"content":"Hi"`)
+	streamError := getStreamError(t, w.Body.String())
+	assert.Equal(t, "overloaded", streamError.Code)
+	assert.Contains(t, streamError.Message, "Overloaded")
+}
+
+func TestRelayFallbackChannels(t *testing.T) {
+	calls := make(map[int]int)
+	newChannel := func(id, statusCode int, response string) *model.Channel {
+		channel := setupClaudeChannel(t, func(w http.ResponseWriter, r *http.Request) {
+			calls[id]++
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statusCode)
+			w.Write([]byte(response))
+		})
+		channel.Id = id
+		weight := uint(1)
+		channel.Weight = &weight
+		return channel
+	}
+	success := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+
+	primary := newChannel(8731, 529, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	outside := newChannel(8732, http.StatusOK, success)
+	fallback := newChannel(8733, http.StatusOK, success)
+	other := newChannel(8734, http.StatusOK, success)
+	plugin := datatypes.NewJSONType(model.PluginType{
+		"anthropic": {"max_retries": "0", "fallback_channels": "8732, 8733"},
+	})
+	primary.Plugin = &plugin
+
+	// 8732 不在当前分组下，8733 与 8734 优先级相同，重试时按备用渠道的顺序选择 8733
+	model.ChannelGroup.Lock()
+	originalChannels, originalRule := model.ChannelGroup.Channels, model.ChannelGroup.Rule
+	model.ChannelGroup.Channels = map[int]*model.ChannelChoice{}
+	for _, channel := range []*model.Channel{primary, outside, fallback, other} {
+		model.ChannelGroup.Channels[channel.Id] = &model.ChannelChoice{Channel: channel}
+	}
+	model.ChannelGroup.Rule = map[string]map[string][][]int{
+		"default": {"claude-3-5-sonnet-20241022": {{8731}, {8734, 8733}}},
+		"vip":     {"claude-3-5-sonnet-20241022": {{8732}}},
+	}
+	model.ChannelGroup.Unlock()
+	memoryCacheEnabled, retryTimes, retryCooldownSeconds := common.MemoryCacheEnabled, common.RetryTimes, common.RetryCooldownSeconds
+	common.MemoryCacheEnabled, common.RetryTimes, common.RetryCooldownSeconds = true, 1, 60
+	t.Cleanup(func() {
+		model.ChannelGroup.Lock()
+		model.ChannelGroup.Channels, model.ChannelGroup.Rule = originalChannels, originalRule
+		model.ChannelGroup.Unlock()
+		common.MemoryCacheEnabled, common.RetryTimes, common.RetryCooldownSeconds = memoryCacheEnabled, retryTimes, retryCooldownSeconds
+	})
+
+	c, w := setupRelayTest(t, primary, "/v1/chat/completions", `{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":"Hello!"}]}`)
+	c.Set("specific_channel_id", 0)
+	Relay(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Hi!")
+	// 备用渠道占用 relay 的重试次数，不再额外请求
+	assert.Equal(t, map[int]int{8731: 1, 8733: 1}, calls)
+	// 计费和日志记录到实际使用的渠道
+	assert.Equal(t, fallback.Id, c.GetInt("channel_id"))
+}
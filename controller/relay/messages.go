@@ -0,0 +1,114 @@
+package relay
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/common"
+	providersBase "one-api/providers/base"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 原生 Anthropic Messages 请求，只解析路由和计费需要的字段，请求体原样转发
+type messagesRequest struct {
+	Model    string          `json:"model" binding:"required"`
+	Stream   bool            `json:"stream"`
+	System   json.RawMessage `json:"system"`
+	Messages json.RawMessage `json:"messages" binding:"required"`
+	Tools    json.RawMessage `json:"tools"`
+}
+
+type relayMessages struct {
+	relayBase
+	request messagesRequest
+	body    []byte
+
+	// 最后一次上游返回的错误响应，重试失败后原样返回给客户端
+	upstreamError *http.Response
+}
+
+func NewRelayMessages(c *gin.Context) *relayMessages {
+	relay := &relayMessages{}
+	relay.c = c
+	return relay
+}
+
+func (r *relayMessages) isStream() bool {
+	return r.request.Stream
+}
+
+func (r *relayMessages) getUpstreamError() *http.Response {
+	return r.upstreamError
+}
+
+func (r *relayMessages) setRequest() error {
+	if err := common.UnmarshalBodyReusable(r.c, &r.request); err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(r.c.Request.Body)
+	if err != nil {
+		return err
+	}
+	r.body = body
+
+	r.originalModel = r.request.Model
+
+	return nil
+}
+
+// 只用于预扣额度，按原始 JSON 估算，实际用量以上游返回为准
+func (r *relayMessages) getPromptTokens() (int, error) {
+	text := string(r.request.System) + string(r.request.Messages) + string(r.request.Tools)
+	return common.CountTokenText(text, r.modelName), nil
+}
+
+func (r *relayMessages) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
+	provider, ok := r.provider.(providersBase.MessagesPassthroughInterface)
+	if !ok {
+		err = common.StringErrorWrapper("channel not implemented", "channel_error", http.StatusServiceUnavailable)
+		done = true
+		return
+	}
+
+	body, err := r.getBody()
+	if err != nil {
+		done = true
+		return
+	}
+
+	response, err := provider.CreateMessages(body, r.request.Stream)
+	r.upstreamError = nil
+	if err != nil {
+		r.upstreamError = response
+		return
+	}
+	err = responsePassthrough(r.c, response, r.request.Stream)
+
+	if err != nil {
+		done = true
+	}
+
+	return
+}
+
+// 模型被映射时只替换 model 字段，其余内容不变
+func (r *relayMessages) getBody() ([]byte, *types.OpenAIErrorWithStatusCode) {
+	if r.modelName == r.request.Model {
+		return r.body, nil
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(r.body, &body); err != nil {
+		return nil, common.ErrorWrapper(err, "invalid_request_body", http.StatusBadRequest)
+	}
+	body["model"], _ = json.Marshal(r.modelName)
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "marshal_request_body_failed", http.StatusInternalServerError)
+	}
+	return data, nil
+}
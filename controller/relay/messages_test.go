@@ -0,0 +1,35 @@
+package relay
+
+import (
+	"net/http"
+	"one-api/common/test"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayMessagesUpstreamError(t *testing.T) {
+	upstreamError := `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: Field required"}}`
+	channel := setupClaudeChannel(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("request-id", "req_01")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(upstreamError))
+	})
+
+	for _, stream := range []string{"false", "true"} {
+		body := `{"model":"claude-3-5-sonnet-20241022","stream":` + stream + `,"messages":[{"role":"user","content":"Hello!"}]}`
+		c, _ := test.GetContext(http.MethodPost, "/v1/messages", test.RequestJSONConfig(), strings.NewReader(body))
+		relay := NewRelayMessages(c)
+		assert.Nil(t, relay.setRequest())
+		assert.Equal(t, stream == "true", relay.isStream())
+
+		c, w := setupRelayTest(t, channel, "/v1/messages", body)
+		// 流式请求也按上游的状态码和响应体原样返回 Anthropic 格式的错误
+		Relay(c)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, upstreamError, w.Body.String())
+	}
+}
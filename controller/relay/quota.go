@@ -100,7 +100,7 @@ func (q *QuotaInfo) completedQuotaConsumption(usage *types.Usage, tokenName stri
 	completionRatio := q.modelRatio[1] * q.groupRatio
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
-	quota = int(math.Ceil(((float64(promptTokens) * q.ratio) + (float64(completionTokens) * completionRatio))))
+	quota = int(math.Ceil(((getBilledPromptTokens(usage) * q.ratio) + (float64(completionTokens) * completionRatio))))
 	if q.ratio != 0 && quota <= 0 {
 		quota = 1
 	}
@@ -136,6 +136,9 @@ func (q *QuotaInfo) completedQuotaConsumption(usage *types.Usage, tokenName stri
 		}
 
 		logContent := fmt.Sprintf("模型倍率 %s，分组倍率 %.2f", modelRatioStr, q.groupRatio)
+		if usage.CacheReadInputTokens > 0 || usage.CacheCreationInputTokens > 0 {
+			logContent += fmt.Sprintf("，缓存读取 %d (%.2f 倍)，缓存写入 %d (%.2f 倍)", usage.CacheReadInputTokens, types.CacheReadTokensRatio, usage.CacheCreationInputTokens, types.CacheCreationTokensRatio)
+		}
 		model.RecordConsumeLog(ctx, q.userId, q.channelId, promptTokens, completionTokens, q.modelName, tokenName, quota, logContent, requestTime)
 		model.UpdateUserUsedQuotaAndRequestCount(q.userId, quota)
 		model.UpdateChannelUsedQuota(q.channelId, quota)
@@ -144,6 +147,13 @@ func (q *QuotaInfo) completedQuotaConsumption(usage *types.Usage, tokenName stri
 	return nil
 }
 
+// 按缓存倍率折算后的输入 tokens
+func getBilledPromptTokens(usage *types.Usage) float64 {
+	return float64(usage.GetUncachedPromptTokens()) +
+		float64(usage.CacheReadInputTokens)*types.CacheReadTokensRatio +
+		float64(usage.CacheCreationInputTokens)*types.CacheCreationTokensRatio
+}
+
 func (q *QuotaInfo) undo(c *gin.Context) {
 	tokenId := c.GetInt("token_id")
 	if q.HandelStatus {
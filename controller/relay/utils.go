@@ -40,6 +40,8 @@ func Path2Relay(c *gin.Context, path string) RelayBaseInterface {
 		return NewRelayTranscriptions(c)
 	} else if strings.HasPrefix(path, "/v1/audio/translations") {
 		return NewRelayTranslations(c)
+	} else if strings.HasPrefix(path, "/v1/messages") {
+		return NewRelayMessages(c)
 	}
 
 	return nil
@@ -90,6 +92,10 @@ func fetchChannelById(channelId int) (*model.Channel, error) {
 
 func fetchChannelByModel(c *gin.Context, modelName string) (*model.Channel, error) {
 	group := c.GetString("group")
+	if channel := nextFallbackChannel(c, group, modelName); channel != nil {
+		return channel, nil
+	}
+
 	channel, err := model.ChannelGroup.Next(group, modelName)
 	if err != nil {
 		message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", group, modelName)
@@ -103,6 +109,28 @@ func fetchChannelByModel(c *gin.Context, modelName string) (*model.Channel, erro
 	return channel, nil
 }
 
+// 出错的渠道配置了备用渠道时，之后的重试按顺序优先使用
+func setFallbackChannels(c *gin.Context, provider providersBase.ProviderInterface) {
+	fallback, ok := provider.(providersBase.FallbackChannelsInterface)
+	if !ok {
+		return
+	}
+	if channelIds := fallback.GetFallbackChannelIds(); len(channelIds) > 0 {
+		c.Set("fallback_channel_ids", channelIds)
+	}
+}
+
+// 依次取出备用渠道，跳过不属于当前分组和模型、已禁用或冷却中的渠道
+func nextFallbackChannel(c *gin.Context, group, modelName string) (channel *model.Channel) {
+	channelIds, _ := c.Value("fallback_channel_ids").([]int)
+	for channel == nil && len(channelIds) > 0 {
+		channel = model.ChannelGroup.GetSatisfiedChannel(group, modelName, channelIds[0])
+		channelIds = channelIds[1:]
+	}
+	c.Set("fallback_channel_ids", channelIds)
+	return
+}
+
 func responseJsonClient(c *gin.Context, data interface{}) *types.OpenAIErrorWithStatusCode {
 	// 将data转换为 JSON
 	responseBody, err := json.Marshal(data)
@@ -124,18 +152,33 @@ func responseStreamClient(c *gin.Context, stream requester.StreamReaderInterface
 	requester.SetEventStreamHeaders(c)
 	dataChan, errChan := stream.Recv()
 
+	// 支持断点续传时为每条数据添加 id，客户端重连时通过 Last-Event-ID 传回
+	eventId := 0
+	if eventIdStream, ok := stream.(requester.StreamEventIdInterface); ok {
+		eventId = eventIdStream.FirstEventId()
+	}
+
 	defer stream.Close()
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
+			if eventId > 0 {
+				fmt.Fprintf(w, "id: %d\n", eventId)
+				eventId++
+			}
 			fmt.Fprintln(w, "data: "+data+"\n")
 			return true
 		case err := <-errChan:
-			if !errors.Is(err, io.EOF) {
-				fmt.Fprintln(w, "data: "+err.Error()+"\n")
+			if errors.Is(err, io.EOF) {
+				fmt.Fprintln(w, "data: [DONE]")
+				return false
 			}
 
-			fmt.Fprintln(w, "data: [DONE]")
+			openAIError := &types.OpenAIError{}
+			if !errors.As(err, &openAIError) {
+				openAIError = common.ErrorToOpenAIError(err)
+			}
+			writeStreamError(w, openAIError)
 			return false
 		}
 	})
@@ -143,6 +186,33 @@ func responseStreamClient(c *gin.Context, stream requester.StreamReaderInterface
 	return nil
 }
 
+// 返回最终的错误，流式请求以一个 SSE 错误块和 [DONE] 返回，流式客户端才能解析
+func responseError(c *gin.Context, apiErr *types.OpenAIErrorWithStatusCode, stream bool) {
+	if !stream {
+		c.JSON(apiErr.StatusCode, gin.H{
+			"error": apiErr.OpenAIError,
+		})
+		return
+	}
+
+	if !c.Writer.Written() {
+		requester.SetEventStreamHeaders(c)
+		c.Writer.WriteHeader(apiErr.StatusCode)
+	}
+	writeStreamError(c.Writer, &apiErr.OpenAIError)
+	c.Writer.Flush()
+}
+
+// 以 OpenAI 的错误对象发送 SSE 错误块，然后发送 [DONE]
+func writeStreamError(w io.Writer, openAIError *types.OpenAIError) {
+	data, err := json.Marshal(types.OpenAIErrorResponse{Error: *openAIError})
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
 func responseMultipart(c *gin.Context, resp *http.Response) *types.OpenAIErrorWithStatusCode {
 	defer resp.Body.Close()
 
@@ -160,6 +230,35 @@ func responseMultipart(c *gin.Context, resp *http.Response) *types.OpenAIErrorWi
 	return nil
 }
 
+// 原样返回上游响应，每次读取后立即发送给客户端
+func responsePassthrough(c *gin.Context, resp *http.Response, stream bool) *types.OpenAIErrorWithStatusCode {
+	defer resp.Body.Close()
+
+	if stream {
+		requester.SetEventStreamHeaders(c)
+	} else {
+		c.Writer.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	}
+	c.Writer.WriteHeader(resp.StatusCode)
+
+	buffer := make([]byte, 4096)
+	for {
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			if _, err := c.Writer.Write(buffer[:n]); err != nil {
+				return common.ErrorWrapper(err, "write_response_body_failed", http.StatusInternalServerError)
+			}
+			c.Writer.Flush()
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return common.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+		}
+	}
+}
+
 func responseCustom(c *gin.Context, response *types.AudioResponseWrapper) *types.OpenAIErrorWithStatusCode {
 	for k, v := range response.Headers {
 		c.Writer.Header().Set(k, v)
@@ -0,0 +1,106 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/common/test"
+	_ "one-api/common/test/init"
+	"one-api/providers"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 请求转换失败，流式输出还没有开始
+func getStreamSetupError(t *testing.T) *types.OpenAIErrorWithStatusCode {
+	body := `{"model":"claude-3-5-sonnet-20241022","stream":true,"logprobs":true,"messages":[{"role":"user","content":"Hello!"}]}`
+	c, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), strings.NewReader(body))
+
+	relay := NewRelayChat(c)
+	assert.Nil(t, relay.setRequest())
+	assert.True(t, relay.isStream())
+
+	channel := test.GetChannel(common.ChannelTypeAnthropic, "http://127.0.0.1:1", "", "", "")
+	relay.provider = providers.GetProvider(&channel, c)
+	relay.provider.SetUsage(&types.Usage{})
+	relay.modelName = relay.originalModel
+	apiErr, _ := relay.send()
+	assert.NotNil(t, apiErr)
+	return apiErr
+}
+
+// 读取 SSE 中的错误块，最后一个事件必须是 [DONE]
+func getStreamError(t *testing.T, body string) types.OpenAIError {
+	events := strings.Split(strings.TrimSuffix(body, "\n\n"), "\n\n")
+	if !assert.GreaterOrEqual(t, len(events), 2) {
+		return types.OpenAIError{}
+	}
+	assert.Equal(t, "data: [DONE]", events[len(events)-1])
+
+	var chunk types.OpenAIErrorResponse
+	errorEvent := events[len(events)-2]
+	assert.True(t, strings.HasPrefix(errorEvent, "data: "))
+	assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(errorEvent, "data: ")), &chunk))
+	return chunk.Error
+}
+
+func TestResponseStreamErrorOnSetupFailure(t *testing.T) {
+	apiErr := getStreamSetupError(t)
+
+	// 响应头还没有发送时，以错误的状态码返回一个 SSE 错误块
+	c, w := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	responseError(c, apiErr, true)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Len(t, strings.Split(strings.TrimSuffix(w.Body.String(), "\n\n"), "\n\n"), 2)
+	streamError := getStreamError(t, w.Body.String())
+	assert.Equal(t, apiErr.Code, streamError.Code)
+	assert.Equal(t, apiErr.Message, streamError.Message)
+
+	// 非流式请求返回 JSON
+	c, w = test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	responseError(c, apiErr, false)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+	var response types.OpenAIErrorResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, apiErr.Code, response.Error.Code)
+}
+
+// 先返回一条数据，再返回指定的错误
+type errorStream struct {
+	err error
+}
+
+func (s *errorStream) Recv() (<-chan string, <-chan error) {
+	dataChan := make(chan string)
+	errChan := make(chan error)
+	go func() {
+		dataChan <- `{"id":"chatcmpl-1"}`
+		errChan <- s.err
+	}()
+	return dataChan, errChan
+}
+
+func (s *errorStream) Close() {}
+
+func TestResponseStreamClientError(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(&streamRecorder{w})
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	responseStreamClient(c, &errorStream{err: errors.New("connection reset by peer")})
+
+	// 普通错误也转换为 OpenAI 的错误对象，不以纯文本返回
+	assert.True(t, strings.HasPrefix(w.Body.String(), `data: {"id":"chatcmpl-1"}`))
+	streamError := getStreamError(t, w.Body.String())
+	assert.Equal(t, "connection reset by peer", streamError.Message)
+	assert.Equal(t, "one_api_error", streamError.Type)
+}
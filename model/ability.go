@@ -40,6 +40,25 @@ func GetRandomSatisfiedChannel(group string, model string) (*Channel, error) {
 	return &channel, err
 }
 
+// 渠道在分组下启用了该模型时返回渠道
+func GetSatisfiedChannel(group string, model string, channelId int) (*Channel, error) {
+	ability := Ability{}
+	groupCol := "`group`"
+	trueVal := "1"
+	if common.UsingPostgreSQL {
+		groupCol = `"group"`
+		trueVal = "true"
+	}
+
+	err := DB.Where(groupCol+" = ? and model = ? and channel_id = ? and enabled = "+trueVal, group, model, channelId).First(&ability).Error
+	if err != nil {
+		return nil, err
+	}
+	channel := Channel{}
+	err = DB.First(&channel, "id = ?", ability.ChannelId).Error
+	return &channel, err
+}
+
 func GetGroupModels(group string) ([]string, error) {
 	var models []string
 	groupCol := "`group`"
@@ -34,6 +34,47 @@ func (cc *ChannelsChooser) Cooldowns(channelId int) bool {
 	return true
 }
 
+// 渠道冷却到指定的时间，已经冷却到更晚的时间时不变
+func (cc *ChannelsChooser) CooldownsUntil(channelId int, until int64) bool {
+	cc.Lock()
+	defer cc.Unlock()
+	choice, ok := cc.Channels[channelId]
+	if !ok {
+		return false
+	}
+
+	if choice.CooldownsTime < until {
+		choice.CooldownsTime = until
+	}
+	return true
+}
+
+// 渠道在分组下可用于该模型且不在冷却中时返回渠道，用于按指定的顺序选择备用渠道
+func (cc *ChannelsChooser) GetSatisfiedChannel(group, model string, channelId int) *Channel {
+	if !common.MemoryCacheEnabled {
+		channel, err := GetSatisfiedChannel(group, model, channelId)
+		if err != nil {
+			return nil
+		}
+		return channel
+	}
+	cc.RLock()
+	defer cc.RUnlock()
+
+	choice, ok := cc.Channels[channelId]
+	if !ok || choice.CooldownsTime >= time.Now().Unix() {
+		return nil
+	}
+	for _, priority := range cc.Rule[group][model] {
+		for _, id := range priority {
+			if id == channelId {
+				return choice.Channel
+			}
+		}
+	}
+	return nil
+}
+
 func (cc *ChannelsChooser) Balancer(channelIds []int) *Channel {
 	nowTime := time.Now().Unix()
 	totalWeight := 0
@@ -137,7 +137,7 @@ func convertFromChatOpenai(request *types.ChatCompletionRequest) *BaiduChatReque
 		Stream:          request.Stream,
 		TopP:            request.TopP,
 		PenaltyScore:    request.FrequencyPenalty,
-		Stop:            request.Stop,
+		Stop:            request.GetStop(),
 		MaxOutputTokens: request.MaxTokens,
 	}
 
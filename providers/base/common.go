@@ -71,6 +71,11 @@ func (p *BaseProvider) SetUsage(usage *types.Usage) {
 
 func (p *BaseProvider) SetContext(c *gin.Context) {
 	p.Context = c
+
+	// 客户端断开连接时取消上游请求
+	if p.Requester != nil && c != nil && c.Request != nil {
+		p.Requester.SetContext(c.Request.Context())
+	}
 }
 
 func (p *BaseProvider) SetOriginalModel(ModelName string) {
@@ -51,6 +51,37 @@ type ChatInterface interface {
 	CreateChatCompletionStream(request *types.ChatCompletionRequest) (requester.StreamReaderInterface[string], *types.OpenAIErrorWithStatusCode)
 }
 
+// 聊天请求转换接口，用于预览发送给上游的请求
+type ChatDryRunInterface interface {
+	ChatInterface
+	ConvertChatRequest(request *types.ChatCompletionRequest) (any, *types.OpenAIErrorWithStatusCode)
+}
+
+// Anthropic 原生请求透传接口，请求体和响应体都不做转换
+// 上游返回错误时同时返回上游的原始响应，供原样返回给客户端
+type MessagesPassthroughInterface interface {
+	ProviderInterface
+	CreateMessages(body []byte, stream bool) (*http.Response, *types.OpenAIErrorWithStatusCode)
+}
+
+// 渠道健康检查接口，用尽量少的消耗验证渠道是否可用
+type ChannelTestInterface interface {
+	ProviderInterface
+	TestChannel(modelName string) *types.ChannelTestResult
+}
+
+// 备用渠道接口，当前渠道出错时 relay 重试优先按顺序使用这些渠道
+type FallbackChannelsInterface interface {
+	ProviderInterface
+	GetFallbackChannelIds() []int
+}
+
+// 模型列表接口，从上游获取渠道可用的模型
+type ModelListInterface interface {
+	ProviderInterface
+	ListModels() ([]string, *types.OpenAIErrorWithStatusCode)
+}
+
 // 嵌入接口
 type EmbeddingsInterface interface {
 	ProviderInterface
@@ -0,0 +1,138 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"one-api/common"
+	"one-api/types"
+	"strings"
+	"time"
+)
+
+// 渠道的 log_level：info 只记录元数据，debug 额外记录截断后的内容
+const (
+	auditLogLevelInfo  = "info"
+	auditLogLevelDebug = "debug"
+)
+
+// 每条消息记录的最大字符数
+const auditContentMaxLength = 200
+
+// 一次请求的审计日志，图片和文件只记录占位符
+type AuditLogEntry struct {
+	TokenId          int    `json:"token_id"`
+	ChannelId        int    `json:"channel_id"`
+	Model            string `json:"model"`
+	Stream           bool   `json:"stream"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	LatencyMs        int64  `json:"latency_ms"`
+	FinishReason     string `json:"finish_reason,omitempty"`
+	Error            string `json:"error,omitempty"`
+	Prompt           string `json:"prompt,omitempty"`
+	Completion       string `json:"completion,omitempty"`
+}
+
+// 审计日志的输出方式，默认写入系统日志
+var AuditLogger = func(ctx context.Context, entry *AuditLogEntry) {
+	data, _ := json.Marshal(entry)
+	common.LogInfo(ctx, "claude audit: "+string(data))
+}
+
+type chatAuditLog struct {
+	ctx        context.Context
+	start      time.Time
+	debug      bool
+	entry      AuditLogEntry
+	completion strings.Builder
+}
+
+// 渠道未配置 log_level 时返回 nil，之后的调用都不做任何事
+func (p *ClaudeProvider) newAuditLog(request *types.ChatCompletionRequest) *chatAuditLog {
+	level := p.getPluginParam("anthropic", "log_level")
+	if level != auditLogLevelInfo && level != auditLogLevelDebug {
+		return nil
+	}
+
+	audit := &chatAuditLog{
+		ctx:   context.Background(),
+		start: time.Now(),
+		debug: level == auditLogLevelDebug,
+		entry: AuditLogEntry{
+			ChannelId: p.Channel.Id,
+			Model:     request.Model,
+			Stream:    request.Stream,
+		},
+	}
+	// gin.Context 在请求结束后会被复用，流结束时可能已经属于其他请求，只保留需要的字段
+	if p.Context != nil {
+		audit.ctx = p.Context.Request.Context()
+		audit.entry.TokenId = p.Context.GetInt("token_id")
+	}
+	if audit.debug {
+		audit.entry.Prompt = redactPrompt(request.Messages)
+	}
+
+	return audit
+}
+
+func (l *chatAuditLog) appendCompletion(text string) {
+	if l == nil || !l.debug {
+		return
+	}
+	l.completion.WriteString(text)
+}
+
+func (l *chatAuditLog) setFinishReason(finishReason string) {
+	if l == nil || finishReason == "" {
+		return
+	}
+	l.entry.FinishReason = finishReason
+}
+
+func (l *chatAuditLog) finish(usage *types.Usage, errMessage string) {
+	if l == nil {
+		return
+	}
+
+	l.entry.LatencyMs = time.Since(l.start).Milliseconds()
+	if usage != nil {
+		l.entry.PromptTokens = usage.PromptTokens
+		l.entry.CompletionTokens = usage.CompletionTokens
+	}
+	l.entry.Error = errMessage
+	if l.debug {
+		l.entry.Completion = truncateAuditContent(l.completion.String())
+	}
+
+	AuditLogger(l.ctx, &l.entry)
+}
+
+// 只保留文本内容并逐条截断，图片和文件不记录数据
+func redactPrompt(messages []types.ChatCompletionMessage) string {
+	lines := make([]string, 0, len(messages))
+	for _, message := range messages {
+		var parts []string
+		for _, part := range message.ParseContent() {
+			switch part.Type {
+			case types.ContentTypeText:
+				parts = append(parts, part.Text)
+			case types.ContentTypeImageURL:
+				parts = append(parts, "[image]")
+			case types.ContentTypeFile:
+				parts = append(parts, "[file]")
+			}
+		}
+		lines = append(lines, message.Role+": "+truncateAuditContent(strings.Join(parts, " ")))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func truncateAuditContent(content string) string {
+	runes := []rune(content)
+	if len(runes) <= auditContentMaxLength {
+		return content
+	}
+	return string(runes[:auditContentMaxLength]) + "..."
+}
@@ -0,0 +1,107 @@
+package claude_test
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/providers/claude"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 替换审计日志输出，返回收到的日志
+func captureAuditLog(t *testing.T) *[]*claude.AuditLogEntry {
+	entries := &[]*claude.AuditLogEntry{}
+	logger := claude.AuditLogger
+	claude.AuditLogger = func(ctx gocontext.Context, entry *claude.AuditLogEntry) {
+		*entries = append(*entries, entry)
+	}
+	t.Cleanup(func() {
+		claude.AuditLogger = logger
+	})
+
+	return entries
+}
+
+func TestChatCompletionsAuditLog(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	context.Set("token_id", 12)
+	defer teardown()
+
+	entries := captureAuditLog(t)
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"A single pixel."}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":5}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	longText := strings.Repeat("a", 300)
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[
+		{"role":"user","content":[
+			{"type":"text","text":"What is this?"},
+			{"type":"image_url","image_url":{"url":"data:image/png;base64,` + testPNG + `"}}
+		]},
+		{"role":"assistant","content":"` + longText + `"},
+		{"role":"user","content":"Again."}
+	]}`)
+
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"log_level": "debug"}})
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	assert.Len(t, *entries, 1)
+	entry := (*entries)[0]
+	assert.Equal(t, 12, entry.TokenId)
+	assert.Equal(t, channel.Id, entry.ChannelId)
+	assert.Equal(t, "claude-3-opus-20240229", entry.Model)
+	assert.Equal(t, 12, entry.PromptTokens)
+	assert.Equal(t, 5, entry.CompletionTokens)
+	assert.Equal(t, types.FinishReasonStop, entry.FinishReason)
+	assert.Equal(t, "A single pixel.", entry.Completion)
+	assert.Equal(t, "user: What is this? [image]\nassistant: "+strings.Repeat("a", 200)+"...\nuser: Again.", entry.Prompt)
+
+	data, _ := json.Marshal(entry)
+	assert.NotContains(t, string(data), testPNG)
+	assert.NotContains(t, string(data), "base64")
+}
+
+func TestChatCompletionsStreamAuditLog(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	entries := captureAuditLog(t)
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":25,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"!"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":15}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"log_level": "info"}})
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "true"))
+	assert.Nil(t, errWithCode)
+	readChatStream(t, stream)
+
+	assert.Len(t, *entries, 1)
+	entry := (*entries)[0]
+	assert.True(t, entry.Stream)
+	assert.Equal(t, 25, entry.PromptTokens)
+	assert.Equal(t, 15, entry.CompletionTokens)
+	assert.Equal(t, types.FinishReasonStop, entry.FinishReason)
+	// info 级别不记录内容
+	assert.Empty(t, entry.Prompt)
+	assert.Empty(t, entry.Completion)
+}
@@ -3,29 +3,131 @@ package claude
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"one-api/common"
 	"one-api/common/requester"
 	"one-api/model"
 	"one-api/providers/base"
 	"one-api/types"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+const defaultAnthropicVersion = "2023-06-01"
+
+// 返回给客户端的上游请求 ID 响应头
+const upstreamRequestIdHeader = "X-Anthropic-Request-Id"
+
+const (
+	defaultMaxTokens        = 4096
+	minThinkingBudgetTokens = 1024
+)
+
+const (
+	defaultMaxRetries = 2
+	retryBaseDelay    = 500 * time.Millisecond
+	retryMaxDelay     = 30 * time.Second
+)
+
+// Claude 单张图片的限制，字节数为 base64 编码后的长度
+const (
+	defaultMaxImageDimension = 8000
+	defaultMaxImageBytes     = 5 * 1024 * 1024
+	lowDetailImageDimension  = 512
+)
+
+// 同时获取和转换的图片数量上限
+const maxConcurrentImages = 4
+
+// 预估提示 tokens 时图片的计算参数，每条消息额外按 3 tokens 计算，
+// 带有工具时 Claude 额外添加约 346 tokens 的工具使用说明
+const (
+	imageMaxLongEdge          = 1568
+	imageMaxPixels            = 1150000
+	imagePixelsPerToken       = 750
+	maxImageTokens            = 1600
+	tokensPerMessage          = 3
+	toolUseSystemPromptTokens = 346
+)
+
+// n 的默认上限
+const defaultMaxChoices = 4
+
+// reasoning_effort 对应的思考预算
+var reasoningEffortBudgets = map[string]int{
+	"low":    1024,
+	"medium": 8192,
+	"high":   24576,
+}
+
+// 各模型的最大输出 tokens，按前缀匹配，更具体的前缀放在前面
+var modelMaxOutputTokens = []struct {
+	prefix    string
+	maxTokens int
+}{
+	{"claude-opus-4", 32000},
+	{"claude-sonnet-4", 64000},
+	{"claude-3-7-sonnet", 64000},
+	{"claude-3-5-sonnet", 8192},
+	{"claude-3-5-haiku", 8192},
+	{"claude-3", 4096},
+	{"claude-2", 4096},
+	{"claude-instant", 4096},
+}
+
+// 各模型的上下文窗口，按前缀匹配
+var modelContextWindows = []struct {
+	prefix        string
+	contextWindow int
+}{
+	{"claude-opus-4", 200000},
+	{"claude-sonnet-4", 200000},
+	{"claude-3", 200000},
+	{"claude-2.1", 200000},
+	{"claude-2", 100000},
+	{"claude-instant", 100000},
+}
+
+// Claude 支持的图片格式
+var supportedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
 type ClaudeProviderFactory struct{}
 
 // 创建 ClaudeProvider
 func (f ClaudeProviderFactory) Create(channel *model.Channel) base.ProviderInterface {
+	claudeRequester := requester.NewHTTPRequester(*channel.Proxy, requestErrorHandle)
+	claudeRequester.ResponseHandler = func(resp *http.Response) {
+		saveRateLimit(channel.Id, resp.Header)
+	}
+
 	return &ClaudeProvider{
 		BaseProvider: base.BaseProvider{
 			Config:    getConfig(),
 			Channel:   channel,
-			Requester: requester.NewHTTPRequester(*channel.Proxy, requestErrorHandle),
+			Requester: claudeRequester,
 		},
 	}
 }
 
 type ClaudeProvider struct {
 	base.BaseProvider
+
+	// n 个 choice 并发请求时保护 Usage 和响应头
+	lock sync.Mutex
+
+	// 中继估算的提示 tokens，Usage 中的值在发送前会被替换为按 Claude 请求估算的值
+	relayPromptTokens int
+
+	// 渠道健康检查请求，只生成 1 个 token，不受 min_max_tokens 限制
+	healthCheck bool
 }
 
 func getConfig() base.ProviderConfig {
@@ -35,15 +137,47 @@ func getConfig() base.ProviderConfig {
 	}
 }
 
-// 请求错误处理
+// Claude 错误类型对应的状态码和 OpenAI 错误类型
+var claudeErrorTypes = map[string]struct {
+	statusCode int
+	errType    string
+	code       string
+}{
+	"invalid_request_error": {http.StatusBadRequest, "invalid_request_error", "invalid_request_error"},
+	"authentication_error":  {http.StatusUnauthorized, "invalid_request_error", "invalid_api_key"},
+	"permission_error":      {http.StatusForbidden, "invalid_request_error", "permission_denied"},
+	"not_found_error":       {http.StatusNotFound, "invalid_request_error", "not_found"},
+	"request_too_large":     {http.StatusRequestEntityTooLarge, "invalid_request_error", "request_too_large"},
+	"rate_limit_error":      {http.StatusTooManyRequests, "requests", "rate_limit_exceeded"},
+	"api_error":             {http.StatusInternalServerError, "server_error", "api_error"},
+	"overloaded_error":      {529, "server_error", "overloaded"},
+}
+
+// 请求错误处理，错误信息在 error 字段中
 func requestErrorHandle(resp *http.Response) *types.OpenAIError {
-	claudeError := &ClaudeError{}
+	claudeError := &ClaudeErrorResponse{}
 	err := json.NewDecoder(resp.Body).Decode(claudeError)
 	if err != nil {
 		return nil
 	}
 
-	return errorHandle(claudeError)
+	// Bedrock 的错误信息在顶层的 message 中，Vertex 的错误没有 Claude 的错误类型
+	if claudeError.Error.Type == "" {
+		message := claudeError.Message
+		if message == "" {
+			message = claudeError.Error.Message
+		}
+		if message == "" {
+			return nil
+		}
+		return &types.OpenAIError{
+			Message: message,
+			Type:    "upstream_error",
+			Code:    "bad_response_status_code",
+		}
+	}
+
+	return errorHandle(&claudeError.Error)
 }
 
 // 错误处理
@@ -51,11 +185,36 @@ func errorHandle(claudeError *ClaudeError) *types.OpenAIError {
 	if claudeError.Type == "" {
 		return nil
 	}
-	return &types.OpenAIError{
+
+	openaiError := &types.OpenAIError{
 		Message: claudeError.Message,
 		Type:    claudeError.Type,
 		Code:    claudeError.Type,
 	}
+	if errorType, ok := claudeErrorTypes[claudeError.Type]; ok {
+		openaiError.Type = errorType.errType
+		openaiError.Code = errorType.code
+	}
+
+	return openaiError
+}
+
+// 响应体中的错误，按错误类型返回对应的状态码，未知类型返回 400
+func errorHandleWithStatusCode(claudeError *ClaudeError) *types.OpenAIErrorWithStatusCode {
+	openaiError := errorHandle(claudeError)
+	if openaiError == nil {
+		return nil
+	}
+
+	statusCode := http.StatusBadRequest
+	if errorType, ok := claudeErrorTypes[claudeError.Type]; ok {
+		statusCode = errorType.statusCode
+	}
+
+	return &types.OpenAIErrorWithStatusCode{
+		OpenAIError: *openaiError,
+		StatusCode:  statusCode,
+	}
 }
 
 // 获取请求头
@@ -63,19 +222,382 @@ func (p *ClaudeProvider) GetRequestHeaders() (headers map[string]string) {
 	headers = make(map[string]string)
 	p.CommonRequestHeaders(headers)
 
+	// 便于上游排查问题，可自定义 User-Agent，并透传客户端的 x-request-id
+	if userAgent := p.getPluginParam("anthropic", "user_agent"); userAgent != "" {
+		headers["User-Agent"] = userAgent
+	}
+	if requestId := p.Context.Request.Header.Get("x-request-id"); requestId != "" {
+		headers["x-request-id"] = requestId
+	}
+
+	// Bedrock 和 Vertex 使用各自的认证方式，版本和 beta 在请求体中
+	if p.isBedrock() || p.isVertex() {
+		p.addExtraHeaders(headers)
+		return headers
+	}
+
 	headers["x-api-key"] = p.Channel.Key
 	anthropicVersion := p.Context.Request.Header.Get("anthropic-version")
 	if anthropicVersion == "" {
-		anthropicVersion = "2023-06-01"
+		anthropicVersion = p.getPluginParam("anthropic", "version")
+	}
+	if anthropicVersion == "" {
+		anthropicVersion = defaultAnthropicVersion
 	}
 	headers["anthropic-version"] = anthropicVersion
 
+	betas := p.getAnthropicBetas()
+	if len(betas) > 0 {
+		headers["anthropic-beta"] = strings.Join(betas, ",")
+	}
+
+	p.addExtraHeaders(headers)
 	return headers
 }
 
+// 认证相关的请求头不允许客户端透传
+var protectedHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"X-Api-Key":            true,
+	"X-Goog-Api-Key":       true,
+	"X-Amz-Security-Token": true,
+	"Cookie":               true,
+	"Host":                 true,
+	"Content-Length":       true,
+}
+
+// 渠道插件 extra_headers 为逗号分隔的请求头前缀，如 x-feature-，匹配的客户端请求头原样转发
+// 认证头和已经设置的请求头不会被覆盖
+func (p *ClaudeProvider) addExtraHeaders(headers map[string]string) {
+	var prefixes []string
+	for _, prefix := range strings.Split(p.getPluginParam("anthropic", "extra_headers"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixes = append(prefixes, http.CanonicalHeaderKey(prefix))
+		}
+	}
+	if len(prefixes) == 0 {
+		return
+	}
+
+	exists := make(map[string]bool, len(headers))
+	for key := range headers {
+		exists[http.CanonicalHeaderKey(key)] = true
+	}
+
+	for key, values := range p.Context.Request.Header {
+		key = http.CanonicalHeaderKey(key)
+		if len(values) == 0 || exists[key] || protectedHeaders[key] {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				headers[key] = values[0]
+				break
+			}
+		}
+	}
+}
+
+// 合并渠道配置、请求头和请求内容需要的 anthropic-beta
+func (p *ClaudeProvider) getAnthropicBetas(requestBetas ...string) []string {
+	var betas []string
+	exists := make(map[string]bool)
+	values := append([]string{
+		p.getPluginParam("anthropic", "beta"),
+		p.Context.Request.Header.Get("anthropic-beta"),
+	}, requestBetas...)
+	for _, value := range values {
+		for _, beta := range strings.Split(value, ",") {
+			beta = strings.TrimSpace(beta)
+			if beta != "" && !exists[beta] {
+				exists[beta] = true
+				betas = append(betas, beta)
+			}
+		}
+	}
+
+	return betas
+}
+
+// 获取渠道插件中的字符串配置
+func (p *ClaudeProvider) getPluginParam(plugin, param string) string {
+	if p.Channel.Plugin == nil {
+		return ""
+	}
+
+	value, _ := p.Channel.Plugin.Data()[plugin][param].(string)
+	return strings.TrimSpace(value)
+}
+
+// 获取渠道插件中的布尔配置，兼容字符串形式
+func (p *ClaudeProvider) getPluginBoolParam(plugin, param string) bool {
+	if p.Channel.Plugin == nil {
+		return false
+	}
+
+	switch value := p.Channel.Plugin.Data()[plugin][param].(type) {
+	case bool:
+		return value
+	case string:
+		enable, _ := strconv.ParseBool(strings.TrimSpace(value))
+		return enable
+	}
+
+	return false
+}
+
+// 获取渠道插件中的整数配置，未配置或格式错误时返回默认值
+func (p *ClaudeProvider) getPluginIntParam(plugin, param string, defaultValue int) int {
+	if p.Channel.Plugin == nil {
+		return defaultValue
+	}
+
+	switch value := p.Channel.Plugin.Data()[plugin][param].(type) {
+	case float64:
+		return int(value)
+	case string:
+		if number, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			return number
+		}
+	}
+
+	return defaultValue
+}
+
+// 获取渠道配置的超时时间，流式请求配置了空闲超时时不限制总时长
+func (p *ClaudeProvider) getHTTPClient(stream bool) *http.Client {
+	if stream && p.getPluginIntParam("anthropic", "idle_timeout", 0) > 0 {
+		return requester.NewHTTPClientWithTimeout(0)
+	}
+
+	timeout := p.getPluginIntParam("anthropic", "timeout", 0)
+	if timeout > 0 {
+		return requester.NewHTTPClientWithTimeout(time.Duration(timeout) * time.Second)
+	}
+
+	return requester.HTTPClient
+}
+
+// 发送请求，遇到 429、529 和 5xx 时按指数退避重试
+func (p *ClaudeProvider) sendRequestWithRetry(req *http.Request, stream bool) (*http.Response, *types.OpenAIErrorWithStatusCode) {
+	client := p.getHTTPClient(stream)
+	maxRetries := p.getPluginIntParam("anthropic", "max_retries", defaultMaxRetries)
+	for attempt := 0; ; attempt++ {
+		resp, err := requester.DoWithNetworkRetry(client, req)
+		if err != nil {
+			return nil, common.ErrorWrapper(err, "http_request_failed", http.StatusInternalServerError)
+		}
+		p.Requester.HandleResponse(resp)
+		p.setUpstreamRequestId(resp)
+
+		if !p.Requester.IsFailureStatusCode(resp) {
+			if idleTimeout := p.getPluginIntParam("anthropic", "idle_timeout", 0); stream && idleTimeout > 0 {
+				requester.SetIdleTimeout(resp, time.Duration(idleTimeout)*time.Second)
+			}
+			return resp, nil
+		}
+
+		if attempt >= maxRetries || !isRetryableStatusCode(resp.StatusCode) {
+			return nil, requester.HandleErrorResp(resp, p.Requester.ErrorHandler)
+		}
+
+		delay := getRetryDelay(resp, attempt)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		time.Sleep(delay)
+
+		// 请求体已被读取，需要重新生成
+		req.Body, err = req.GetBody()
+		if err != nil {
+			return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+		}
+	}
+}
+
+// 将 Anthropic 返回的 request-id 通过响应头返回给客户端
+func (p *ClaudeProvider) setUpstreamRequestId(resp *http.Response) {
+	if requestId := resp.Header.Get("request-id"); requestId != "" {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		p.Context.Writer.Header().Set(upstreamRequestIdHeader, requestId)
+	}
+}
+
+// 529 为 Anthropic 的 overloaded_error
+func isRetryableStatusCode(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == 529 || statusCode >= http.StatusInternalServerError
+}
+
+// 优先使用 Retry-After，否则按指数退避
+func getRetryDelay(resp *http.Response, attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			delay = time.Duration(seconds) * time.Second
+		} else if retryTime, err := http.ParseTime(retryAfter); err == nil {
+			delay = time.Until(retryTime)
+		}
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	if delay > retryMaxDelay {
+		return retryMaxDelay
+	}
+	return delay
+}
+
+// 获取模型的最大输出 tokens，未知模型返回 0
+func getModelMaxOutputTokens(modelName string) int {
+	for _, limit := range modelMaxOutputTokens {
+		if strings.HasPrefix(modelName, limit.prefix) {
+			return limit.maxTokens
+		}
+	}
+
+	return 0
+}
+
+// 渠道插件 model_alias 为别名到实际模型的 JSON 映射，如 {"claude-best":"claude-3-7-sonnet-20250219"}
+func (p *ClaudeProvider) getModelAliases() map[string]string {
+	aliases := make(map[string]string)
+	value := p.getPluginParam("anthropic", "model_alias")
+	if value == "" {
+		return aliases
+	}
+
+	if err := json.Unmarshal([]byte(value), &aliases); err != nil {
+		common.SysError("invalid claude model_alias: " + err.Error())
+	}
+	return aliases
+}
+
+// 返回别名对应的实际模型，不是别名时原样返回
+func (p *ClaudeProvider) resolveModelAlias(modelName string) string {
+	if target := p.getModelAliases()[modelName]; target != "" {
+		return target
+	}
+	return modelName
+}
+
+type modelDefaults struct {
+	MaxTokens   int      `json:"max_tokens"`
+	Temperature *float64 `json:"temperature"`
+	TopP        *float64 `json:"top_p"`
+}
+
+// 渠道插件 model_defaults 为模型到默认参数的 JSON 映射，如 {"claude-3-opus":{"max_tokens":1024,"temperature":0.5}}
+// 模型名按前缀匹配，多个前缀都匹配时使用最长的，未配置时返回 nil
+func (p *ClaudeProvider) getModelDefaults(modelName string) *modelDefaults {
+	value := p.getPluginParam("anthropic", "model_defaults")
+	if value == "" {
+		return nil
+	}
+
+	var defaults map[string]*modelDefaults
+	if err := json.Unmarshal([]byte(value), &defaults); err != nil {
+		common.SysError("invalid claude model_defaults: " + err.Error())
+		return nil
+	}
+
+	var matched *modelDefaults
+	matchedPrefix := ""
+	for prefix, params := range defaults {
+		if params != nil && strings.HasPrefix(modelName, prefix) && len(prefix) >= len(matchedPrefix) {
+			matched, matchedPrefix = params, prefix
+		}
+	}
+	return matched
+}
+
+// 客户端未指定的参数使用模型的默认值，显式传 0 的 temperature 和 top_p 保持不变
+// 开启扩展思考时 Claude 不允许修改 temperature 和 top_p，不使用默认值
+func (p *ClaudeProvider) applyModelDefaults(claudeRequest *ClaudeRequest) {
+	defaults := p.getModelDefaults(claudeRequest.Model)
+	if defaults == nil {
+		return
+	}
+
+	if claudeRequest.MaxTokens == 0 {
+		claudeRequest.MaxTokens = defaults.MaxTokens
+	}
+	if claudeRequest.Thinking != nil {
+		return
+	}
+	if claudeRequest.Temperature == nil {
+		claudeRequest.Temperature = defaults.Temperature
+	}
+	if claudeRequest.TopP == nil {
+		claudeRequest.TopP = defaults.TopP
+	}
+}
+
+// 请求使用别名时，响应中返回别名
+func (p *ClaudeProvider) getResponseModel(requestModel, responseModel string) string {
+	if responseModel == "" || p.resolveModelAlias(requestModel) != requestModel {
+		return requestModel
+	}
+	return responseModel
+}
+
+// 获取模型的上下文窗口，未知模型返回 0
+func getModelContextWindow(modelName string) int {
+	for _, limit := range modelContextWindows {
+		if strings.HasPrefix(modelName, limit.prefix) {
+			return limit.contextWindow
+		}
+	}
+
+	return 0
+}
+
+// 请求中的 service_tier 优先，其次使用渠道配置
+func (p *ClaudeProvider) getServiceTier(request *types.ChatCompletionRequest) string {
+	serviceTier := request.ServiceTier
+	if serviceTier == "" {
+		serviceTier = p.getPluginParam("anthropic", "service_tier")
+	}
+
+	// 兼容 OpenAI 的取值，无法识别的取值不传给 Claude
+	switch serviceTier {
+	case "auto", "priority":
+		return "auto"
+	case "standard_only", "default":
+		return "standard_only"
+	default:
+		return ""
+	}
+}
+
+// 添加 Warning 响应头，不影响请求结果
+func (p *ClaudeProvider) addWarning(message string) {
+	p.Context.Writer.Header().Add("Warning", fmt.Sprintf(`299 - "%s"`, message))
+}
+
+// 是否开启了提示缓存，通过请求头 x-anthropic-cache 开启
+func (p *ClaudeProvider) isPromptCacheEnabled() bool {
+	enable, _ := strconv.ParseBool(p.Context.Request.Header.Get("x-anthropic-cache"))
+	return enable
+}
+
+// 是否缓存对话历史，通过请求头 x-anthropic-cache-history 开启
+func (p *ClaudeProvider) isHistoryCacheEnabled() bool {
+	enable, _ := strconv.ParseBool(p.Context.Request.Header.Get("x-anthropic-cache-history"))
+	return enable
+}
+
+// 是否开启文档引用，通过请求头 x-anthropic-citations 开启
+func (p *ClaudeProvider) isCitationsEnabled() bool {
+	enable, _ := strconv.ParseBool(p.Context.Request.Header.Get("x-anthropic-citations"))
+	return enable
+}
+
+// 渠道地址可以带路径前缀，如 https://proxy.example.com/anthropic-proxy，以 /v1 结尾时不再重复拼接
 func (p *ClaudeProvider) GetFullRequestURL(requestURL string, modelName string) string {
-	baseURL := strings.TrimSuffix(p.GetBaseURL(), "/")
-	if strings.HasPrefix(baseURL, "https://gateway.ai.cloudflare.com") {
+	baseURL := strings.TrimRight(p.GetBaseURL(), "/")
+	if strings.HasPrefix(baseURL, "https://gateway.ai.cloudflare.com") || strings.HasSuffix(baseURL, "/v1") {
 		requestURL = strings.TrimPrefix(requestURL, "/v1")
 	}
 
@@ -84,10 +606,14 @@ func (p *ClaudeProvider) GetFullRequestURL(requestURL string, modelName string)
 
 func stopReasonClaude2OpenAI(reason string) string {
 	switch reason {
-	case "end_turn":
+	case "end_turn", "stop_sequence":
 		return types.FinishReasonStop
 	case "max_tokens":
 		return types.FinishReasonLength
+	case "tool_use":
+		return types.FinishReasonToolCalls
+	case "refusal":
+		return types.FinishReasonContentFilter
 	default:
 		return reason
 	}
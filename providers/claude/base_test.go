@@ -0,0 +1,392 @@
+package claude_test
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatCompletionsAnthropicHeaders(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	var requestHeader http.Header
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		requestHeader = r.Header.Clone()
+		handleClaudeEndpoint(nil, response)(w, r)
+	})
+
+	chatRequest := test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false")
+
+	// 默认版本
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "2023-06-01", requestHeader.Get("anthropic-version"))
+	assert.Empty(t, requestHeader.Get("anthropic-beta"))
+
+	// 渠道自定义版本和 beta
+	headers := test.RequestJSONConfig()
+	headers["anthropic-beta"] = "pdfs-2024-09-25, prompt-caching-2024-07-31"
+	context, _ = test.GetContext("POST", "/v1/chat/completions", headers, nil)
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {
+			"version": "2024-01-01",
+			"beta":    "prompt-caching-2024-07-31,max-tokens-3-5-sonnet-2024-07-15",
+		},
+	})
+	chatProvider = getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode = chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "2024-01-01", requestHeader.Get("anthropic-version"))
+	assert.Equal(t, "prompt-caching-2024-07-31,max-tokens-3-5-sonnet-2024-07-15,pdfs-2024-09-25", requestHeader.Get("anthropic-beta"))
+}
+
+func TestChatCompletionsRequestIdHeaders(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	var requestHeader http.Header
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		requestHeader = r.Header.Clone()
+		w.Header().Set("request-id", "req_018EeWyXxfu5pfWkrYcMdjWG")
+		handleClaudeEndpoint(nil, response)(w, r)
+	})
+
+	headers := test.RequestJSONConfig()
+	headers["x-request-id"] = "client-request-1"
+	context, writer := test.GetContext("POST", "/v1/chat/completions", headers, nil)
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"user_agent": "one-api/claude-test"},
+	})
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false"))
+	assert.Nil(t, errWithCode)
+
+	assert.Equal(t, "one-api/claude-test", requestHeader.Get("User-Agent"))
+	assert.Equal(t, "client-request-1", requestHeader.Get("x-request-id"))
+	assert.Equal(t, "req_018EeWyXxfu5pfWkrYcMdjWG", writer.Header().Get("X-Anthropic-Request-Id"))
+
+	// 未配置时使用默认 User-Agent，不添加 x-request-id
+	context, _ = test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	channel = getClaudeChannel(url)
+	chatProvider = getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode = chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false"))
+	assert.Nil(t, errWithCode)
+	assert.NotEqual(t, "one-api/claude-test", requestHeader.Get("User-Agent"))
+	assert.Empty(t, requestHeader.Get("x-request-id"))
+}
+
+func TestChatCompletionsExtraHeaders(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	var requestHeader http.Header
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		requestHeader = r.Header.Clone()
+		handleClaudeEndpoint(nil, response)(w, r)
+	})
+
+	headers := test.RequestJSONConfig()
+	headers["x-feature-flag"] = "new-tokenizer"
+	headers["X-Feature-Region"] = "eu"
+	headers["x-other"] = "dropped"
+	headers["x-api-key"] = "client-key"
+	headers["Authorization"] = "Bearer client-token"
+	headers["anthropic-version"] = "2024-01-01"
+	context, _ := test.GetContext("POST", "/v1/chat/completions", headers, nil)
+
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"extra_headers": "x-feature-, x-api-, authorization, anthropic-"},
+	})
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false"))
+	assert.Nil(t, errWithCode)
+
+	assert.Equal(t, "new-tokenizer", requestHeader.Get("x-feature-flag"))
+	assert.Equal(t, "eu", requestHeader.Get("x-feature-region"))
+	assert.Empty(t, requestHeader.Get("x-other"))
+	// 认证头即使匹配前缀也不转发
+	assert.Equal(t, test.GetTestToken(), requestHeader.Get("x-api-key"))
+	assert.Empty(t, requestHeader.Get("Authorization"))
+	assert.Equal(t, []string{"2024-01-01"}, requestHeader.Values("anthropic-version"))
+
+	// 未配置时不转发
+	context, _ = test.GetContext("POST", "/v1/chat/completions", headers, nil)
+	channel = getClaudeChannel(url)
+	chatProvider = getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode = chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false"))
+	assert.Nil(t, errWithCode)
+	assert.Empty(t, requestHeader.Get("x-feature-flag"))
+}
+
+func TestChatCompletionsBaseURLPrefix(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	var requestURI string
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.RequestURI
+		handleClaudeEndpoint(nil, response)(w, r)
+	}
+	server.RegisterHandler("/v1/messages", handler)
+	server.RegisterHandler("/anthropic-proxy/v1/messages", handler)
+
+	tests := map[string]string{
+		"":                     "/v1/messages",
+		"/":                    "/v1/messages",
+		"/anthropic-proxy":     "/anthropic-proxy/v1/messages",
+		"/anthropic-proxy/":    "/anthropic-proxy/v1/messages",
+		"/anthropic-proxy//":   "/anthropic-proxy/v1/messages",
+		"/anthropic-proxy/v1":  "/anthropic-proxy/v1/messages",
+		"/anthropic-proxy/v1/": "/anthropic-proxy/v1/messages",
+	}
+	for suffix, expected := range tests {
+		requestURI = ""
+		channel := getClaudeChannel(url + suffix)
+		chatProvider := getChatProvider(&channel, context)
+		chatProvider.SetUsage(&types.Usage{})
+		_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "false"))
+		assert.Nil(t, errWithCode, suffix)
+		assert.Equal(t, expected, requestURI, suffix)
+	}
+}
+
+func handleClaudeFailures(calls *int, failures []int, response string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Header().Set("Content-Type", "application/json")
+		if *calls <= len(failures) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(failures[*calls-1])
+			fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+			return
+		}
+		fmt.Fprint(w, response)
+	}
+}
+
+func TestChatCompletionsRetry(t *testing.T) {
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+
+	tests := []struct {
+		name          string
+		maxRetries    string
+		failures      []int
+		expectedCalls int
+		statusCode    int
+	}{
+		{"overloaded then success", "", []int{529}, 2, http.StatusOK},
+		{"rate limited and server error", "", []int{http.StatusTooManyRequests, http.StatusInternalServerError}, 3, http.StatusOK},
+		{"retries exhausted", "1", []int{529, 529}, 2, 529},
+		{"retry disabled", "0", []int{529}, 1, 529},
+		{"bad request fails fast", "", []int{http.StatusBadRequest}, 1, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			calls := 0
+			server.RegisterHandler("/v1/messages", handleClaudeFailures(&calls, tt.failures, response))
+
+			channel := getClaudeChannel(url)
+			if tt.maxRetries != "" {
+				setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"max_retries": tt.maxRetries}})
+			}
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}]}`)
+			openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+			assert.Equal(t, tt.expectedCalls, calls)
+			if tt.statusCode != http.StatusOK {
+				assert.NotNil(t, errWithCode)
+				assert.Equal(t, tt.statusCode, errWithCode.StatusCode)
+				return
+			}
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, "Hi!", openaiResponse.Choices[0].Message.Content)
+		})
+	}
+}
+
+func TestChatCompletionsStreamRetry(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi!"}}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":3}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	streamHandler := handleClaudeStreamEndpoint(nil, events)
+	calls := 0
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(529)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+			return
+		}
+		streamHandler(w, r)
+	})
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	content := ""
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			content += choice.Delta.Content
+		}
+	}
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "Hi!", content)
+}
+
+func TestChatCompletionsErrorTypes(t *testing.T) {
+	tests := []struct {
+		errType    string
+		statusCode int
+		openaiType string
+		code       string
+	}{
+		{"invalid_request_error", http.StatusBadRequest, "invalid_request_error", "invalid_request_error"},
+		{"authentication_error", http.StatusUnauthorized, "invalid_request_error", "invalid_api_key"},
+		{"permission_error", http.StatusForbidden, "invalid_request_error", "permission_denied"},
+		{"not_found_error", http.StatusNotFound, "invalid_request_error", "not_found"},
+		{"request_too_large", http.StatusRequestEntityTooLarge, "invalid_request_error", "request_too_large"},
+		{"rate_limit_error", http.StatusTooManyRequests, "requests", "rate_limit_exceeded"},
+		{"api_error", http.StatusInternalServerError, "server_error", "api_error"},
+		{"overloaded_error", 529, "server_error", "overloaded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.errType, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			calls := 0
+			response := fmt.Sprintf(`{"type":"error","error":{"type":"%s","message":"something went wrong"}}`, tt.errType)
+			server.RegisterHandler("/v1/messages", handleClaudeStatus(&calls, tt.statusCode, response))
+
+			channel := getClaudeChannel(url)
+			setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"max_retries": "0"}})
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}]}`)
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			assert.NotNil(t, errWithCode)
+			assert.Equal(t, tt.statusCode, errWithCode.StatusCode)
+			assert.Equal(t, tt.openaiType, errWithCode.Type)
+			assert.Equal(t, tt.code, errWithCode.Code)
+			assert.Contains(t, errWithCode.Message, "something went wrong")
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
+
+func TestChatCompletionsServiceTier(t *testing.T) {
+	tests := []struct {
+		name          string
+		pluginTier    string
+		requestParams string
+		expectedTier  any
+	}{
+		{"from request", "", `,"service_tier":"auto"`, "auto"},
+		{"openai default", "", `,"service_tier":"default"`, "standard_only"},
+		{"from channel", "standard_only", "", "standard_only"},
+		{"request overrides channel", "standard_only", `,"service_tier":"auto"`, "auto"},
+		{"unknown tier", "", `,"service_tier":"flex"`, nil},
+		{"not set", "", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3,"service_tier":"priority"}}`
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			channel := getClaudeChannel(url)
+			if tt.pluginTier != "" {
+				setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"service_tier": tt.pluginTier}})
+			}
+			chatProvider := getChatProvider(&channel, context)
+			usage := &types.Usage{}
+			chatProvider.SetUsage(usage)
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}]` + tt.requestParams + `}`)
+			openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, tt.expectedTier, requestBody["service_tier"])
+			assert.Equal(t, "priority", openaiResponse.ServiceTier)
+			assert.Equal(t, "priority", usage.ServiceTier)
+		})
+	}
+}
+
+func TestChatCompletionsStreamServiceTier(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1,"service_tier":"standard"}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi!"}}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":3}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+	readChatStream(t, stream)
+
+	assert.Equal(t, "standard", usage.ServiceTier)
+}
@@ -0,0 +1,153 @@
+package claude
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/types"
+)
+
+const batchesURL = "/v1/messages/batches"
+
+// 批量请求项，custom_id 用于在结果中找到对应的请求
+type BatchChatRequest struct {
+	CustomId string
+	Request  *types.ChatCompletionRequest
+}
+
+// 批量请求结果，成功时 Response 不为空，否则 Error 不为空
+type BatchChatResult struct {
+	CustomId string
+	Response *types.ChatCompletionResponse
+	Error    *types.OpenAIError
+}
+
+// 提交批量请求，批量请求不支持流式
+func (p *ClaudeProvider) CreateBatch(requests []BatchChatRequest) (*ClaudeBatch, *types.OpenAIErrorWithStatusCode) {
+	batchRequest := &ClaudeBatchRequest{
+		Requests: make([]ClaudeBatchRequestItem, 0, len(requests)),
+	}
+	for _, item := range requests {
+		request := *item.Request
+		request.Stream = false
+		claudeRequest, errWithCode := p.convertFromChatOpenai(&request)
+		if errWithCode != nil {
+			return nil, errWithCode
+		}
+		batchRequest.Requests = append(batchRequest.Requests, ClaudeBatchRequestItem{
+			CustomId: item.CustomId,
+			Params:   claudeRequest,
+		})
+	}
+
+	return p.sendBatchRequest(http.MethodPost, batchesURL, batchRequest)
+}
+
+// 查询批量请求的处理状态
+func (p *ClaudeProvider) RetrieveBatch(batchId string) (*ClaudeBatch, *types.OpenAIErrorWithStatusCode) {
+	return p.sendBatchRequest(http.MethodGet, fmt.Sprintf("%s/%s", batchesURL, batchId), nil)
+}
+
+// 获取批量请求的结果，requests 为提交时的请求，用于还原模型名称等信息
+func (p *ClaudeProvider) GetBatchResults(batchId string, requests []BatchChatRequest) ([]BatchChatResult, *types.OpenAIErrorWithStatusCode) {
+	fullRequestURL := p.GetFullRequestURL(fmt.Sprintf("%s/%s/results", batchesURL, batchId), "")
+	headers := p.GetRequestHeaders()
+
+	req, err := p.Requester.NewRequest(http.MethodGet, fullRequestURL, p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+
+	resp, errWithCode := p.Requester.SendRequestRaw(req)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	defer resp.Body.Close()
+
+	requestMap := make(map[string]*types.ChatCompletionRequest, len(requests))
+	for _, item := range requests {
+		requestMap[item.CustomId] = item.Request
+	}
+
+	// 批量结果按请求累计用量
+	totalUsage := &types.Usage{}
+	if p.Usage == nil {
+		p.Usage = &types.Usage{}
+	}
+
+	var results []BatchChatResult
+	// 结果为 JSONL 格式，单行可能很长
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 32*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		batchResult := &ClaudeBatchResult{}
+		if err := json.Unmarshal(line, batchResult); err != nil {
+			return nil, common.ErrorWrapper(err, "decode_response_failed", http.StatusInternalServerError)
+		}
+
+		result := BatchChatResult{CustomId: batchResult.CustomId}
+		switch {
+		case batchResult.Result.Type == "succeeded" && batchResult.Result.Message != nil:
+			request, ok := requestMap[batchResult.CustomId]
+			if !ok {
+				request = &types.ChatCompletionRequest{Model: batchResult.Result.Message.Model}
+			}
+			response, errWithCode := p.convertToChatOpenai(batchResult.Result.Message, request)
+			if errWithCode != nil {
+				result.Error = &errWithCode.OpenAIError
+				break
+			}
+			result.Response = response
+			totalUsage.PromptTokens += response.Usage.PromptTokens
+			totalUsage.CompletionTokens += response.Usage.CompletionTokens
+			totalUsage.TotalTokens += response.Usage.TotalTokens
+		case batchResult.Result.Error != nil && batchResult.Result.Error.Error.Type != "":
+			result.Error = errorHandle(&batchResult.Result.Error.Error)
+		default:
+			// canceled 或 expired
+			result.Error = &types.OpenAIError{
+				Message: fmt.Sprintf("batch request %s", batchResult.Result.Type),
+				Type:    batchResult.Result.Type,
+				Code:    batchResult.Result.Type,
+			}
+		}
+		results = append(results, result)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, common.ErrorWrapper(err, "read_response_failed", http.StatusInternalServerError)
+	}
+
+	*p.Usage = *totalUsage
+
+	return results, nil
+}
+
+func (p *ClaudeProvider) sendBatchRequest(method, url string, body any) (*ClaudeBatch, *types.OpenAIErrorWithStatusCode) {
+	fullRequestURL := p.GetFullRequestURL(url, "")
+	headers := p.GetRequestHeaders()
+
+	req, err := p.Requester.NewRequest(method, fullRequestURL, p.Requester.WithBody(body), p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+
+	batch := &ClaudeBatch{}
+	_, errWithCode := p.Requester.SendRequest(req, batch, false)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	if errWithCode := errorHandleWithStatusCode(&batch.Error); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	return batch, nil
+}
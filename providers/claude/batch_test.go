@@ -0,0 +1,101 @@
+package claude_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common/test"
+	_ "one-api/common/test/init"
+	"one-api/providers"
+	"one-api/providers/claude"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchLifecycle(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	server.RegisterHandler("/v1/messages/batches", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		json.NewDecoder(r.Body).Decode(&requestBody)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"msgbatch_01","type":"message_batch","processing_status":"in_progress","request_counts":{"processing":3,"succeeded":0,"errored":0,"canceled":0,"expired":0},"created_at":"2024-09-24T18:37:24.100435Z","expires_at":"2024-09-25T18:37:24.100435Z"}`)
+	})
+
+	polls := 0
+	server.RegisterHandler("/v1/messages/batches/msgbatch_01", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		polls++
+		w.Header().Set("Content-Type", "application/json")
+		if polls == 1 {
+			fmt.Fprint(w, `{"id":"msgbatch_01","type":"message_batch","processing_status":"in_progress","request_counts":{"processing":3,"succeeded":0,"errored":0,"canceled":0,"expired":0},"created_at":"2024-09-24T18:37:24.100435Z","expires_at":"2024-09-25T18:37:24.100435Z"}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"msgbatch_01","type":"message_batch","processing_status":"ended","request_counts":{"processing":0,"succeeded":1,"errored":1,"canceled":0,"expired":1},"created_at":"2024-09-24T18:37:24.100435Z","ended_at":"2024-09-24T18:40:24.100435Z","expires_at":"2024-09-25T18:37:24.100435Z","results_url":"https://api.anthropic.com/v1/messages/batches/msgbatch_01/results"}`)
+	})
+
+	server.RegisterHandler("/v1/messages/batches/msgbatch_01/results", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/binary")
+		fmt.Fprintln(w, `{"custom_id":"req-1","result":{"type":"succeeded","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hello!"}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":2}}}}`)
+		fmt.Fprintln(w, `{"custom_id":"req-2","result":{"type":"errored","error":{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: Field required"}}}}`)
+		fmt.Fprintln(w, `{"custom_id":"req-3","result":{"type":"expired"}}`)
+	})
+
+	channel := getClaudeChannel(url)
+	provider, ok := providers.GetProvider(&channel, context).(*claude.ClaudeProvider)
+	assert.True(t, ok)
+	usage := &types.Usage{}
+	provider.SetUsage(usage)
+
+	requests := []claude.BatchChatRequest{
+		{CustomId: "req-1", Request: getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":"Hello!"}],"stream":true}`)},
+		{CustomId: "req-2", Request: getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":"Hi!"}]}`)},
+		{CustomId: "req-3", Request: getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":"Hey!"}]}`)},
+	}
+
+	batch, errWithCode := provider.CreateBatch(requests)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "msgbatch_01", batch.Id)
+	assert.Equal(t, "in_progress", batch.ProcessingStatus)
+
+	items := requestBody["requests"].([]any)
+	assert.Len(t, items, 3)
+	first := items[0].(map[string]any)
+	assert.Equal(t, "req-1", first["custom_id"])
+	params := first["params"].(map[string]any)
+	assert.Equal(t, "claude-3-5-sonnet-20241022", params["model"])
+	assert.NotContains(t, params, "stream")
+
+	batch, errWithCode = provider.RetrieveBatch(batch.Id)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "in_progress", batch.ProcessingStatus)
+
+	batch, errWithCode = provider.RetrieveBatch(batch.Id)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "ended", batch.ProcessingStatus)
+	assert.Equal(t, 1, batch.RequestCounts.Succeeded)
+
+	results, errWithCode := provider.GetBatchResults(batch.Id, requests)
+	assert.Nil(t, errWithCode)
+	assert.Len(t, results, 3)
+
+	assert.Equal(t, "req-1", results[0].CustomId)
+	assert.Nil(t, results[0].Error)
+	assert.Equal(t, "Hello!", results[0].Response.Choices[0].Message.Content)
+	assert.Equal(t, "claude-3-5-sonnet-20241022", results[0].Response.Model)
+
+	assert.Equal(t, "req-2", results[1].CustomId)
+	assert.Nil(t, results[1].Response)
+	assert.Equal(t, "invalid_request_error", results[1].Error.Type)
+
+	assert.Equal(t, "req-3", results[2].CustomId)
+	assert.Equal(t, "expired", results[2].Error.Type)
+
+	assert.Equal(t, 10, usage.PromptTokens)
+	assert.Equal(t, 2, usage.CompletionTokens)
+}
@@ -0,0 +1,266 @@
+package claude
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/common/requester"
+	"one-api/types"
+	"strings"
+	"time"
+)
+
+const (
+	platformBedrock         = "bedrock"
+	bedrockAnthropicVersion = "bedrock-2023-05-31"
+	defaultBedrockRegion    = "us-east-1"
+)
+
+// Bedrock 流中的异常类型对应的 Claude 错误类型
+var bedrockExceptionTypes = map[string]string{
+	"throttlingException":         "rate_limit_error",
+	"validationException":         "invalid_request_error",
+	"serviceUnavailableException": "overloaded_error",
+}
+
+// 渠道插件 platform 为 bedrock 时通过 AWS Bedrock 调用 Claude
+func (p *ClaudeProvider) isBedrock() bool {
+	return p.getPluginParam("anthropic", "platform") == platformBedrock
+}
+
+func (p *ClaudeProvider) getBedrockRegion() string {
+	region := p.getPluginParam("anthropic", "region")
+	if region == "" {
+		return defaultBedrockRegion
+	}
+	return region
+}
+
+// 渠道密钥格式为 AccessKeyId|SecretAccessKey，使用临时凭证时为 AccessKeyId|SecretAccessKey|SessionToken
+func (p *ClaudeProvider) getBedrockCredentials() (requester.AWSCredentials, *types.OpenAIErrorWithStatusCode) {
+	parts := strings.Split(p.Channel.Key, "|")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return requester.AWSCredentials{}, common.StringErrorWrapper("invalid bedrock key, expected AccessKeyId|SecretAccessKey[|SessionToken]", "invalid_claude_config", http.StatusInternalServerError)
+	}
+
+	credentials := requester.AWSCredentials{
+		AccessKeyId:     parts[0],
+		SecretAccessKey: parts[1],
+	}
+	if len(parts) == 3 {
+		credentials.SessionToken = parts[2]
+	}
+	return credentials, nil
+}
+
+// 模型 id 中的冒号需要编码，与 AWS SDK 保持一致
+func (p *ClaudeProvider) getBedrockURL(modelName string, stream bool) string {
+	baseURL := p.Channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", p.getBedrockRegion())
+	}
+
+	action := "invoke"
+	if stream {
+		action = "invoke-with-response-stream"
+	}
+
+	modelId := strings.ReplaceAll(url.PathEscape(modelName), ":", "%3A")
+	return fmt.Sprintf("%s/model/%s/%s", strings.TrimSuffix(baseURL, "/"), modelId, action)
+}
+
+// Bedrock 的请求体不包含 model 和 stream，版本和 beta 放在请求体中，请求使用 SigV4 签名
+func (p *ClaudeProvider) newBedrockRequest(claudeRequest *ClaudeRequest, stream bool) (*http.Request, *types.OpenAIErrorWithStatusCode) {
+	credentials, errWithCode := p.getBedrockCredentials()
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	fullRequestURL := p.getBedrockURL(claudeRequest.Model, stream)
+	bedrockRequest := *claudeRequest
+	bedrockRequest.Model = ""
+	bedrockRequest.Stream = false
+	bedrockRequest.AnthropicVersion = bedrockAnthropicVersion
+	bedrockRequest.AnthropicBeta = p.getAnthropicBetas(claudeRequest.betas...)
+
+	body, err := json.Marshal(bedrockRequest)
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "marshal_request_failed", http.StatusInternalServerError)
+	}
+
+	headers := p.GetRequestHeaders()
+	headers["Accept"] = "application/json"
+	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(bytes.NewReader(body)), p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+
+	requester.SignAWSRequest(req, body, credentials, p.getBedrockRegion(), "bedrock", time.Now())
+
+	return req, nil
+}
+
+// Bedrock 的流式响应为 AWS event stream 二进制格式，转换为 Claude 的 SSE 格式后复用流处理
+type bedrockStreamBody struct {
+	body   io.ReadCloser
+	buffer bytes.Buffer
+}
+
+func newBedrockStreamBody(body io.ReadCloser) io.ReadCloser {
+	return &bedrockStreamBody{body: body}
+}
+
+func (b *bedrockStreamBody) Read(p []byte) (int, error) {
+	for b.buffer.Len() == 0 {
+		data, err := readBedrockEvent(b.body)
+		if err != nil {
+			return 0, err
+		}
+		if data != nil {
+			b.buffer.WriteString("data: ")
+			b.buffer.Write(data)
+			b.buffer.WriteString("\n\n")
+		}
+	}
+
+	return b.buffer.Read(p)
+}
+
+func (b *bedrockStreamBody) Close() error {
+	return b.body.Close()
+}
+
+// 读取一条 event stream 消息，返回其中的 Claude 事件，无需处理的消息返回 nil
+func readBedrockEvent(reader io.Reader) ([]byte, error) {
+	// 前 12 字节为总长度、头部长度和前 12 字节的 CRC
+	prelude := make([]byte, 12)
+	if _, err := io.ReadFull(reader, prelude); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:]) {
+		return nil, errors.New("bedrock event stream prelude checksum mismatch")
+	}
+
+	totalLength := binary.BigEndian.Uint32(prelude[:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+	if totalLength < 16 || headersLength > totalLength-16 {
+		return nil, errors.New("bedrock event stream message length is invalid")
+	}
+
+	message := make([]byte, totalLength-12)
+	if _, err := io.ReadFull(reader, message); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	checksum := crc32.NewIEEE()
+	checksum.Write(prelude)
+	checksum.Write(message[:len(message)-4])
+	if checksum.Sum32() != binary.BigEndian.Uint32(message[len(message)-4:]) {
+		return nil, errors.New("bedrock event stream message checksum mismatch")
+	}
+
+	headers, err := parseBedrockEventHeaders(message[:headersLength])
+	if err != nil {
+		return nil, err
+	}
+	payload := message[headersLength : len(message)-4]
+
+	switch headers[":message-type"] {
+	case "event":
+		if headers[":event-type"] != "chunk" {
+			return nil, nil
+		}
+		var chunk struct {
+			Bytes string `json:"bytes"`
+		}
+		if err := json.Unmarshal(payload, &chunk); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(chunk.Bytes)
+
+	case "exception", "error":
+		errorType := headers[":exception-type"]
+		if errorType == "" {
+			errorType = headers[":error-code"]
+		}
+		var exception struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(payload, &exception)
+		if exception.Message == "" {
+			exception.Message = headers[":error-message"]
+		}
+
+		claudeErrorType, ok := bedrockExceptionTypes[errorType]
+		if !ok {
+			claudeErrorType = "api_error"
+		}
+		return json.Marshal(ClaudeStreamResponse{
+			Type: "error",
+			Error: ClaudeError{
+				Type:    claudeErrorType,
+				Message: fmt.Sprintf("%s: %s", errorType, exception.Message),
+			},
+		})
+	}
+
+	return nil, nil
+}
+
+// 只解析字符串类型的值，其他类型跳过
+func parseBedrockEventHeaders(data []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	invalid := errors.New("bedrock event stream headers are invalid")
+
+	for len(data) > 0 {
+		nameLength := int(data[0])
+		if len(data) < 1+nameLength+1 {
+			return nil, invalid
+		}
+		name := string(data[1 : 1+nameLength])
+		valueType := data[1+nameLength]
+		data = data[2+nameLength:]
+
+		var valueLength int
+		switch valueType {
+		case 0, 1:
+			valueLength = 0
+		case 2:
+			valueLength = 1
+		case 3:
+			valueLength = 2
+		case 4:
+			valueLength = 4
+		case 5, 8:
+			valueLength = 8
+		case 9:
+			valueLength = 16
+		case 6, 7:
+			if len(data) < 2 {
+				return nil, invalid
+			}
+			valueLength = int(binary.BigEndian.Uint16(data))
+			data = data[2:]
+		default:
+			return nil, invalid
+		}
+
+		if len(data) < valueLength {
+			return nil, invalid
+		}
+		if valueType == 7 {
+			headers[name] = string(data[:valueLength])
+		}
+		data = data[valueLength:]
+	}
+
+	return headers, nil
+}
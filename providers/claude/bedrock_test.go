@@ -0,0 +1,227 @@
+package claude_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"one-api/common/requester"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const bedrockModel = "anthropic.claude-3-5-sonnet-20241022-v2:0"
+
+// Bedrock 使用签名认证，测试服务器不检查 x-api-key
+func setupBedrockTestServer() (baseUrl string, server *test.ServerTest, teardown func()) {
+	server = test.NewTestServer()
+	ts := server.TestServer(nil)
+	ts.Start()
+
+	return ts.URL, server, ts.Close
+}
+
+func getBedrockChannel(baseUrl string) model.Channel {
+	channel := getClaudeChannel(baseUrl)
+	channel.Key = "AKIDEXAMPLE|wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"platform": "bedrock", "region": "us-west-2"},
+	})
+	return channel
+}
+
+// 编码一条 AWS event stream 消息，headers 的值都为字符串
+func encodeBedrockMessage(headers map[string]string, payload []byte) []byte {
+	var headerBytes bytes.Buffer
+	for name, value := range headers {
+		headerBytes.WriteByte(byte(len(name)))
+		headerBytes.WriteString(name)
+		headerBytes.WriteByte(7)
+		binary.Write(&headerBytes, binary.BigEndian, uint16(len(value)))
+		headerBytes.WriteString(value)
+	}
+
+	var message bytes.Buffer
+	binary.Write(&message, binary.BigEndian, uint32(16+headerBytes.Len()+len(payload)))
+	binary.Write(&message, binary.BigEndian, uint32(headerBytes.Len()))
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+	message.Write(headerBytes.Bytes())
+	message.Write(payload)
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+
+	return message.Bytes()
+}
+
+func encodeBedrockChunk(event string) []byte {
+	payload, _ := json.Marshal(map[string]string{"bytes": base64.StdEncoding.EncodeToString([]byte(event))})
+	return encodeBedrockMessage(map[string]string{
+		":message-type": "event",
+		":event-type":   "chunk",
+		":content-type": "application/json",
+	}, payload)
+}
+
+func TestBedrockChatCompletions(t *testing.T) {
+	url, server, teardown := setupBedrockTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	var requestURI string
+	var requestHeader http.Header
+	var requestBody []byte
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/model/"+bedrockModel+"/invoke", func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.RequestURI
+		requestHeader = r.Header.Clone()
+		requestBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, response)
+	})
+
+	channel := getBedrockChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", bedrockModel, "false"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hi!", openaiResponse.Choices[0].Message.Content)
+
+	// 请求体不包含 model 和 stream
+	assert.Equal(t, "/model/anthropic.claude-3-5-sonnet-20241022-v2%3A0/invoke", requestURI)
+	body := map[string]any{}
+	assert.Nil(t, json.Unmarshal(requestBody, &body))
+	assert.Equal(t, "bedrock-2023-05-31", body["anthropic_version"])
+	assert.NotContains(t, body, "model")
+	assert.NotContains(t, body, "stream")
+	assert.Equal(t, "You are a helpful assistant.", body["system"])
+
+	assert.Empty(t, requestHeader.Get("x-api-key"))
+	assert.Empty(t, requestHeader.Get("anthropic-version"))
+
+	// 使用相同的参数重新签名，结果应当一致
+	amzDate := requestHeader.Get("X-Amz-Date")
+	signTime, err := time.Parse("20060102T150405Z", amzDate)
+	assert.Nil(t, err)
+	authorization := requestHeader.Get("Authorization")
+	assert.True(t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"+amzDate[:8]+"/us-west-2/bedrock/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature="))
+
+	req, _ := http.NewRequest(http.MethodPost, url+"/model/anthropic.claude-3-5-sonnet-20241022-v2%3A0/invoke", nil)
+	req.Header.Set("Content-Type", requestHeader.Get("Content-Type"))
+	requester.SignAWSRequest(req, requestBody, requester.AWSCredentials{
+		AccessKeyId:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}, "us-west-2", "bedrock", signTime)
+	assert.Equal(t, req.Header.Get("Authorization"), authorization)
+}
+
+func TestBedrockChatCompletionsStream(t *testing.T) {
+	url, server, teardown := setupBedrockTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":25,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"!"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":15}}`,
+		`{"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/model/"+bedrockModel+"/invoke-with-response-stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		for _, event := range events {
+			w.Write(encodeBedrockChunk(event))
+		}
+	})
+
+	channel := getBedrockChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", bedrockModel, "true"))
+	assert.Nil(t, errWithCode)
+
+	var content string
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			content += choice.Delta.Content
+		}
+	}
+	assert.Equal(t, "Hello!", content)
+	assert.Equal(t, 25, usage.PromptTokens)
+	assert.Equal(t, 15, usage.CompletionTokens)
+}
+
+func TestBedrockChatCompletionsStreamException(t *testing.T) {
+	url, server, teardown := setupBedrockTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	server.RegisterHandler("/model/"+bedrockModel+"/invoke-with-response-stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.Write(encodeBedrockChunk(`{"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","content":[],"usage":{"input_tokens":25,"output_tokens":1}}}`))
+		w.Write(encodeBedrockMessage(map[string]string{
+			":message-type":   "exception",
+			":exception-type": "throttlingException",
+		}, []byte(`{"message":"Too many requests, please wait before trying again."}`)))
+	})
+
+	channel := getBedrockChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", bedrockModel, "true"))
+	assert.Nil(t, errWithCode)
+	defer stream.Close()
+
+	dataChan, errChan := stream.Recv()
+	for {
+		select {
+		case <-dataChan:
+			continue
+		case err := <-errChan:
+			openaiError, ok := err.(*types.OpenAIError)
+			assert.True(t, ok)
+			assert.Equal(t, "rate_limit_exceeded", openaiError.Code)
+			assert.Contains(t, openaiError.Message, "Too many requests")
+			return
+		}
+	}
+}
+
+func TestBedrockChatCompletionsError(t *testing.T) {
+	url, server, teardown := setupBedrockTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	server.RegisterHandler("/model/"+bedrockModel+"/invoke", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-amzn-ErrorType", "AccessDeniedException")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"message":"You don't have access to the model with the specified model ID."}`)
+	})
+
+	channel := getBedrockChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", bedrockModel, "false"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusForbidden, errWithCode.StatusCode)
+	assert.Contains(t, errWithCode.Message, "You don't have access to the model")
+
+	// 密钥格式错误时不发送请求
+	channel.Key = "AKIDEXAMPLE"
+	chatProvider = getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode = chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", bedrockModel, "false"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "invalid_claude_config", errWithCode.Code)
+}
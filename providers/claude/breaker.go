@@ -0,0 +1,160 @@
+package claude
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/types"
+	"sync"
+	"time"
+)
+
+// 熔断默认冷却时间，单位秒
+const defaultBreakerCooldown = 60
+
+const (
+	BreakerStateClosed   = "closed"
+	BreakerStateOpen     = "open"
+	BreakerStateHalfOpen = "half_open"
+)
+
+// 渠道的熔断状态，用于监控
+type CircuitBreakerStatus struct {
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	OpenedAt int64  `json:"opened_at,omitempty"`
+}
+
+// 每个渠道一个熔断器，provider 按请求创建，状态需要全局保存
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+var (
+	breakersLock sync.Mutex
+	breakers     = make(map[int]*circuitBreaker)
+)
+
+func getCircuitBreaker(channelId int) *circuitBreaker {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+
+	breaker, ok := breakers[channelId]
+	if !ok {
+		breaker = &circuitBreaker{state: BreakerStateClosed}
+		breakers[channelId] = breaker
+	}
+	return breaker
+}
+
+// 获取所有渠道的熔断状态
+func GetCircuitBreakerStatus() map[int]CircuitBreakerStatus {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+
+	status := make(map[int]CircuitBreakerStatus, len(breakers))
+	for channelId, breaker := range breakers {
+		status[channelId] = breaker.status()
+	}
+	return status
+}
+
+func (b *circuitBreaker) status() CircuitBreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := CircuitBreakerStatus{
+		State:    b.state,
+		Failures: b.failures,
+	}
+	if b.state != BreakerStateClosed {
+		status.OpenedAt = b.openedAt.Unix()
+	}
+	return status
+}
+
+// 熔断打开时直接拒绝，冷却结束后只放行一个探测请求
+func (b *circuitBreaker) allow(cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerStateOpen:
+		if time.Since(b.openedAt) < cooldown {
+			return false
+		}
+		b.state = BreakerStateHalfOpen
+		return true
+	case BreakerStateHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// 连续失败达到阈值或探测失败时打开熔断，成功则恢复
+func (b *circuitBreaker) record(failed bool, threshold int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.state = BreakerStateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerStateHalfOpen || b.failures >= threshold {
+		b.state = BreakerStateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// 探测请求被取消时恢复为打开状态，下一个请求重新探测
+func (b *circuitBreaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerStateHalfOpen {
+		b.state = BreakerStateOpen
+	}
+}
+
+// 只有渠道本身的问题才计入失败，请求参数错误不算
+func isBreakerFailure(errWithCode *types.OpenAIErrorWithStatusCode) bool {
+	if errWithCode == nil {
+		return false
+	}
+
+	switch errWithCode.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	}
+	return errWithCode.StatusCode >= http.StatusInternalServerError
+}
+
+// 渠道配置了 breaker_threshold 时启用熔断
+func (p *ClaudeProvider) sendRequestWithBreaker(req *http.Request, stream bool) (*http.Response, *types.OpenAIErrorWithStatusCode) {
+	threshold := p.getPluginIntParam("anthropic", "breaker_threshold", 0)
+	if threshold <= 0 {
+		return p.sendRequestWithRetry(req, stream)
+	}
+
+	cooldown := time.Duration(p.getPluginIntParam("anthropic", "breaker_cooldown", defaultBreakerCooldown)) * time.Second
+	breaker := getCircuitBreaker(p.Channel.Id)
+	if !breaker.allow(cooldown) {
+		return nil, common.StringErrorWrapper("channel is temporarily unavailable after repeated upstream failures", "circuit_breaker_open", http.StatusServiceUnavailable)
+	}
+
+	resp, errWithCode := p.sendRequestWithRetry(req, stream)
+	// 客户端取消的请求不能说明渠道的状态
+	if req.Context().Err() != nil {
+		breaker.cancel()
+	} else {
+		breaker.record(isBreakerFailure(errWithCode), threshold)
+	}
+
+	return resp, errWithCode
+}
@@ -0,0 +1,102 @@
+package claude_test
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/providers/claude"
+	"one-api/types"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatCompletionsCircuitBreaker(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	var calls, healthy int32
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintln(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+			return
+		}
+		fmt.Fprintln(w, response)
+	})
+
+	channel := getClaudeChannel(url)
+	channel.Id = 4901
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"breaker_threshold": "2", "breaker_cooldown": "1"},
+	})
+
+	send := func() *types.OpenAIErrorWithStatusCode {
+		context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+		chatProvider := getChatProvider(&channel, context)
+		chatProvider.SetUsage(&types.Usage{})
+		_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false"))
+		return errWithCode
+	}
+	state := func() string {
+		return claude.GetCircuitBreakerStatus()[channel.Id].State
+	}
+
+	// 连续失败达到阈值后打开
+	assert.Equal(t, http.StatusUnauthorized, send().StatusCode)
+	assert.Equal(t, claude.BreakerStateClosed, state())
+	assert.Equal(t, http.StatusUnauthorized, send().StatusCode)
+	assert.Equal(t, claude.BreakerStateOpen, state())
+
+	errWithCode := send()
+	assert.Equal(t, http.StatusServiceUnavailable, errWithCode.StatusCode)
+	assert.Equal(t, "circuit_breaker_open", errWithCode.Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// 冷却后探测失败，重新打开
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, http.StatusUnauthorized, send().StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, claude.BreakerStateOpen, state())
+	assert.Equal(t, "circuit_breaker_open", send().Code)
+
+	// 冷却后探测成功，恢复
+	time.Sleep(1100 * time.Millisecond)
+	atomic.StoreInt32(&healthy, 1)
+	assert.Nil(t, send())
+	assert.Equal(t, claude.BreakerStateClosed, state())
+	assert.Nil(t, send())
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+}
+
+func TestChatCompletionsCircuitBreakerIgnoresBadRequests(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, `{"type":"error","error":{"type":"invalid_request_error","message":"messages: field required"}}`)
+	})
+
+	channel := getClaudeChannel(url)
+	channel.Id = 4902
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"breaker_threshold": "1"},
+	})
+
+	for i := 0; i < 3; i++ {
+		context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+		chatProvider := getChatProvider(&channel, context)
+		chatProvider.SetUsage(&types.Usage{})
+		_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false"))
+		assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	}
+	assert.Equal(t, claude.BreakerStateClosed, claude.GetCircuitBreakerStatus()[channel.Id].State)
+}
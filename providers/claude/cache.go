@@ -0,0 +1,141 @@
+package claude
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/types"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 响应缓存最多保存的条目数，超出时淘汰最久未使用的
+const responseCacheSize = 1000
+
+const responseCacheHeader = "X-Response-Cache"
+
+type responseCacheEntry struct {
+	key       string
+	response  []byte
+	expiresAt time.Time
+}
+
+// 相同请求的非流式响应缓存，所有渠道共用，key 中包含渠道 id
+var (
+	responseCacheLock    sync.Mutex
+	responseCacheList    = list.New()
+	responseCacheEntries = make(map[string]*list.Element)
+)
+
+// 渠道插件 response_cache_ttl 大于 0 时开启，只缓存客户端显式传了 temperature 为 0、不使用工具且只有一个 choice 的请求，
+// 是否缓存以应用默认参数后最终发给 Claude 的请求为准，返回该请求的哈希，不缓存时返回空字符串
+func (p *ClaudeProvider) getResponseCacheKey(request *types.ChatCompletionRequest, req *http.Request) string {
+	if p.getResponseCacheTTL() <= 0 || request.Stream || p.getChoiceCount(request) > 1 {
+		return ""
+	}
+	if temperature := request.GetTemperature(); temperature == nil || *temperature != 0 {
+		return ""
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+
+	claudeRequest := &ClaudeRequest{}
+	if err := json.NewDecoder(body).Decode(claudeRequest); err != nil {
+		return ""
+	}
+	if claudeRequest.Temperature == nil || *claudeRequest.Temperature != 0 {
+		return ""
+	}
+	// json_schema 等转换出的工具和强制的 tool_choice 也不缓存
+	if len(claudeRequest.Tools) > 0 || claudeRequest.ToolChoice != nil {
+		return ""
+	}
+
+	// 缓存按令牌隔离，不同用户的相同请求不共享响应
+	return p.hashChatRequest(req, strconv.Itoa(p.Context.GetInt("token_id")))
+}
+
+// 计算渠道 id、请求地址、请求体和附加字段的哈希，失败时返回空字符串
+func (p *ClaudeProvider) hashChatRequest(req *http.Request, extra ...string) string {
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	io.WriteString(hash, strconv.Itoa(p.Channel.Id)+"\n"+req.URL.String()+"\n")
+	for _, value := range extra {
+		io.WriteString(hash, value+"\n")
+	}
+	if _, err := io.Copy(hash, body); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (p *ClaudeProvider) getResponseCacheTTL() time.Duration {
+	return time.Duration(p.getPluginIntParam("anthropic", "response_cache_ttl", 0)) * time.Second
+}
+
+// 返回缓存的响应，每次返回新的副本
+func getCachedResponse(key string) *types.ChatCompletionResponse {
+	responseCacheLock.Lock()
+	defer responseCacheLock.Unlock()
+
+	element, ok := responseCacheEntries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := element.Value.(*responseCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		responseCacheList.Remove(element)
+		delete(responseCacheEntries, key)
+		return nil
+	}
+	responseCacheList.MoveToFront(element)
+
+	response := &types.ChatCompletionResponse{}
+	if err := json.Unmarshal(entry.response, response); err != nil {
+		return nil
+	}
+	return response
+}
+
+func setCachedResponse(key string, response *types.ChatCompletionResponse, ttl time.Duration) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+
+	responseCacheLock.Lock()
+	defer responseCacheLock.Unlock()
+
+	entry := &responseCacheEntry{
+		key:       key,
+		response:  data,
+		expiresAt: time.Now().Add(ttl),
+	}
+	if element, ok := responseCacheEntries[key]; ok {
+		element.Value = entry
+		responseCacheList.MoveToFront(element)
+		return
+	}
+
+	responseCacheEntries[key] = responseCacheList.PushFront(entry)
+	for responseCacheList.Len() > responseCacheSize {
+		oldest := responseCacheList.Back()
+		responseCacheList.Remove(oldest)
+		delete(responseCacheEntries, oldest.Value.(*responseCacheEntry).key)
+	}
+}
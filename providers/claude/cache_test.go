@@ -0,0 +1,269 @@
+package claude_test
+
+import (
+	"net/http"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupResponseCacheServer() (baseUrl string, requests *int, teardown func()) {
+	url, server, teardown := setupClaudeTestServer()
+
+	requests = new(int)
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if r.Header.Get("Accept") == "text/event-stream" {
+			handleClaudeStreamEndpoint(nil, []string{
+				"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+				"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi!"}}`,
+				"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":3}}`,
+				"event: message_stop\n" + `data: {"type":"message_stop"}`,
+			})(w, r)
+			return
+		}
+		handleClaudeEndpoint(nil, response)(w, r)
+	})
+
+	return url, requests, teardown
+}
+
+func getResponseCacheChannel(baseUrl string, id int) model.Channel {
+	channel := getClaudeChannel(baseUrl)
+	channel.Id = id
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"response_cache_ttl": "60"},
+	})
+	return channel
+}
+
+// 显式传 temperature 为 0 的请求，params 为追加的字段
+func getZeroTemperatureRequest(params string) *types.ChatCompletionRequest {
+	return getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"system","content":"You are a helpful assistant."},{"role":"user","content":"Hello!"}],"temperature":0` + params + `}`)
+}
+
+func TestResponseCache(t *testing.T) {
+	url, requests, teardown := setupResponseCacheServer()
+	defer teardown()
+
+	channel := getResponseCacheChannel(url, 7601)
+	send := func(tokenId int, request *types.ChatCompletionRequest) (*types.ChatCompletionResponse, *types.Usage, http.Header) {
+		context, writer := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+		context.Set("token_id", tokenId)
+		chatProvider := getChatProvider(&channel, context)
+		usage := &types.Usage{}
+		chatProvider.SetUsage(usage)
+		response, errWithCode := chatProvider.CreateChatCompletion(request)
+		assert.Nil(t, errWithCode)
+		return response, usage, writer.Header()
+	}
+
+	// 第一次请求上游
+	response, usage, header := send(1, getZeroTemperatureRequest(""))
+	assert.Equal(t, 1, *requests)
+	assert.Equal(t, "MISS", header.Get("X-Response-Cache"))
+	assert.Equal(t, "Hi!", response.Choices[0].Message.Content)
+	assert.Equal(t, 15, usage.TotalTokens)
+
+	// 相同请求命中缓存，不计费
+	response, usage, header = send(1, getZeroTemperatureRequest(""))
+	assert.Equal(t, 1, *requests)
+	assert.Equal(t, "HIT", header.Get("X-Response-Cache"))
+	assert.Equal(t, "Hi!", response.Choices[0].Message.Content)
+	assert.Equal(t, types.Usage{}, *usage)
+
+	// 消息不同时不命中
+	request := getZeroTemperatureRequest("")
+	request.Messages[1].Content = "Hello again!"
+	_, _, header = send(1, request)
+	assert.Equal(t, 2, *requests)
+	assert.Equal(t, "MISS", header.Get("X-Response-Cache"))
+
+	// 其他令牌的相同请求不命中
+	_, usage, header = send(2, getZeroTemperatureRequest(""))
+	assert.Equal(t, 3, *requests)
+	assert.Equal(t, "MISS", header.Get("X-Response-Cache"))
+	assert.Equal(t, 15, usage.TotalTokens)
+
+	// 其他渠道单独缓存
+	other := getResponseCacheChannel(url, 7602)
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	chatProvider := getChatProvider(&other, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(getZeroTemperatureRequest(""))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 4, *requests)
+}
+
+func TestResponseCacheBypass(t *testing.T) {
+	url, requests, teardown := setupResponseCacheServer()
+	defer teardown()
+
+	channel := getResponseCacheChannel(url, 7603)
+	sendTwice := func(request func() *types.ChatCompletionRequest) http.Header {
+		var header http.Header
+		for i := 0; i < 2; i++ {
+			context, writer := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			if request().Stream {
+				stream, errWithCode := chatProvider.CreateChatCompletionStream(request())
+				assert.Nil(t, errWithCode)
+				readChatStream(t, stream)
+			} else {
+				_, errWithCode := chatProvider.CreateChatCompletion(request())
+				assert.Nil(t, errWithCode)
+			}
+			header = writer.Header()
+		}
+		return header
+	}
+
+	// 流式请求
+	header := sendTwice(func() *types.ChatCompletionRequest {
+		return getZeroTemperatureRequest(`,"stream":true`)
+	})
+	assert.Equal(t, 2, *requests)
+	assert.Empty(t, header.Get("X-Response-Cache"))
+
+	// 使用工具的请求
+	header = sendTwice(func() *types.ChatCompletionRequest {
+		return getZeroTemperatureRequest(`,"tools":[{"type":"function","function":{"name":"get_weather","parameters":{"type":"object","properties":{}}}}]`)
+	})
+	assert.Equal(t, 4, *requests)
+	assert.Empty(t, header.Get("X-Response-Cache"))
+
+	// temperature 不为 0 的请求
+	header = sendTwice(func() *types.ChatCompletionRequest {
+		request := test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "false")
+		request.Temperature = 0.7
+		return request
+	})
+	assert.Equal(t, 6, *requests)
+	assert.Empty(t, header.Get("X-Response-Cache"))
+
+	// 未指定 temperature 的请求，Claude 默认按 1 采样
+	header = sendTwice(func() *types.ChatCompletionRequest {
+		return test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "false")
+	})
+	assert.Equal(t, 8, *requests)
+	assert.Empty(t, header.Get("X-Response-Cache"))
+
+	// json_schema 转换为强制的工具调用
+	header = sendTwice(func() *types.ChatCompletionRequest {
+		return getZeroTemperatureRequest(`,"response_format":{"type":"json_schema","json_schema":{"name":"person","schema":{"type":"object"}}}`)
+	})
+	assert.Equal(t, 10, *requests)
+	assert.Empty(t, header.Get("X-Response-Cache"))
+
+	// 未开启缓存的渠道
+	channel = getClaudeChannel(url)
+	channel.Id = 7604
+	header = sendTwice(func() *types.ChatCompletionRequest {
+		return getZeroTemperatureRequest("")
+	})
+	assert.Equal(t, 12, *requests)
+	assert.Empty(t, header.Get("X-Response-Cache"))
+}
+
+func TestChatCompletionsSystemCache(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	headers := test.RequestJSONConfig()
+	headers["x-anthropic-cache"] = "true"
+	context, _ := test.GetContext("POST", "/v1/chat/completions", headers, nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"cache_creation_input_tokens":1024,"cache_read_input_tokens":2048,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	chatRequest := test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false")
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, []any{
+		map[string]any{
+			"type":          "text",
+			"text":          "You are a helpful assistant.",
+			"cache_control": map[string]any{"type": "ephemeral"},
+		},
+	}, requestBody["system"])
+
+	assert.Equal(t, 3084, usage.PromptTokens)
+	assert.Equal(t, 3087, usage.TotalTokens)
+	assert.Equal(t, 1024, openaiResponse.Usage.CacheCreationInputTokens)
+	assert.Equal(t, 2048, openaiResponse.Usage.CacheReadInputTokens)
+	assert.Equal(t, 12, openaiResponse.Usage.GetUncachedPromptTokens())
+}
+
+func TestChatCompletionsHistoryCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		messages    string
+		breakpoints []string // 每条消息最后一个块是否带 cache_control
+	}{
+		{
+			"multi-turn",
+			`[{"role":"user","content":"Hi"},{"role":"assistant","content":"Hello!"},{"role":"user","content":[{"type":"text","text":"First"},{"type":"text","text":"Second"}]},{"role":"assistant","content":"Noted."},{"role":"user","content":"What did I say?"}]`,
+			[]string{"", "", "", "ephemeral", ""},
+		},
+		{
+			"single message",
+			`[{"role":"user","content":"Hi"}]`,
+			[]string{""},
+		},
+	}
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"First and Second."}],"stop_reason":"end_turn","usage":{"input_tokens":12,"cache_creation_input_tokens":1024,"cache_read_input_tokens":2048,"output_tokens":3}}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			headers := test.RequestJSONConfig()
+			headers["x-anthropic-cache-history"] = "true"
+			context, _ := test.GetContext("POST", "/v1/chat/completions", headers, nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":` + tt.messages + `}`)
+			openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			assert.Nil(t, errWithCode)
+
+			var breakpoints []string
+			for _, message := range requestBody["messages"].([]any) {
+				content := message.(map[string]any)["content"].([]any)
+				for i, block := range content {
+					cacheControl, ok := block.(map[string]any)["cache_control"].(map[string]any)
+					if i < len(content)-1 {
+						// 只在消息的最后一个块设置断点
+						assert.False(t, ok)
+						continue
+					}
+					if ok {
+						breakpoints = append(breakpoints, cacheControl["type"].(string))
+					} else {
+						breakpoints = append(breakpoints, "")
+					}
+				}
+			}
+			assert.Equal(t, tt.breakpoints, breakpoints)
+
+			assert.Equal(t, 1024, openaiResponse.Usage.CacheCreationInputTokens)
+			assert.Equal(t, 2048, openaiResponse.Usage.CacheReadInputTokens)
+		})
+	}
+}
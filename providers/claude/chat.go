@@ -1,172 +1,1415 @@
 package claude
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"one-api/common"
 	"one-api/common/image"
 	"one-api/common/requester"
 	"one-api/types"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 type claudeStreamHandler struct {
 	Usage   *types.Usage
 	Request *types.ChatCompletionRequest
+
+	// 中继估算的提示 tokens，Claude 未返回输入 tokens 时使用
+	relayPromptTokens int
+
+	// 进行中的工具调用，按 Claude 内容块的序号保存，toolIndex 为下一个工具调用的序号
+	toolIndex int
+	toolCalls map[int]*streamToolCall
+
+	// json_schema 对应的工具，其参数作为文本内容返回
+	jsonSchemaTool string
+	inJsonBlock    bool
+	jsonResponded  bool
+
+	streamStarted bool
+
+	// 旧版 functions 请求只返回第一个工具调用，格式为 function_call
+	legacyFunctions bool
+
+	audit *chatAuditLog
+
+	// message_start 中 Claude 返回的模型
+	model string
+
+	// 请求使用的模型别名，响应中返回别名
+	modelAlias string
+
+	// 已返回的思考内容，用于估算推理 tokens
+	reasoning strings.Builder
+
+	// 预填充的内容，在流开始时先返回
+	prefill string
+
+	timer *streamTimer
+
+	// 上一个文本增量末尾不完整的 UTF-8 字节或代理对转义
+	partialText []byte
+}
+
+// 流式返回中的工具调用，参数在内容块结束时一次发送
+type streamToolCall struct {
+	call      types.ChatCompletionToolCalls
+	arguments strings.Builder
+}
+
+func (p *ClaudeProvider) CreateChatCompletion(request *types.ChatCompletionRequest) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
+	audit := p.newAuditLog(request)
+	openaiResponse, errWithCode := p.createChatCompletion(request)
+	if errWithCode != nil {
+		audit.finish(nil, errWithCode.Message)
+		return nil, errWithCode
+	}
+
+	if len(openaiResponse.Choices) > 0 {
+		finishReason, _ := openaiResponse.Choices[0].FinishReason.(string)
+		audit.setFinishReason(finishReason)
+		audit.appendCompletion(openaiResponse.Choices[0].Message.StringContent())
+	}
+	audit.finish(openaiResponse.Usage, "")
+	p.setEstimatedCost(p.resolveModelAlias(request.Model))
+
+	return openaiResponse, nil
+}
+
+func (p *ClaudeProvider) createChatCompletion(request *types.ChatCompletionRequest) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
+	req, errWithCode := p.getChatRequest(request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	defer req.Body.Close()
+
+	// Claude 不支持 n，需要多次请求
+	n := p.getChoiceCount(request)
+	if n > 1 {
+		return p.createChatCompletions(req, request, n)
+	}
+
+	// 命中缓存时不请求上游，用量清零不再计费
+	cacheKey := p.getResponseCacheKey(request, req)
+	if cacheKey != "" {
+		if cachedResponse := getCachedResponse(cacheKey); cachedResponse != nil {
+			p.Context.Writer.Header().Set(responseCacheHeader, "HIT")
+			if p.Usage != nil {
+				*p.Usage = types.Usage{}
+			}
+			return cachedResponse, nil
+		}
+		p.Context.Writer.Header().Set(responseCacheHeader, "MISS")
+	}
+
+	claudeResponse, errWithCode := p.sendChatRequest(req)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	openaiResponse, errWithCode := p.convertToChatOpenai(claudeResponse, request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	p.setUsage(openaiResponse.Usage)
+
+	if cacheKey != "" {
+		setCachedResponse(cacheKey, openaiResponse, p.getResponseCacheTTL())
+	}
+
+	return openaiResponse, nil
+}
+
+// 返回转换后的 Claude 请求，不发送给上游
+func (p *ClaudeProvider) ConvertChatRequest(request *types.ChatCompletionRequest) (any, *types.OpenAIErrorWithStatusCode) {
+	claudeRequest, errWithCode := p.convertFromChatOpenai(request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	return claudeRequest, nil
+}
+
+// Claude 不返回 logprobs，忽略后客户端难以排查，直接拒绝
+func checkUnsupportedParams(request *types.ChatCompletionRequest) *types.OpenAIErrorWithStatusCode {
+	if (request.LogProbs != nil && *request.LogProbs) || request.TopLogProbs > 0 {
+		return common.StringErrorWrapper("logprobs and top_logprobs are not supported by Claude", "unsupported_parameter", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// Claude 没有对应的参数，忽略后通过响应头告知客户端
+func (p *ClaudeProvider) warnUnsupportedParams(request *types.ChatCompletionRequest) {
+	if logitBias, ok := request.LogitBias.(map[string]any); request.LogitBias != nil && (!ok || len(logitBias) > 0) {
+		p.addWarning("logit_bias is not supported by Claude and was ignored")
+	}
+	if request.Seed != nil && !seedSupported {
+		p.addWarning("seed is not supported by Claude and was ignored, sampling is not deterministic")
+	}
+}
+
+// 发送非流式请求并解析响应
+func (p *ClaudeProvider) sendChatRequest(req *http.Request) (*ClaudeResponse, *types.OpenAIErrorWithStatusCode) {
+	resp, errWithCode := p.sendRequestWithBreaker(req, false)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	defer resp.Body.Close()
+
+	claudeResponse := &ClaudeResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(claudeResponse); err != nil {
+		return nil, common.ErrorWrapper(err, "decode_response_failed", http.StatusInternalServerError)
+	}
+
+	return claudeResponse, nil
+}
+
+// 获取需要返回的 choices 数量，超过渠道配置的上限时取上限
+func (p *ClaudeProvider) getChoiceCount(request *types.ChatCompletionRequest) int {
+	n := request.N
+	if n < 1 {
+		return 1
+	}
+
+	maxChoices := p.getPluginIntParam("anthropic", "max_n", defaultMaxChoices)
+	if maxChoices < 1 {
+		maxChoices = 1
+	}
+	if n > maxChoices {
+		return maxChoices
+	}
+	return n
+}
+
+// 并发发送 n 个请求，合并 choices 和 usage
+func (p *ClaudeProvider) createChatCompletions(req *http.Request, request *types.ChatCompletionRequest, n int) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
+	responses := make([]*types.ChatCompletionResponse, n)
+	errs := make([]*types.OpenAIErrorWithStatusCode, n)
+	usage := &types.Usage{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+		}
+		choiceReq := req.Clone(req.Context())
+		choiceReq.Body = body
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			claudeResponse, errWithCode := p.sendChatRequest(choiceReq)
+			if errWithCode != nil {
+				errs[i] = errWithCode
+				return
+			}
+			responses[i], errs[i] = p.convertToChatOpenai(claudeResponse, request)
+			if errs[i] == nil {
+				// 每个请求都会计费提示词，这里全部累加
+				p.addUsage(usage, responses[i].Usage)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, errWithCode := range errs {
+		if errWithCode != nil {
+			return nil, errWithCode
+		}
+	}
+
+	var openaiResponse *types.ChatCompletionResponse
+	for i, response := range responses {
+		choice := response.Choices[0]
+		choice.Index = i
+		if openaiResponse == nil {
+			openaiResponse = response
+			openaiResponse.Choices = nil
+		}
+		openaiResponse.Choices = append(openaiResponse.Choices, choice)
+	}
+	usage.ServiceTier = openaiResponse.ServiceTier
+	setUsageDetails(usage)
+
+	openaiResponse.Usage = usage
+	p.setUsage(usage)
+
+	return openaiResponse, nil
+}
+
+// 加锁累加用量，并发请求完成时调用
+func (p *ClaudeProvider) addUsage(total, usage *types.Usage) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	total.CacheCreationInputTokens += usage.CacheCreationInputTokens
+	total.CacheReadInputTokens += usage.CacheReadInputTokens
+	if usage.CompletionTokensDetails != nil {
+		if total.CompletionTokensDetails == nil {
+			total.CompletionTokensDetails = &types.CompletionTokensDetails{}
+		}
+		total.CompletionTokensDetails.ReasoningTokens += usage.CompletionTokensDetails.ReasoningTokens
+	}
+}
+
+func (p *ClaudeProvider) setUsage(usage *types.Usage) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.Usage != nil {
+		*p.Usage = *usage
+	}
+}
+
+// 记录中继估算的提示 tokens
+func (p *ClaudeProvider) SetUsage(usage *types.Usage) {
+	p.BaseProvider.SetUsage(usage)
+	if usage != nil {
+		p.relayPromptTokens = usage.PromptTokens
+	}
+}
+
+// 上游未返回提示 tokens 时使用请求前估算的值
+func (p *ClaudeProvider) getPromptTokens(request *types.ChatCompletionRequest) int {
+	return estimatePromptTokens(p.relayPromptTokens, request)
+}
+
+func (p *ClaudeProvider) CreateChatCompletionStream(request *types.ChatCompletionRequest) (requester.StreamReaderInterface[string], *types.OpenAIErrorWithStatusCode) {
+	audit := p.newAuditLog(request)
+	timer := p.newStreamTimer()
+	cancel := p.detachStreamContext()
+	// 开始记录后由缓存在续传窗口结束时取消上游请求
+	recording := false
+	defer func() {
+		if !recording {
+			cancel()
+		}
+	}()
+	req, errWithCode := p.getChatRequest(request)
+	if errWithCode != nil {
+		audit.finish(nil, errWithCode.Message)
+		return nil, errWithCode
+	}
+	defer req.Body.Close()
+
+	// 开启断点续传时，带有 Last-Event-ID 的相同请求从缓存的流继续返回
+	resumeKey := ""
+	if p.getStreamResumeTTL() > 0 {
+		resumeKey = p.hashChatRequest(req, strconv.Itoa(p.Context.GetInt("token_id")))
+		if stream := p.resumeStream(resumeKey); stream != nil {
+			return stream, nil
+		}
+	}
+
+	// 发送请求
+	resp, errWithCode := p.sendRequestWithBreaker(req, true)
+	if errWithCode != nil {
+		audit.finish(nil, errWithCode.Message)
+		return nil, errWithCode
+	}
+	if p.isBedrock() {
+		resp.Body = newBedrockStreamBody(resp.Body)
+	} else if errWithCode = checkStreamResponse(resp); errWithCode != nil {
+		audit.finish(nil, errWithCode.Message)
+		return nil, errWithCode
+	}
+
+	// 记录的流在客户端断开后继续写入用量，使用单独的 Usage，读取者关闭时再复制到 p.Usage
+	usage := p.Usage
+	if resumeKey != "" {
+		usage = &types.Usage{}
+		if p.Usage != nil {
+			*usage = *p.Usage
+		}
+	}
+
+	chatHandler := &claudeStreamHandler{
+		Usage:             usage,
+		Request:           request,
+		relayPromptTokens: p.relayPromptTokens,
+		jsonSchemaTool:    getJsonSchemaToolName(request),
+		legacyFunctions:   isLegacyFunctions(request),
+		audit:             audit,
+		timer:             timer,
+	}
+	if chatHandler.jsonSchemaTool == "" {
+		chatHandler.prefill = getPrefill(request)
+	}
+	if p.resolveModelAlias(request.Model) != request.Model {
+		chatHandler.modelAlias = request.Model
+	}
+
+	stream, errWithCode := requester.RequestStream[string](p.Requester, resp, chatHandler.handlerStream)
+	if errWithCode != nil || resumeKey == "" {
+		return stream, errWithCode
+	}
+	recording = true
+	return p.recordStream(resumeKey, stream, cancel, usage), nil
+}
+
+// SSE 中合法的行前缀
+var sseLinePrefixes = [][]byte{[]byte("event:"), []byte("data:"), []byte("id:"), []byte("retry:"), []byte(":")}
+
+type peekedBody struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// 代理等返回的 HTML 等非 SSE 响应会被流处理忽略，需要提前作为错误返回
+func checkStreamResponse(resp *http.Response) *types.OpenAIErrorWithStatusCode {
+	contentType := resp.Header.Get("Content-Type")
+	if strings.Contains(contentType, "text/event-stream") || strings.Contains(contentType, "application/json") {
+		return nil
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	peek, _ := reader.Peek(512)
+	peek = bytes.TrimLeft(peek, " \t\r\n")
+	for _, prefix := range sseLinePrefixes {
+		if bytes.HasPrefix(peek, prefix) {
+			resp.Body = peekedBody{Reader: reader, Closer: resp.Body}
+			return nil
+		}
+	}
+	resp.Body.Close()
+
+	statusCode := resp.StatusCode
+	if !(statusCode < http.StatusOK || statusCode >= http.StatusBadRequest) {
+		statusCode = http.StatusBadGateway
+	}
+
+	snippet := []rune(strings.Join(strings.Fields(string(peek)), " "))
+	if len(snippet) > 200 {
+		snippet = snippet[:200]
+	}
+
+	return &types.OpenAIErrorWithStatusCode{
+		StatusCode: statusCode,
+		OpenAIError: types.OpenAIError{
+			Message: fmt.Sprintf("Provider API error: invalid stream response, status code %d, content type %q: %s", resp.StatusCode, contentType, string(snippet)),
+			Type:    "upstream_error",
+			Code:    "invalid_stream_response",
+			Param:   strconv.Itoa(resp.StatusCode),
+		},
+	}
+}
+
+func (p *ClaudeProvider) getChatRequest(request *types.ChatCompletionRequest) (*http.Request, *types.OpenAIErrorWithStatusCode) {
+	url, errWithCode := p.GetSupportedAPIUri(common.RelayModeChatCompletions)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	// 获取请求地址
+	fullRequestURL := p.GetFullRequestURL(url, request.Model)
+	if fullRequestURL == "" {
+		return nil, common.ErrorWrapper(nil, "invalid_claude_config", http.StatusInternalServerError)
+	}
+
+	headers := p.GetRequestHeaders()
+	if request.Stream {
+		headers["Accept"] = "text/event-stream"
+	}
+
+	claudeRequest, errWithCode := p.convertFromChatOpenai(request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	p.warnUnsupportedParams(request)
+
+	// 模型名写错时直接返回相近的模型，而不是转发后得到上游的 404
+	if errWithCode = p.checkModelSupported(claudeRequest.Model); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	// 预估提示 tokens 供额度预扣，收到响应后以实际用量为准
+	promptTokens, errWithCode := p.checkContextWindow(claudeRequest, request.Model)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	if p.Usage != nil {
+		p.Usage.PromptTokens = promptTokens
+	}
+
+	if p.isBedrock() {
+		return p.newBedrockRequest(claudeRequest, request.Stream)
+	}
+	if p.isVertex() {
+		return p.newVertexRequest(claudeRequest)
+	}
+	if len(claudeRequest.betas) > 0 {
+		headers["anthropic-beta"] = strings.Join(p.getAnthropicBetas(claudeRequest.betas...), ",")
+	}
+
+	// 创建请求
+	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(claudeRequest), p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+
+	return req, nil
+}
+
+func (p *ClaudeProvider) convertFromChatOpenai(request *types.ChatCompletionRequest) (*ClaudeRequest, *types.OpenAIErrorWithStatusCode) {
+	if errWithCode := checkUnsupportedParams(request); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	claudeRequest := ClaudeRequest{
+		Model:         p.resolveModelAlias(request.Model),
+		Messages:      []Message{},
+		MaxTokens:     request.MaxTokens,
+		StopSequences: request.GetStop(),
+		Temperature:   request.GetTemperature(),
+		TopP:          request.GetTopP(),
+		TopK:          request.TopK,
+		Stream:        request.Stream,
+	}
+
+	if request.User != "" {
+		claudeRequest.Metadata = &ClaudeMetadata{UserId: request.User}
+	}
+
+	claudeRequest.ServiceTier = p.getServiceTier(request)
+
+	if errWithCode := convertThinking(request, &claudeRequest); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	p.applyModelDefaults(&claudeRequest)
+	if claudeRequest.MaxTokens == 0 {
+		claudeRequest.MaxTokens = defaultMaxTokens
+	}
+	p.applyMinMaxTokens(&claudeRequest)
+
+	if errWithCode := p.limitMaxTokens(&claudeRequest); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	if errWithCode := p.limitSamplingParams(&claudeRequest); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	tools, toolChoice := request.Tools, request.ToolChoice
+	if isLegacyFunctions(request) {
+		tools, toolChoice = convertLegacyFunctions(request)
+	}
+
+	// tool_choice 为 none 时不发送工具，但历史消息中有工具调用时 Claude 要求定义工具，此时改用 Claude 的 none
+	if toolChoice == "none" && !hasToolHistory(request) {
+		tools = nil
+	}
+
+	if tools != nil {
+		claudeRequest.Tools = make([]Tools, 0, len(tools))
+		for _, tool := range tools {
+			claudeRequest.addTool(tool)
+		}
+		claudeRequest.ToolChoice = convertToolChoice(toolChoice)
+	}
+
+	convertJsonSchema(request, &claudeRequest)
+	convertParallelToolCalls(request, &claudeRequest)
+	convertSeed(request, &claudeRequest)
+
+	imageOptions := p.getImageOptions()
+	images, errWithCode := convertImages(request.Messages, imageOptions)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	// 旧版 function_call 没有 id，按顺序生成，之后的 function 消息对应最近的一次调用
+	legacyCallCount := 0
+
+	var systems []string
+	for _, message := range request.Messages {
+		// system 的 content 可能是字符串，也可能是 text 块数组
+		if message.Role == "system" {
+			for _, part := range message.ParseContent() {
+				if part.Type == types.ContentTypeText && part.Text != "" {
+					systems = append(systems, part.Text)
+				}
+			}
+			continue
+		}
+
+		// 工具调用结果需要以 user 角色的 tool_result 发送
+		if message.Role == types.ChatMessageRoleTool || message.Role == types.ChatMessageRoleFunction {
+			toolUseId := message.ToolCallID
+			if message.Role == types.ChatMessageRoleFunction {
+				toolUseId = getLegacyCallId(legacyCallCount)
+			}
+			toolResult, errWithCode := convertToolResultContent(&message, images, imageOptions)
+			if errWithCode != nil {
+				return nil, errWithCode
+			}
+			claudeRequest.Messages = append(claudeRequest.Messages, Message{
+				Role: types.ChatMessageRoleUser,
+				Content: []MessageContent{
+					{
+						Type:      "tool_result",
+						ToolUseId: toolUseId,
+						Content:   toolResult,
+					},
+				},
+			})
+			continue
+		}
+
+		content := Message{
+			Role:    convertRole(message.Role),
+			Content: []MessageContent{},
+		}
+
+		openaiContent := message.ParseContent()
+		for _, part := range openaiContent {
+			if part.Type == types.ContentTypeText {
+				// Claude 不接受空白的文本块，回放的 assistant 工具调用消息 content 也常为空字符串
+				if strings.TrimSpace(part.Text) == "" {
+					continue
+				}
+				content.Content = append(content.Content, MessageContent{
+					Type: "text",
+					Text: part.Text,
+				})
+				continue
+			}
+
+			if part.Type == types.ContentTypeImageURL {
+				imageContent, ok := images[*part.ImageURL]
+				if !ok {
+					var errWithCode *types.OpenAIErrorWithStatusCode
+					imageContent, errWithCode = convertImage(part.ImageURL, imageOptions)
+					if errWithCode != nil {
+						return nil, errWithCode
+					}
+					images[*part.ImageURL] = imageContent
+				}
+				content.Content = append(content.Content, *imageContent)
+				continue
+			}
+
+			if part.Type == types.ContentTypeFile {
+				document, errWithCode := convertDocument(part.File)
+				if errWithCode != nil {
+					return nil, errWithCode
+				}
+				if p.isCitationsEnabled() {
+					document.Citations = &Citations{Enabled: true}
+				}
+				content.Content = append(content.Content, *document)
+			}
+		}
+
+		for _, toolCall := range message.ToolCalls {
+			toolUse, errWithCode := convertToolCall(toolCall)
+			if errWithCode != nil {
+				return nil, errWithCode
+			}
+			content.Content = append(content.Content, *toolUse)
+		}
+
+		if message.FunctionCall != nil {
+			legacyCallCount++
+			toolUse, errWithCode := convertToolCall(&types.ChatCompletionToolCalls{
+				Id:       getLegacyCallId(legacyCallCount),
+				Type:     "function",
+				Function: message.FunctionCall,
+			})
+			if errWithCode != nil {
+				return nil, errWithCode
+			}
+			content.Content = append(content.Content, *toolUse)
+		}
+
+		// 没有内容的消息 Claude 不接受，如作为预填充的空回复，或工具结果之后只有空文本的 user 消息
+		if len(content.Content) == 0 {
+			continue
+		}
+
+		claudeRequest.Messages = append(claudeRequest.Messages, content)
+	}
+	claudeRequest.Messages = mergeSameRoleMessages(claudeRequest.Messages)
+	trimPrefill(&claudeRequest)
+
+	// 只有 system 消息时 Claude 返回的错误不易理解，提前返回
+	if len(claudeRequest.Messages) == 0 {
+		return nil, errorHandleWithStatusCode(&ClaudeError{
+			Type:    "invalid_request_error",
+			Message: "at least one user or assistant message is required, system messages alone are not enough",
+		})
+	}
+
+	systems = p.addDefaultSystemPrompt(systems)
+
+	// 多个 system 消息按顺序合并
+	if len(systems) > 0 {
+		claudeRequest.System = strings.Join(systems, "\n")
+	}
+
+	if p.isPromptCacheEnabled() {
+		claudeRequest.cacheSystem()
+	}
+
+	if p.isHistoryCacheEnabled() {
+		claudeRequest.cacheHistory()
+	}
+
+	return &claudeRequest, nil
+}
+
+// 渠道配置的 system_prompt 默认放在客户端的 system 之前，system_prompt_position 为 append 时放在之后
+func (p *ClaudeProvider) addDefaultSystemPrompt(systems []string) []string {
+	systemPrompt := p.getPluginParam("anthropic", "system_prompt")
+	if systemPrompt == "" {
+		return systems
+	}
+
+	if p.getPluginParam("anthropic", "system_prompt_position") == "append" {
+		return append(systems, systemPrompt)
+	}
+	return append([]string{systemPrompt}, systems...)
+}
+
+// 根据 thinking 或 reasoning_effort 开启扩展思考
+func convertThinking(request *types.ChatCompletionRequest, claudeRequest *ClaudeRequest) *types.OpenAIErrorWithStatusCode {
+	budgetTokens := 0
+	if request.Thinking != nil && request.Thinking.Type == "enabled" {
+		budgetTokens = request.Thinking.BudgetTokens
+		if budgetTokens < minThinkingBudgetTokens {
+			return common.StringErrorWrapper(fmt.Sprintf("thinking budget_tokens must be at least %d", minThinkingBudgetTokens), "invalid_thinking", http.StatusBadRequest)
+		}
+	} else if request.ReasoningEffort != "" {
+		var ok bool
+		budgetTokens, ok = reasoningEffortBudgets[request.ReasoningEffort]
+		if !ok {
+			return common.StringErrorWrapper(fmt.Sprintf("unsupported reasoning_effort %s", request.ReasoningEffort), "invalid_thinking", http.StatusBadRequest)
+		}
+	}
+
+	if budgetTokens == 0 {
+		return nil
+	}
+
+	// 未指定 max_tokens 时在预算之上留出输出空间
+	if request.MaxTokens == 0 {
+		claudeRequest.MaxTokens = budgetTokens + defaultMaxTokens
+	} else if request.MaxTokens <= budgetTokens {
+		return common.StringErrorWrapper("max_tokens must be greater than thinking budget_tokens", "invalid_thinking", http.StatusBadRequest)
+	}
+
+	claudeRequest.Thinking = &Thinking{
+		Type:         "enabled",
+		BudgetTokens: budgetTokens,
+	}
+
+	return nil
+}
+
+// 渠道插件 min_max_tokens 为 max_tokens 的下限，避免客户端误传 1 之类的值导致回复为空，健康检查不受影响
+func (p *ClaudeProvider) applyMinMaxTokens(claudeRequest *ClaudeRequest) {
+	minMaxTokens := p.getPluginIntParam("anthropic", "min_max_tokens", 0)
+	if p.healthCheck || claudeRequest.MaxTokens >= minMaxTokens {
+		return
+	}
+
+	common.LogWarn(p.Context.Request.Context(), fmt.Sprintf("claude max_tokens %d is below the minimum, raised to %d", claudeRequest.MaxTokens, minMaxTokens))
+	claudeRequest.MaxTokens = minMaxTokens
+}
+
+// max_tokens 超过模型上限时，默认截断到上限，渠道配置 max_tokens_policy 为 reject 时直接拒绝
+func (p *ClaudeProvider) limitMaxTokens(claudeRequest *ClaudeRequest) *types.OpenAIErrorWithStatusCode {
+	maxOutputTokens := getModelMaxOutputTokens(claudeRequest.Model)
+	if maxOutputTokens == 0 || claudeRequest.MaxTokens <= maxOutputTokens {
+		return nil
+	}
+
+	if p.getPluginParam("anthropic", "max_tokens_policy") == "reject" {
+		return common.StringErrorWrapper(fmt.Sprintf("max_tokens %d exceeds the maximum of %d output tokens for model %s", claudeRequest.MaxTokens, maxOutputTokens, claudeRequest.Model), "invalid_max_tokens", http.StatusBadRequest)
+	}
+
+	if claudeRequest.Thinking != nil && maxOutputTokens <= claudeRequest.Thinking.BudgetTokens {
+		return common.StringErrorWrapper(fmt.Sprintf("thinking budget_tokens must be less than the maximum of %d output tokens for model %s", maxOutputTokens, claudeRequest.Model), "invalid_thinking", http.StatusBadRequest)
+	}
+
+	claudeRequest.MaxTokens = maxOutputTokens
+
+	return nil
+}
+
+// json_schema 使用的工具名称，未请求 json_schema 时返回空
+func getJsonSchemaToolName(request *types.ChatCompletionRequest) string {
+	if request.ResponseFormat == nil || request.ResponseFormat.Type != "json_schema" || request.ResponseFormat.JsonSchema == nil {
+		return ""
+	}
+
+	if request.ResponseFormat.JsonSchema.Name == "" {
+		return "json_response"
+	}
+	return request.ResponseFormat.JsonSchema.Name
+}
+
+// Claude 不支持 json_schema，通过强制调用一个以该 schema 为参数的工具实现
+func convertJsonSchema(request *types.ChatCompletionRequest, claudeRequest *ClaudeRequest) {
+	toolName := getJsonSchemaToolName(request)
+	if toolName == "" {
+		return
+	}
+
+	jsonSchema := request.ResponseFormat.JsonSchema
+	description := jsonSchema.Description
+	if description == "" {
+		description = "Respond with a JSON object that matches the input schema."
+	}
+
+	// 有其他工具时只要求必须调用工具，否则强制调用 json_schema 工具
+	if len(claudeRequest.Tools) > 0 {
+		claudeRequest.ToolChoice = &ToolChoice{Type: "any"}
+	} else {
+		claudeRequest.ToolChoice = &ToolChoice{Type: "tool", Name: toolName}
+	}
+
+	claudeRequest.Tools = append(claudeRequest.Tools, Tools{
+		Name:        toolName,
+		Description: description,
+		InputSchema: jsonSchema.Schema,
+	})
+}
+
+// Claude 暂不支持 seed，相同的请求不能保证得到相同的结果，支持后改为 true 即可透传
+const seedSupported = false
+
+// 支持 seed 时透传给 Claude，否则忽略并由 warnUnsupportedParams 提示客户端
+func convertSeed(request *types.ChatCompletionRequest, claudeRequest *ClaudeRequest) {
+	if seedSupported {
+		claudeRequest.Seed = request.Seed
+	}
+}
+
+// parallel_tool_calls 明确为 false 时，限制 Claude 最多调用一个工具
+func convertParallelToolCalls(request *types.ChatCompletionRequest, claudeRequest *ClaudeRequest) {
+	if request.ParallelToolCalls == nil || *request.ParallelToolCalls || len(claudeRequest.Tools) == 0 {
+		return
+	}
+
+	if claudeRequest.ToolChoice == nil {
+		claudeRequest.ToolChoice = &ToolChoice{Type: "auto"}
+	}
+	claudeRequest.ToolChoice.DisableParallelToolUse = true
+}
+
+// Claude 的 temperature 和 top_p 只接受 [0, 1]，而 OpenAI 的 temperature 最大为 2
+// 默认截断到范围内，渠道配置 temperature_policy 为 reject 时直接拒绝
+func (p *ClaudeProvider) limitSamplingParams(claudeRequest *ClaudeRequest) *types.OpenAIErrorWithStatusCode {
+	reject := p.getPluginParam("anthropic", "temperature_policy") == "reject"
+	params := []struct {
+		name  string
+		value *float64
+	}{
+		{"temperature", claudeRequest.Temperature},
+		{"top_p", claudeRequest.TopP},
+	}
+
+	for _, param := range params {
+		if param.value == nil || *param.value >= 0 && *param.value <= 1 {
+			continue
+		}
+
+		if reject {
+			return common.StringErrorWrapper(fmt.Sprintf("%s %g is out of range: Claude only accepts values between 0 and 1, and this channel rejects out-of-range values instead of clamping them", param.name, *param.value), "invalid_sampling_params", http.StatusBadRequest)
+		}
+
+		if *param.value > 1 {
+			*param.value = 1
+		} else {
+			*param.value = 0
+		}
+	}
+
+	return nil
+}
+
+// 按 OpenAI 的格式返回用量明细
+func setUsageDetails(usage *types.Usage) {
+	usage.PromptTokensDetails = &types.PromptTokensDetails{
+		CachedTokens:        usage.CacheReadInputTokens,
+		CacheCreationTokens: usage.CacheCreationInputTokens,
+	}
+	completionTokensDetails := &types.CompletionTokensDetails{}
+	if usage.CompletionTokensDetails != nil {
+		completionTokensDetails.ReasoningTokens = usage.CompletionTokensDetails.ReasoningTokens
+	}
+	usage.CompletionTokensDetails = completionTokensDetails
+}
+
+// Claude 的 output_tokens 包含思考内容但没有单独返回，按思考文本估算推理 tokens，不超过 output_tokens
+func countReasoningTokens(reasoningContent string, completionTokens int, modelName string) int {
+	if reasoningContent == "" {
+		return 0
+	}
+
+	tokens := common.CountTokenText(reasoningContent, modelName)
+	if tokens > completionTokens {
+		return completionTokens
+	}
+	return tokens
+}
+
+// Claude 未返回输入 tokens 时，优先使用已估算的值，否则用本地分词器计算
+func estimatePromptTokens(relayPromptTokens int, request *types.ChatCompletionRequest) int {
+	if relayPromptTokens > 0 {
+		return relayPromptTokens
+	}
+
+	return common.CountTokenMessages(request.Messages, request.Model)
+}
+
+// 渠道插件中的图片转换配置，maxDimension 和 maxBytes 为 0 时不限制
+type imageOptions struct {
+	transcode    bool // 不支持但可以解码的图片格式转换为 png
+	maxDimension int
+	maxBytes     int
+}
+
+// 按 Claude 的计算方式估算转换后请求的提示 tokens，图片按尺寸计算
+func estimateClaudePromptTokens(claudeRequest *ClaudeRequest, modelName string) int {
+	tokens := 0
+	switch system := claudeRequest.System.(type) {
+	case string:
+		tokens += common.CountTokenText(system, modelName)
+	case []MessageContent:
+		tokens += estimateContentTokens(system, modelName)
+	}
+
+	for _, message := range claudeRequest.Messages {
+		tokens += tokensPerMessage
+		tokens += estimateContentTokens(message.Content, modelName)
+	}
+
+	tokens += estimateToolTokens(claudeRequest.Tools, modelName)
+
+	return tokens + tokensPerMessage
+}
+
+// 工具定义按序列化后的 JSON 计算
+func estimateToolTokens(tools []Tools, modelName string) int {
+	if len(tools) == 0 {
+		return 0
+	}
+
+	tokens := toolUseSystemPromptTokens
+	for _, tool := range tools {
+		data, err := json.Marshal(tool)
+		if err != nil {
+			continue
+		}
+		tokens += common.CountTokenText(string(data), modelName)
+	}
+
+	return tokens
+}
+
+// 提示 tokens 超过上下文窗口减去 max_tokens 时，默认在发送前拒绝，
+// 渠道插件 context_window_policy 为 truncate 时从最早的消息开始删除，返回预估的提示 tokens
+func (p *ClaudeProvider) checkContextWindow(claudeRequest *ClaudeRequest, modelName string) (int, *types.OpenAIErrorWithStatusCode) {
+	promptTokens := estimateClaudePromptTokens(claudeRequest, modelName)
+	contextWindow := getModelContextWindow(claudeRequest.Model)
+	if contextWindow == 0 {
+		return promptTokens, nil
+	}
+
+	maxPromptTokens := contextWindow - claudeRequest.MaxTokens
+	if promptTokens <= maxPromptTokens {
+		return promptTokens, nil
+	}
+
+	if p.getPluginParam("anthropic", "context_window_policy") == "truncate" {
+		messages := claudeRequest.Messages
+		for len(messages) > 1 && promptTokens > maxPromptTokens {
+			promptTokens -= tokensPerMessage + estimateContentTokens(messages[0].Content, modelName)
+			messages = messages[1:]
+			// 第一条消息需要是 user，且 tool_result 不能脱离对应的 tool_use
+			for len(messages) > 1 && (messages[0].Role != types.ChatMessageRoleUser || hasToolResult(messages[0])) {
+				promptTokens -= tokensPerMessage + estimateContentTokens(messages[0].Content, modelName)
+				messages = messages[1:]
+			}
+		}
+		claudeRequest.Messages = messages
+		if promptTokens <= maxPromptTokens {
+			return promptTokens, nil
+		}
+	}
+
+	return 0, common.StringErrorWrapper(fmt.Sprintf("prompt is about %d tokens, which exceeds the context window of %d tokens for model %s minus max_tokens %d", promptTokens, contextWindow, claudeRequest.Model, claudeRequest.MaxTokens), "context_length_exceeded", http.StatusBadRequest)
+}
+
+// 历史消息中是否有工具调用或工具结果
+func hasToolHistory(request *types.ChatCompletionRequest) bool {
+	for _, message := range request.Messages {
+		if message.Role == types.ChatMessageRoleTool || message.Role == types.ChatMessageRoleFunction {
+			return true
+		}
+		if len(message.ToolCalls) > 0 || message.FunctionCall != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func hasToolResult(message Message) bool {
+	for _, content := range message.Content {
+		if content.Type == "tool_result" {
+			return true
+		}
+	}
+	return false
+}
+
+func estimateContentTokens(contents []MessageContent, modelName string) int {
+	tokens := 0
+	for _, content := range contents {
+		switch content.Type {
+		case "text":
+			tokens += common.CountTokenText(content.Text, modelName)
+		case "image":
+			tokens += estimateImageTokens(content.Source)
+		case "tool_use":
+			tokens += common.CountTokenText(content.Name+string(content.Input), modelName)
+		case "tool_result":
+			switch result := content.Content.(type) {
+			case string:
+				tokens += common.CountTokenText(result, modelName)
+			case []MessageContent:
+				tokens += estimateContentTokens(result, modelName)
+			}
+		}
+	}
+	return tokens
 }
 
-func (p *ClaudeProvider) CreateChatCompletion(request *types.ChatCompletionRequest) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
-	req, errWithCode := p.getChatRequest(request)
-	if errWithCode != nil {
-		return nil, errWithCode
+// 无法读取尺寸的图片按最大值计算
+func estimateImageTokens(source *ContentSource) int {
+	if source == nil || source.Type != "base64" {
+		return maxImageTokens
 	}
-	defer req.Body.Close()
 
-	claudeResponse := &ClaudeResponse{}
-	// 发送请求
-	_, errWithCode = p.Requester.SendRequest(req, claudeResponse, false)
-	if errWithCode != nil {
-		return nil, errWithCode
+	width, height, err := image.GetImageSizeFromBase64(source.Data)
+	if err != nil {
+		return maxImageTokens
+	}
+	return getImageTokens(width, height)
+}
+
+// Claude 会把长边超过 1568 像素或超过约 115 万像素的图片缩小，每 750 像素约 1 token
+func getImageTokens(width, height int) int {
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+
+	scale := 1.0
+	if longEdge := math.Max(float64(width), float64(height)); longEdge > imageMaxLongEdge {
+		scale = imageMaxLongEdge / longEdge
+	}
+	if pixels := float64(width) * float64(height) * scale * scale; pixels > imageMaxPixels {
+		scale *= math.Sqrt(imageMaxPixels / pixels)
 	}
 
-	return p.convertToChatOpenai(claudeResponse, request)
+	return int(math.Ceil(float64(width) * scale * float64(height) * scale / imagePixelsPerToken))
 }
 
-func (p *ClaudeProvider) CreateChatCompletionStream(request *types.ChatCompletionRequest) (requester.StreamReaderInterface[string], *types.OpenAIErrorWithStatusCode) {
-	req, errWithCode := p.getChatRequest(request)
-	if errWithCode != nil {
-		return nil, errWithCode
+func (p *ClaudeProvider) getImageOptions() imageOptions {
+	return imageOptions{
+		transcode:    p.getPluginBoolParam("anthropic", "transcode_images"),
+		maxDimension: p.getPluginIntParam("anthropic", "max_image_dimension", defaultMaxImageDimension),
+		maxBytes:     p.getPluginIntParam("anthropic", "max_image_bytes", defaultMaxImageBytes),
 	}
-	defer req.Body.Close()
+}
 
-	// 发送请求
-	resp, errWithCode := p.Requester.SendRequestRaw(req)
-	if errWithCode != nil {
-		return nil, errWithCode
+// 同一请求中重复的图片只获取一次，不同的图片并发获取和转换，每张图片对应一个 image 块
+// 有多张图片失败时返回消息中最靠前的错误
+func convertImages(messages []types.ChatCompletionMessage, options imageOptions) (map[types.ChatMessageImageURL]*MessageContent, *types.OpenAIErrorWithStatusCode) {
+	images := make(map[types.ChatMessageImageURL]*MessageContent)
+	var imageURLs []types.ChatMessageImageURL
+	for _, message := range messages {
+		if message.Role == types.ChatMessageRoleSystem {
+			continue
+		}
+		for _, part := range message.ParseContent() {
+			if part.Type != types.ContentTypeImageURL || part.ImageURL == nil {
+				continue
+			}
+			if _, ok := images[*part.ImageURL]; ok {
+				continue
+			}
+			images[*part.ImageURL] = nil
+			imageURLs = append(imageURLs, *part.ImageURL)
+		}
 	}
 
-	chatHandler := &claudeStreamHandler{
-		Usage:   p.Usage,
-		Request: request,
+	contents := make([]*MessageContent, len(imageURLs))
+	errs := make([]*types.OpenAIErrorWithStatusCode, len(imageURLs))
+	semaphore := make(chan struct{}, maxConcurrentImages)
+	var wg sync.WaitGroup
+	for i := range imageURLs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			contents[i], errs[i] = convertImage(&imageURLs[i], options)
+		}(i)
 	}
+	wg.Wait()
 
-	return requester.RequestStream[string](p.Requester, resp, chatHandler.handlerStream)
+	for i, imageURL := range imageURLs {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		images[imageURL] = contents[i]
+	}
+	return images, nil
 }
 
-func (p *ClaudeProvider) getChatRequest(request *types.ChatCompletionRequest) (*http.Request, *types.OpenAIErrorWithStatusCode) {
-	url, errWithCode := p.GetSupportedAPIUri(common.RelayModeChatCompletions)
-	if errWithCode != nil {
-		return nil, errWithCode
+// 图片的实际类型必须是 Claude 支持的格式，避免把错误页面等内容当作图片发送
+func convertImage(imageURL *types.ChatMessageImageURL, options imageOptions) (*MessageContent, *types.OpenAIErrorWithStatusCode) {
+	mimeType, data, err := image.GetImageFromUrl(imageURL.URL)
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "image_url_invalid", http.StatusBadRequest)
 	}
 
-	// 获取请求地址
-	fullRequestURL := p.GetFullRequestURL(url, request.Model)
-	if fullRequestURL == "" {
-		return nil, common.ErrorWrapper(nil, "invalid_claude_config", http.StatusInternalServerError)
-	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	if !supportedImageTypes[mimeType] {
+		unsupportedErr := common.StringErrorWrapper(fmt.Sprintf("unsupported image type %s, only image/jpeg, image/png, image/gif and image/webp are supported", mimeType), "image_url_invalid", http.StatusBadRequest)
+		if !options.transcode {
+			return nil, unsupportedErr
+		}
 
-	headers := p.GetRequestHeaders()
-	if request.Stream {
-		headers["Accept"] = "text/event-stream"
+		data, err = image.TranscodeToPNG(data)
+		if err != nil {
+			return nil, unsupportedErr
+		}
+		mimeType = "image/png"
 	}
 
-	claudeRequest, errWithCode := convertFromChatOpenai(request)
-	if errWithCode != nil {
-		return nil, errWithCode
+	// Claude 没有 detail 参数，low 时缩小图片以节省 tokens，high 和 auto 保持原始分辨率
+	maxDimension := options.maxDimension
+	if imageURL.Detail == "low" && (maxDimension <= 0 || maxDimension > lowDetailImageDimension) {
+		maxDimension = lowDetailImageDimension
 	}
 
-	// 创建请求
-	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(claudeRequest), p.Requester.WithHeader(headers))
+	mimeType, data, err = image.ResizeImage(mimeType, data, maxDimension, options.maxBytes)
+	if errors.Is(err, image.ErrImageTooLarge) {
+		return nil, common.StringErrorWrapper(fmt.Sprintf("image exceeds the limit of %d pixels per side and %d bytes even after downscaling", maxDimension, options.maxBytes), "image_too_large", http.StatusBadRequest)
+	}
 	if err != nil {
-		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+		return nil, common.ErrorWrapper(err, "image_url_invalid", http.StatusBadRequest)
 	}
 
-	return req, nil
+	return &MessageContent{
+		Type: "image",
+		Source: &ContentSource{
+			Type:      "base64",
+			MediaType: mimeType,
+			Data:      data,
+		},
+	}, nil
 }
 
-func convertFromChatOpenai(request *types.ChatCompletionRequest) (*ClaudeRequest, *types.OpenAIErrorWithStatusCode) {
-	claudeRequest := ClaudeRequest{
-		Model:         request.Model,
-		Messages:      []Message{},
-		System:        "",
-		MaxTokens:     request.MaxTokens,
-		StopSequences: nil,
-		Temperature:   request.Temperature,
-		TopP:          request.TopP,
-		Stream:        request.Stream,
+// Claude 目前只支持 PDF 文档
+func convertDocument(file *types.ChatMessageFile) (*MessageContent, *types.OpenAIErrorWithStatusCode) {
+	mimeType, data, err := image.GetFileFromUrl(file.FileData)
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "file_invalid", http.StatusBadRequest)
 	}
-	if claudeRequest.MaxTokens == 0 {
-		claudeRequest.MaxTokens = 4096
+
+	if mimeType != "application/pdf" {
+		return nil, common.StringErrorWrapper(fmt.Sprintf("unsupported file type %s, only application/pdf is supported", mimeType), "file_type_unsupported", http.StatusBadRequest)
 	}
 
-	for _, message := range request.Messages {
-		if message.Role == "system" {
-			claudeRequest.System = message.Content.(string)
-			continue
+	return &MessageContent{
+		Type: "document",
+		Source: &ContentSource{
+			Type:      "base64",
+			MediaType: mimeType,
+			Data:      data,
+		},
+	}, nil
+}
+
+// 将 OpenAI 的 tool_choice 转换为 Claude 的 tool_choice，required 表示必须调用任意一个工具，对应 Claude 的 any
+func convertToolChoice(toolChoice any) *ToolChoice {
+	switch choice := toolChoice.(type) {
+	case string:
+		switch choice {
+		case "auto":
+			return &ToolChoice{Type: "auto"}
+		case "required":
+			return &ToolChoice{Type: "any"}
+		case "none":
+			return &ToolChoice{Type: "none"}
 		}
-		content := Message{
-			Role:    convertRole(message.Role),
-			Content: []MessageContent{},
+	case map[string]any:
+		if function, ok := choice["function"].(map[string]any); ok {
+			if name, ok := function["name"].(string); ok && name != "" {
+				return &ToolChoice{Type: "tool", Name: name}
+			}
 		}
+	}
 
-		openaiContent := message.ParseContent()
-		for _, part := range openaiContent {
-			if part.Type == types.ContentTypeText {
-				content.Content = append(content.Content, MessageContent{
-					Type: "text",
-					Text: part.Text,
-				})
-				continue
-			}
+	return nil
+}
 
-			if part.Type == types.ContentTypeImageURL {
-				mimeType, data, err := image.GetImageFromUrl(part.ImageURL.URL)
-				if err != nil {
-					return nil, common.ErrorWrapper(err, "image_url_invalid", http.StatusBadRequest)
+// 工具结果包含图片时按内容块发送，如 computer 工具返回的截图，否则发送文本
+func convertToolResultContent(message *types.ChatCompletionMessage, images map[types.ChatMessageImageURL]*MessageContent, imageOptions imageOptions) (any, *types.OpenAIErrorWithStatusCode) {
+	parts := message.ParseContent()
+	hasImage := false
+	for _, part := range parts {
+		if part.Type == types.ContentTypeImageURL {
+			hasImage = true
+			break
+		}
+	}
+	if !hasImage {
+		// 空的工具结果不带 content，避免生成空文本
+		text := message.StringContent()
+		if strings.TrimSpace(text) == "" {
+			return nil, nil
+		}
+		return text, nil
+	}
+
+	contents := make([]MessageContent, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case types.ContentTypeText:
+			if strings.TrimSpace(part.Text) != "" {
+				contents = append(contents, MessageContent{Type: "text", Text: part.Text})
+			}
+		case types.ContentTypeImageURL:
+			imageContent, ok := images[*part.ImageURL]
+			if !ok {
+				var errWithCode *types.OpenAIErrorWithStatusCode
+				imageContent, errWithCode = convertImage(part.ImageURL, imageOptions)
+				if errWithCode != nil {
+					return nil, errWithCode
 				}
-				content.Content = append(content.Content, MessageContent{
-					Type: "image",
-					Source: &ContentSource{
-						Type:      "base64",
-						MediaType: mimeType,
-						Data:      data,
-					},
-				})
+				images[*part.ImageURL] = imageContent
 			}
+			contents = append(contents, *imageContent)
 		}
-		claudeRequest.Messages = append(claudeRequest.Messages, content)
 	}
 
-	return &claudeRequest, nil
+	return contents, nil
 }
 
-func (p *ClaudeProvider) convertToChatOpenai(response *ClaudeResponse, request *types.ChatCompletionRequest) (openaiResponse *types.ChatCompletionResponse, errWithCode *types.OpenAIErrorWithStatusCode) {
-	error := errorHandle(&response.Error)
-	if error != nil {
-		errWithCode = &types.OpenAIErrorWithStatusCode{
-			OpenAIError: *error,
-			StatusCode:  http.StatusBadRequest,
+// Claude 要求 user 和 assistant 交替出现，连续的同角色消息合并为一条，如连续的工具结果
+func mergeSameRoleMessages(messages []Message) []Message {
+	merged := make([]Message, 0, len(messages))
+	for _, message := range messages {
+		if last := len(merged) - 1; last >= 0 && merged[last].Role == message.Role {
+			merged[last].Content = append(merged[last].Content, message.Content...)
+			continue
+		}
+		merged = append(merged, message)
+	}
+	return merged
+}
+
+// 预填充的 assistant 消息不能以空白结尾，去除末尾文本块结尾的空白
+func trimPrefill(claudeRequest *ClaudeRequest) {
+	last := len(claudeRequest.Messages) - 1
+	if last < 0 || claudeRequest.Messages[last].Role != types.ChatMessageRoleAssistant {
+		return
+	}
+
+	message := &claudeRequest.Messages[last]
+	lastContent := len(message.Content) - 1
+	if message.Content[lastContent].Type != "text" {
+		return
+	}
+
+	message.Content[lastContent].Text = strings.TrimRightFunc(message.Content[lastContent].Text, unicode.IsSpace)
+}
+
+// 最后一条消息为 assistant 的文本时作为回复的预填充，与发送给 Claude 的一致，不含末尾的空白
+func getPrefill(request *types.ChatCompletionRequest) string {
+	if len(request.Messages) == 0 {
+		return ""
+	}
+
+	message := request.Messages[len(request.Messages)-1]
+	if message.Role != types.ChatMessageRoleAssistant || len(message.ToolCalls) > 0 || message.FunctionCall != nil {
+		return ""
+	}
+
+	var prefill strings.Builder
+	for _, part := range message.ParseContent() {
+		if part.Type == types.ContentTypeText && strings.TrimSpace(part.Text) != "" {
+			prefill.WriteString(part.Text)
+		}
+	}
+	return strings.TrimRightFunc(prefill.String(), unicode.IsSpace)
+}
+
+// 请求只使用了旧版的 functions/function_call
+func isLegacyFunctions(request *types.ChatCompletionRequest) bool {
+	return request.Tools == nil && request.Functions != nil
+}
+
+// 将旧版的 functions/function_call 转换为 tools/tool_choice
+func convertLegacyFunctions(request *types.ChatCompletionRequest) ([]*types.ChatCompletionTool, any) {
+	tools := make([]*types.ChatCompletionTool, 0, len(request.Functions))
+	for _, function := range request.Functions {
+		tools = append(tools, &types.ChatCompletionTool{
+			Type:     "function",
+			Function: *function,
+		})
+	}
+
+	// function_call 为 {"name": "..."} 时指定调用该函数
+	toolChoice := request.FunctionCall
+	if functionCall, ok := request.FunctionCall.(map[string]any); ok {
+		toolChoice = map[string]any{
+			"type":     "function",
+			"function": functionCall,
 		}
+	}
+
+	return tools, toolChoice
+}
+
+func getLegacyCallId(index int) string {
+	return fmt.Sprintf("call_function_%d", index)
+}
+
+// 将 OpenAI 的 tool_call 转换为 Claude 的 tool_use
+func convertToolCall(toolCall *types.ChatCompletionToolCalls) (*MessageContent, *types.OpenAIErrorWithStatusCode) {
+	if toolCall.Function == nil {
+		return nil, common.StringErrorWrapper("tool call function is empty", "invalid_tool_calls", http.StatusBadRequest)
+	}
+
+	arguments := toolCall.Function.Arguments
+	if arguments == "" {
+		arguments = "{}"
+	}
+	if !json.Valid([]byte(arguments)) {
+		return nil, common.StringErrorWrapper("tool call arguments is not valid json", "invalid_tool_calls", http.StatusBadRequest)
+	}
+
+	return &MessageContent{
+		Type:  "tool_use",
+		Id:    toolCall.Id,
+		Name:  toolCall.Function.Name,
+		Input: json.RawMessage(arguments),
+	}, nil
+}
+
+func (p *ClaudeProvider) convertToChatOpenai(response *ClaudeResponse, request *types.ChatCompletionRequest) (openaiResponse *types.ChatCompletionResponse, errWithCode *types.OpenAIErrorWithStatusCode) {
+	errWithCode = errorHandleWithStatusCode(&response.Error)
+	if errWithCode != nil {
 		return
 	}
 
+	// content 可能为空，或者第一个块不是文本，多个文本块（如带引用时）按顺序拼接，与流式一致
+	content := ""
+	reasoningContent := ""
+	var citations []json.RawMessage
+	for _, block := range response.Content {
+		if block.Type == "thinking" {
+			reasoningContent += block.Thinking
+			continue
+		}
+		if block.Type == "text" {
+			content += block.Text
+		}
+		citations = append(citations, block.Citations...)
+	}
+
+	// 预填充时 Claude 只返回后续内容，拼接后返回完整的回复
+	if prefill := getPrefill(request); prefill != "" {
+		content = prefill + content
+	} else {
+		content = strings.TrimPrefix(content, " ")
+	}
+
 	choice := types.ChatCompletionChoice{
 		Index: 0,
 		Message: types.ChatCompletionMessage{
-			Role:    response.Role,
-			Content: strings.TrimPrefix(response.Content[0].Text, " "),
-			Name:    nil,
+			Role:             response.Role,
+			Content:          content,
+			ReasoningContent: reasoningContent,
+			Name:             nil,
 		},
 		FinishReason: stopReasonClaude2OpenAI(response.StopReason),
 	}
+	if len(citations) > 0 {
+		choice.Message.Annotations = citations
+	}
+
+	// stop_sequence 和 end_turn 都映射为 stop，命中的停止序列放在 finish_details 中
+	if response.StopReason == "stop_sequence" {
+		choice.FinishDetails = &FinishDetails{
+			Type: "stop_sequence",
+			Stop: response.StopSequence,
+		}
+	}
+
+	jsonSchemaTool := getJsonSchemaToolName(request)
+	for _, content := range response.Content {
+		if content.Type != "tool_use" {
+			continue
+		}
+		if jsonSchemaTool != "" && content.Name == jsonSchemaTool {
+			choice.Message.Content = string(content.Input)
+			choice.FinishReason = types.FinishReasonStop
+			continue
+		}
+		choice.Message.ToolCalls = append(choice.Message.ToolCalls, &types.ChatCompletionToolCalls{
+			Id:    content.Id,
+			Type:  "function",
+			Index: len(choice.Message.ToolCalls),
+			Function: &types.ChatCompletionToolCallsFunction{
+				Name:      content.Name,
+				Arguments: string(content.Input),
+			},
+		})
+	}
+
+	// 旧版 function_call 只能表示一个调用
+	if isLegacyFunctions(request) && len(choice.Message.ToolCalls) > 0 {
+		choice.Message.FunctionCall = choice.Message.ToolCalls[0].Function
+		choice.Message.ToolCalls = nil
+		choice.FinishReason = types.FinishReasonFunctionCall
+	}
+
+	// 返回 Claude 实际使用的模型，未返回或请求使用别名时返回请求的模型
+	responseModel := p.getResponseModel(request.Model, response.Model)
+
 	openaiResponse = &types.ChatCompletionResponse{
 		ID:      response.Id,
 		Object:  "chat.completion",
 		Created: common.GetTimestamp(),
 		Choices: []types.ChatCompletionChoice{choice},
-		Model:   request.Model,
+		Model:   responseModel,
 		Usage: &types.Usage{
 			CompletionTokens: 0,
 			PromptTokens:     0,
@@ -176,27 +1419,36 @@ func (p *ClaudeProvider) convertToChatOpenai(response *ClaudeResponse, request *
 
 	completionTokens := response.Usage.OutputTokens
 
-	promptTokens := response.Usage.InputTokens
+	promptTokens := response.Usage.GetPromptTokens()
+	if promptTokens == 0 {
+		promptTokens = p.getPromptTokens(request)
+	}
 
 	openaiResponse.Usage.PromptTokens = promptTokens
 	openaiResponse.Usage.CompletionTokens = completionTokens
 	openaiResponse.Usage.TotalTokens = promptTokens + completionTokens
-
-	*p.Usage = *openaiResponse.Usage
+	openaiResponse.Usage.CacheCreationInputTokens = response.Usage.CacheCreationInputTokens
+	openaiResponse.Usage.CacheReadInputTokens = response.Usage.CacheReadInputTokens
+	openaiResponse.Usage.ServiceTier = response.Usage.ServiceTier
+	openaiResponse.ServiceTier = response.Usage.ServiceTier
+	openaiResponse.Usage.CompletionTokensDetails = &types.CompletionTokensDetails{
+		ReasoningTokens: countReasoningTokens(reasoningContent, completionTokens, request.Model),
+	}
+	setUsageDetails(openaiResponse.Usage)
 
 	return openaiResponse, nil
 }
 
 // 转换为OpenAI聊天流式请求体
 func (h *claudeStreamHandler) handlerStream(rawLine *[]byte, dataChan chan string, errChan chan error) {
-	// 如果rawLine 前缀不为data:，则直接返回
-	if !strings.HasPrefix(string(*rawLine), `data: {"type"`) {
+	// 只处理 data: 行，event: 行由 data 中的 type 代替
+	if !strings.HasPrefix(string(*rawLine), "data:") {
 		*rawLine = nil
 		return
 	}
 
-	// 去除前缀
-	*rawLine = (*rawLine)[6:]
+	// 去除前缀，data: 后的空格可有可无
+	*rawLine = bytes.TrimSpace((*rawLine)[5:])
 
 	var claudeResponse ClaudeStreamResponse
 	err := json.Unmarshal(*rawLine, &claudeResponse)
@@ -207,11 +1459,25 @@ func (h *claudeStreamHandler) handlerStream(rawLine *[]byte, dataChan chan strin
 
 	error := errorHandle(&claudeResponse.Error)
 	if error != nil {
+		// 已经返回了部分内容时，先发送结束块告知客户端流异常结束
+		if h.streamStarted {
+			h.flushToolCalls(dataChan)
+			finishReason := "error"
+			h.sendStreamChoice(types.ChatCompletionStreamChoice{FinishReason: &finishReason}, dataChan)
+		}
+		h.audit.finish(h.Usage, error.Message)
+		h.timer.finish(h.getModel(), error.Message)
 		errChan <- error
+		*rawLine = requester.StreamClosed
 		return
 	}
 
 	if claudeResponse.Type == "message_stop" {
+		if h.Request.IncludeUsage() {
+			h.sendUsage(dataChan)
+		}
+		h.audit.finish(h.Usage, "")
+		h.timer.finish(h.getModel(), "")
 		errChan <- io.EOF
 		*rawLine = requester.StreamClosed
 		return
@@ -219,26 +1485,239 @@ func (h *claudeStreamHandler) handlerStream(rawLine *[]byte, dataChan chan strin
 
 	switch claudeResponse.Type {
 	case "message_start":
+		h.model = claudeResponse.Message.Model
+		claudeResponse.Delta.Text = h.prefill
 		h.convertToOpenaiStream(&claudeResponse, dataChan)
-		h.Usage.PromptTokens = claudeResponse.Message.Usage.InputTokens
+		promptTokens := claudeResponse.Message.Usage.GetPromptTokens()
+		if promptTokens == 0 {
+			promptTokens = estimatePromptTokens(h.relayPromptTokens, h.Request)
+		}
+		h.Usage.PromptTokens = promptTokens
+		h.Usage.CacheCreationInputTokens = claudeResponse.Message.Usage.CacheCreationInputTokens
+		h.Usage.CacheReadInputTokens = claudeResponse.Message.Usage.CacheReadInputTokens
+		h.Usage.ServiceTier = claudeResponse.Message.Usage.ServiceTier
 
 	case "message_delta":
 		h.convertToOpenaiStream(&claudeResponse, dataChan)
 		h.Usage.CompletionTokens = claudeResponse.Usage.OutputTokens
 		h.Usage.TotalTokens = h.Usage.PromptTokens + h.Usage.CompletionTokens
+		h.Usage.CompletionTokensDetails = &types.CompletionTokensDetails{
+			ReasoningTokens: countReasoningTokens(h.reasoning.String(), h.Usage.CompletionTokens, h.Request.Model),
+		}
+		setUsageDetails(h.Usage)
+
+	case "content_block_start":
+		if claudeResponse.ContentBlock.Type == "tool_use" && h.jsonSchemaTool != "" && claudeResponse.ContentBlock.Name == h.jsonSchemaTool {
+			h.inJsonBlock = true
+			h.jsonResponded = true
+			return
+		}
+		if claudeResponse.ContentBlock.Type == "tool_use" {
+			h.timer.recordFirstToken()
+			h.startToolCall(&claudeResponse, dataChan)
+			return
+		}
+		// 预填充 assistant 回复时，块开始事件可能已经带有文本
+		if claudeResponse.ContentBlock.Text != "" || claudeResponse.ContentBlock.Thinking != "" {
+			h.timer.recordFirstToken()
+			claudeResponse.Delta.Text = claudeResponse.ContentBlock.Text
+			claudeResponse.Delta.Thinking = claudeResponse.ContentBlock.Thinking
+			h.convertToOpenaiStream(&claudeResponse, dataChan)
+		}
 
 	case "content_block_delta":
+		if claudeResponse.Delta.Type != "signature_delta" {
+			h.timer.recordFirstToken()
+		}
+		if claudeResponse.Delta.Type == "input_json_delta" && h.inJsonBlock {
+			claudeResponse.Delta.Text = claudeResponse.Delta.PartialJson
+			h.convertToOpenaiStream(&claudeResponse, dataChan)
+			return
+		}
+		if claudeResponse.Delta.Type == "input_json_delta" {
+			if toolCall, ok := h.toolCalls[claudeResponse.Index]; ok {
+				toolCall.arguments.WriteString(claudeResponse.Delta.PartialJson)
+			}
+			return
+		}
+		// 思考内容的签名不需要返回给客户端
+		if claudeResponse.Delta.Type == "signature_delta" {
+			return
+		}
+		// 多字节字符或转义的代理对可能被拆分到两个增量中，解码后会变成替换字符，需要按原始字节拼接
+		if claudeResponse.Delta.Type == "text_delta" && (len(h.partialText) > 0 || strings.ContainsRune(claudeResponse.Delta.Text, utf8.RuneError)) {
+			if text, ok := h.completeText(*rawLine); ok {
+				claudeResponse.Delta.Text = text
+			}
+			if claudeResponse.Delta.Text == "" {
+				return
+			}
+		}
 		h.convertToOpenaiStream(&claudeResponse, dataChan)
 
+	case "content_block_stop":
+		h.inJsonBlock = false
+		h.flushToolCall(claudeResponse.Index, dataChan)
+		// 块结束时仍不完整的字节无法再补全
+		if len(h.partialText) > 0 {
+			h.partialText = nil
+			claudeResponse.Delta.Text = string(utf8.RuneError)
+			h.convertToOpenaiStream(&claudeResponse, dataChan)
+		}
+
+	// ping 为保活事件，无需处理
 	default:
 		return
 	}
 }
 
-func (h *claudeStreamHandler) convertToOpenaiStream(claudeResponse *ClaudeStreamResponse, dataChan chan string) {
-	choice := types.ChatCompletionStreamChoice{
-		Index: claudeResponse.Index,
+// 拼接上一个增量留下的原始字节后用 encoding/json 解码，末尾不完整的部分留到下一个增量
+func (h *claudeStreamHandler) completeText(line []byte) (string, bool) {
+	var rawResponse struct {
+		Delta struct {
+			Text json.RawMessage `json:"text"`
+		} `json:"delta"`
+	}
+	if err := json.Unmarshal(line, &rawResponse); err != nil {
+		return "", false
+	}
+	literal := rawResponse.Delta.Text
+	if len(literal) < 2 || literal[0] != '"' {
+		return "", false
+	}
+
+	// json.RawMessage 保留了原始字节，被拆分的多字节字符不会被替换
+	text := append(h.partialText, literal[1:len(literal)-1]...)
+	h.partialText = nil
+	if cut := incompleteTextSuffix(text); cut > 0 {
+		h.partialText = append([]byte(nil), text[len(text)-cut:]...)
+		text = text[:len(text)-cut]
+	}
+
+	var decoded string
+	if err := json.Unmarshal(append(append([]byte{'"'}, text...), '"'), &decoded); err != nil {
+		return "", false
+	}
+	return decoded, true
+}
+
+// 返回末尾需要等待下一个增量的字节数：不完整的 UTF-8 字符，或代理对的前半个 \u 转义
+func incompleteTextSuffix(text []byte) int {
+	if n := len(text); n >= 6 && text[n-6] == '\\' && text[n-5] == 'u' {
+		backslashes := 0
+		for i := n - 6; i >= 0 && text[i] == '\\'; i-- {
+			backslashes++
+		}
+		code, err := strconv.ParseUint(string(text[n-4:]), 16, 16)
+		if err == nil && backslashes%2 == 1 && utf16.IsSurrogate(rune(code)) && code < 0xdc00 {
+			return 6
+		}
+	}
+
+	for i := 1; i <= utf8.UTFMax && i <= len(text); i++ {
+		c := text[len(text)-i]
+		if !utf8.RuneStart(c) {
+			continue
+		}
+		if c >= utf8.RuneSelf && !utf8.FullRune(text[len(text)-i:]) {
+			return i
+		}
+		return 0
+	}
+	return 0
+}
+
+// 开始一个工具调用，先发送工具的 id 和名称
+func (h *claudeStreamHandler) startToolCall(claudeResponse *ClaudeStreamResponse, dataChan chan string) {
+	if h.legacyFunctions && h.toolIndex > 0 {
+		return
+	}
+
+	toolCall := &streamToolCall{
+		call: types.ChatCompletionToolCalls{
+			Id:    claudeResponse.ContentBlock.Id,
+			Type:  "function",
+			Index: h.toolIndex,
+		},
+	}
+	h.toolIndex++
+	if h.toolCalls == nil {
+		h.toolCalls = make(map[int]*streamToolCall)
+	}
+	h.toolCalls[claudeResponse.Index] = toolCall
+
+	// 只有一个 choice，claudeResponse.Index 是内容块的序号，不能作为 choice 的序号
+	choice := types.ChatCompletionStreamChoice{}
+	function := &types.ChatCompletionToolCallsFunction{
+		Name:      claudeResponse.ContentBlock.Name,
+		Arguments: "",
+	}
+	if h.legacyFunctions {
+		choice.Delta.FunctionCall = function
+	} else {
+		choice.Delta.ToolCalls = []*types.ChatCompletionToolCalls{
+			{
+				Id:       toolCall.call.Id,
+				Type:     toolCall.call.Type,
+				Index:    toolCall.call.Index,
+				Function: function,
+			},
+		}
+	}
+
+	h.sendStreamChoice(choice, dataChan)
+}
+
+// 工具调用结束时，发送缓存的参数
+func (h *claudeStreamHandler) flushToolCall(blockIndex int, dataChan chan string) {
+	toolCall, ok := h.toolCalls[blockIndex]
+	if !ok {
+		return
+	}
+	delete(h.toolCalls, blockIndex)
+
+	arguments := toolCall.arguments.String()
+	if arguments == "" {
+		arguments = "{}"
+	}
+
+	choice := types.ChatCompletionStreamChoice{}
+	function := &types.ChatCompletionToolCallsFunction{
+		Arguments: arguments,
+	}
+	if h.legacyFunctions {
+		choice.Delta.FunctionCall = function
+	} else {
+		choice.Delta.ToolCalls = []*types.ChatCompletionToolCalls{
+			{
+				Id:       toolCall.call.Id,
+				Type:     toolCall.call.Type,
+				Index:    toolCall.call.Index,
+				Function: function,
+			},
+		}
+	}
+
+	h.sendStreamChoice(choice, dataChan)
+}
+
+// 流异常结束时，按工具调用的顺序发送所有未结束的工具调用
+func (h *claudeStreamHandler) flushToolCalls(dataChan chan string) {
+	blockIndexes := make([]int, 0, len(h.toolCalls))
+	for blockIndex := range h.toolCalls {
+		blockIndexes = append(blockIndexes, blockIndex)
+	}
+	sort.Slice(blockIndexes, func(i, j int) bool {
+		return h.toolCalls[blockIndexes[i]].call.Index < h.toolCalls[blockIndexes[j]].call.Index
+	})
+
+	for _, blockIndex := range blockIndexes {
+		h.flushToolCall(blockIndex, dataChan)
 	}
+}
+
+func (h *claudeStreamHandler) convertToOpenaiStream(claudeResponse *ClaudeStreamResponse, dataChan chan string) {
+	choice := types.ChatCompletionStreamChoice{}
 
 	if claudeResponse.Message.Role != "" {
 		choice.Delta.Role = claudeResponse.Message.Role
@@ -248,18 +1727,74 @@ func (h *claudeStreamHandler) convertToOpenaiStream(claudeResponse *ClaudeStream
 		choice.Delta.Content = claudeResponse.Delta.Text
 	}
 
+	if claudeResponse.Delta.Thinking != "" {
+		choice.Delta.ReasoningContent = claudeResponse.Delta.Thinking
+		h.reasoning.WriteString(claudeResponse.Delta.Thinking)
+	}
+
+	// citations_delta 每次返回一条引用
+	if claudeResponse.Delta.Citation != nil {
+		choice.Delta.Annotations = []json.RawMessage{claudeResponse.Delta.Citation}
+	}
+
 	finishReason := stopReasonClaude2OpenAI(claudeResponse.Delta.StopReason)
+	if claudeResponse.Delta.StopReason == "tool_use" && h.jsonResponded {
+		finishReason = types.FinishReasonStop
+	} else if claudeResponse.Delta.StopReason == "tool_use" && h.legacyFunctions {
+		finishReason = types.FinishReasonFunctionCall
+	}
 	if finishReason != "" {
 		choice.FinishReason = &finishReason
 	}
+	// 与非流式一致，命中的停止序列放在 finish_details 中
+	if claudeResponse.Delta.StopReason == "stop_sequence" {
+		choice.FinishDetails = &FinishDetails{
+			Type: "stop_sequence",
+			Stop: claudeResponse.Delta.StopSequence,
+		}
+	}
+	h.audit.appendCompletion(choice.Delta.Content)
+	h.audit.setFinishReason(finishReason)
+
+	h.sendStreamChoice(choice, dataChan)
+}
+
+func (h *claudeStreamHandler) getModel() string {
+	if h.modelAlias != "" {
+		return h.modelAlias
+	}
+	if h.model != "" {
+		return h.model
+	}
+	return h.Request.Model
+}
+
+// stream_options.include_usage 要求最后返回一个 choices 为空、带 usage 的块
+func (h *claudeStreamHandler) sendUsage(dataChan chan string) {
+	usage := *h.Usage
+	chatCompletion := types.ChatCompletionStreamResponse{
+		ID:      fmt.Sprintf("chatcmpl-%s", common.GetUUID()),
+		Object:  "chat.completion.chunk",
+		Created: common.GetTimestamp(),
+		Model:   h.getModel(),
+		Choices: []types.ChatCompletionStreamChoice{},
+		Usage:   &usage,
+	}
+
+	responseBody, _ := json.Marshal(chatCompletion)
+	dataChan <- string(responseBody)
+}
+
+func (h *claudeStreamHandler) sendStreamChoice(choice types.ChatCompletionStreamChoice, dataChan chan string) {
 	chatCompletion := types.ChatCompletionStreamResponse{
 		ID:      fmt.Sprintf("chatcmpl-%s", common.GetUUID()),
 		Object:  "chat.completion.chunk",
 		Created: common.GetTimestamp(),
-		Model:   h.Request.Model,
+		Model:   h.getModel(),
 		Choices: []types.ChatCompletionStreamChoice{choice},
 	}
 
+	h.streamStarted = true
 	responseBody, _ := json.Marshal(chatCompletion)
 	dataChan <- string(responseBody)
 }
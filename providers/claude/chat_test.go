@@ -0,0 +1,1570 @@
+package claude_test
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common/requester"
+	"one-api/common/test"
+	_ "one-api/common/test/init"
+	"one-api/model"
+	"one-api/providers/base"
+	"one-api/types"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatCompletionsEmptyContent(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	response := `{"id":"msg_01XFDUDYJgAACzvnptvVoYEL","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":"max_tokens","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":0}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	chatRequest := test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false")
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	assert.NotPanics(t, func() {
+		openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+		assert.Nil(t, errWithCode)
+		assert.Equal(t, "", openaiResponse.Choices[0].Message.Content)
+		assert.Equal(t, types.FinishReasonLength, openaiResponse.Choices[0].FinishReason)
+	})
+}
+
+func TestChatCompletionsNonTextFirstBlock(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	response := `{"id":"msg_01XFDUDYJgAACzvnptvVoYEL","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"tool_use","id":"toolu_01","name":"get_current_weather","input":{}},{"type":"text","text":"Checking."}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":6}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	chatRequest := test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false")
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Checking.", openaiResponse.Choices[0].Message.Content)
+}
+
+func TestChatCompletionsSystemString(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	chatRequest := test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false")
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "You are a helpful assistant.", requestBody["system"])
+}
+
+func TestChatCompletionsStopSequences(t *testing.T) {
+	tests := []struct {
+		name     string
+		stop     string
+		expected []any
+	}{
+		{"string", `"\n\nHuman:"`, []any{"\n\nHuman:"}},
+		{"array", `["END", "STOP"]`, []any{"END", "STOP"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"stop_sequence","stop_sequence":"END","usage":{"input_tokens":12,"output_tokens":3}}`
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"stop":` + tt.stop + `}`)
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, tt.expected, requestBody["stop_sequences"])
+			assert.Equal(t, types.FinishReasonStop, openaiResponse.Choices[0].FinishReason)
+		})
+	}
+}
+
+func TestChatCompletionsMultipleSystem(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-opus-20240229",
+		"messages": [
+			{"role": "system", "content": "You are a helpful assistant."},
+			{"role": "system", "content": "Answer in English."},
+			{"role": "user", "content": "Hello!"},
+			{"role": "system", "content": "Be brief."}
+		]
+	}`)
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "You are a helpful assistant.\nAnswer in English.\nBe brief.", requestBody["system"])
+	assert.Len(t, requestBody["messages"], 1)
+}
+
+func TestChatCompletionsDocument(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"It is a PDF."}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, "hello")
+	}))
+	defer fileServer.Close()
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	pdf := "JVBERi0xLjQKJcfsj6IKMSAwIG9iago8PC9UeXBlL0NhdGFsb2c+PgplbmRvYmoKdHJhaWxlcgo8PC9Sb290IDEgMCBSPj4KJSVFT0YK"
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":[
+		{"type":"file","file":{"filename":"test.pdf","file_data":"data:application/pdf;base64,` + pdf + `"}},
+		{"type":"text","text":"What is this document?"}
+	]}]}`)
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	content := requestBody["messages"].([]any)[0].(map[string]any)["content"].([]any)
+	assert.Equal(t, map[string]any{
+		"type": "document",
+		"source": map[string]any{
+			"type":       "base64",
+			"media_type": "application/pdf",
+			"data":       pdf,
+		},
+	}, content[0])
+
+	chatRequest = getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":[
+		{"type":"file","file":{"filename":"test.txt","file_data":"` + fileServer.URL + `/test.txt"}}
+	]}]}`)
+	_, errWithCode = chatProvider.CreateChatCompletion(chatRequest)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "file_type_unsupported", errWithCode.Code)
+	assert.Contains(t, errWithCode.Message, "text/plain")
+}
+
+func TestChatCompletionsMetadataUser(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"user":"user-123"}`)
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, map[string]any{"user_id": "user-123"}, requestBody["metadata"])
+
+	requestBody = map[string]any{}
+	chatRequest = getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}]}`)
+	_, errWithCode = chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.NotContains(t, requestBody, "metadata")
+}
+
+func TestChatCompletionsMatchedStopSequence(t *testing.T) {
+	tests := []struct {
+		name          string
+		response      string
+		finishDetails any
+	}{
+		{
+			"stop_sequence",
+			`{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"stop_sequence","stop_sequence":"END","usage":{"input_tokens":12,"output_tokens":3}}`,
+			map[string]any{"type": "stop_sequence", "stop": "END"},
+		},
+		{
+			"end_turn",
+			`{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, tt.response))
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"stop":["END"]}`)
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, types.FinishReasonStop, openaiResponse.Choices[0].FinishReason)
+
+			// 以客户端收到的 JSON 为准
+			responseBody, _ := json.Marshal(openaiResponse)
+			var body map[string]any
+			json.Unmarshal(responseBody, &body)
+			choice := body["choices"].([]any)[0].(map[string]any)
+			assert.Equal(t, tt.finishDetails, choice["finish_details"])
+		})
+	}
+}
+
+func TestChatCompletionsStreamMatchedStopSequence(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi!"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"stop_sequence","stop_sequence":"END"},"usage":{"output_tokens":3}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"stop":["END"],"stream":true}`)
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	responses := readChatStream(t, stream)
+	last := responses[len(responses)-1]
+	assert.Equal(t, types.FinishReasonStop, last.Choices[0].FinishReason)
+	assert.Equal(t, map[string]any{"type": "stop_sequence", "stop": "END"}, last.Choices[0].FinishDetails)
+
+	// 其他块不带 finish_details
+	for _, response := range responses[:len(responses)-1] {
+		assert.Nil(t, response.Choices[0].FinishDetails)
+	}
+}
+
+func TestChatCompletionsMultipleTextBlocks(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"The sky is blue"},{"type":"text","text":" because of Rayleigh scattering."}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":9}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "false"))
+	assert.Nil(t, errWithCode)
+
+	// 所有文本块按顺序拼接
+	assert.Equal(t, "The sky is blue because of Rayleigh scattering.", openaiResponse.Choices[0].Message.Content)
+}
+
+func TestChatCompletionsStreamPingAndFraming(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type": "message_start", "message": {"id": "msg_01", "type": "message", "role": "assistant", "model": "claude-3-opus-20240229", "content": [], "stop_reason": null, "usage": {"input_tokens": 12, "output_tokens": 1}}}`,
+		"event: ping\n" + `data: {"type": "ping"}`,
+		"event: content_block_start\n" + `data:{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: ping\n" + `data: {"type": "ping"}`,
+		"event: content_block_delta\n" + `data:   {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hello"}}`,
+		"event: ping\n" + `data: {"type": "ping"}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" world"}}`,
+		"event: content_block_stop\n" + `data: {"type": "content_block_stop", "index": 0}`,
+		"event: message_delta\n" + `data: {"type": "message_delta", "delta": {"stop_reason": "end_turn", "stop_sequence": null}, "usage": {"output_tokens": 5}}`,
+		"event: message_stop\n" + `data: {"type": "message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	content := ""
+	var finishReason any
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			content += choice.Delta.Content
+			if choice.FinishReason != nil {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+
+	assert.Equal(t, "Hello world", content)
+	assert.Equal(t, types.FinishReasonStop, finishReason)
+	assert.Equal(t, 12, usage.PromptTokens)
+	assert.Equal(t, 5, usage.CompletionTokens)
+}
+
+func TestChatCompletionsStreamPrefill(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":20,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":" Paris"}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":", of course."}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":6}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(&requestBody, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-opus-20240229",
+		"stream": true,
+		"messages": [
+			{"role": "user", "content": "What is the capital of France?"},
+			{"role": "assistant", "content": "The capital of France is"}
+		]
+	}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	content := ""
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			content += choice.Delta.Content
+		}
+	}
+
+	// 返回的内容包含预填充部分
+	assert.Equal(t, "The capital of France is Paris, of course.", content)
+
+	messages := requestBody["messages"].([]any)
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "assistant", messages[1].(map[string]any)["role"])
+}
+
+const jsonSchemaRequest = `{
+	"model": "claude-3-5-sonnet-20241022",
+	"messages": [{"role": "user", "content": "Extract: John is 30 years old."}],
+	"response_format": {
+		"type": "json_schema",
+		"json_schema": {
+			"name": "person",
+			"schema": {
+				"type": "object",
+				"properties": {"name": {"type": "string"}, "age": {"type": "integer"}},
+				"required": ["name", "age"]
+			}
+		}
+	}
+}`
+
+func TestChatCompletionsJsonSchema(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"tool_use","id":"toolu_01","name":"person","input":{"name":"John","age":30}}],"stop_reason":"tool_use","usage":{"input_tokens":120,"output_tokens":20}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(getChatRequestFromJSON(jsonSchemaRequest))
+	assert.Nil(t, errWithCode)
+
+	assert.Equal(t, map[string]any{"type": "tool", "name": "person"}, requestBody["tool_choice"])
+	tools := requestBody["tools"].([]any)
+	assert.Len(t, tools, 1)
+	assert.Equal(t, "person", tools[0].(map[string]any)["name"])
+	assert.Equal(t, "object", tools[0].(map[string]any)["input_schema"].(map[string]any)["type"])
+
+	message := openaiResponse.Choices[0].Message
+	content, _ := message.Content.(string)
+	assert.True(t, json.Valid([]byte(content)))
+	assert.JSONEq(t, `{"name":"John","age":30}`, content)
+	assert.Empty(t, message.ToolCalls)
+	assert.Equal(t, types.FinishReasonStop, openaiResponse.Choices[0].FinishReason)
+}
+
+func TestChatCompletionsStreamJsonSchema(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":120,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_01","name":"person","input":{}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"name\": \"John\""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":", \"age\": 30}"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":20}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(jsonSchemaRequest)
+	chatRequest.Stream = true
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	content := ""
+	var finishReason any
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			assert.Empty(t, choice.Delta.ToolCalls)
+			content += choice.Delta.Content
+			if choice.FinishReason != nil {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+
+	assert.JSONEq(t, `{"name":"John","age":30}`, content)
+	assert.Equal(t, types.FinishReasonStop, finishReason)
+}
+
+func TestChatCompletionsStreamMidStreamError(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Once upon"}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" a time"}}`,
+		"event: error\n" + `data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" never sent"}}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Tell me a story"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+	defer stream.Close()
+
+	dataChan, errChan := stream.Recv()
+	var responses []types.ChatCompletionStreamResponse
+	var streamErr error
+	for streamErr == nil {
+		select {
+		case data := <-dataChan:
+			var response types.ChatCompletionStreamResponse
+			assert.Nil(t, json.Unmarshal([]byte(data), &response))
+			responses = append(responses, response)
+		case streamErr = <-errChan:
+		}
+	}
+
+	assert.Contains(t, streamErr.Error(), "Overloaded")
+
+	content := ""
+	for _, response := range responses {
+		content += response.Choices[0].Delta.Content
+	}
+	assert.Equal(t, "Once upon a time", content)
+
+	last := responses[len(responses)-1]
+	assert.Equal(t, "error", last.Choices[0].FinishReason)
+}
+
+// 每个事件之间间隔 delay 发送
+func handleClaudeSlowStreamEndpoint(events []string, delay time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			time.Sleep(delay)
+			fmt.Fprint(w, event+"\n\n")
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+var slowStreamEvents = []string{
+	"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+	"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Once upon"}}`,
+	"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" a time"}}`,
+	"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+	"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":5}}`,
+	"event: message_stop\n" + `data: {"type":"message_stop"}`,
+}
+
+func TestChatCompletionsStreamIdleTimeout(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	// 总耗时超过 timeout，但每个事件的间隔都小于 idle_timeout
+	server.RegisterHandler("/v1/messages", handleClaudeSlowStreamEndpoint(slowStreamEvents, 300*time.Millisecond))
+
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"timeout": "1", "idle_timeout": "1"}})
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Tell me a story"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	content := ""
+	for _, response := range readChatStream(t, stream) {
+		if len(response.Choices) > 0 {
+			content += response.Choices[0].Delta.Content
+		}
+	}
+	assert.Equal(t, "Once upon a time", content)
+}
+
+func TestChatCompletionsStreamIdleTimeoutExceeded(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, slowStreamEvents[0]+"\n\n")
+		w.(http.Flusher).Flush()
+		time.Sleep(1500 * time.Millisecond)
+	})
+
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"idle_timeout": "1"}})
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Tell me a story"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+	defer stream.Close()
+
+	dataChan, errChan := stream.Recv()
+	var streamErr error
+	for streamErr == nil {
+		select {
+		case <-dataChan:
+		case streamErr = <-errChan:
+		}
+	}
+	assert.ErrorIs(t, streamErr, requester.ErrIdleTimeout)
+}
+
+func TestChatCompletionsRefusal(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"I can't help with that."}],"stop_reason":"refusal","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":7}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}]}`)
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, types.FinishReasonContentFilter, openaiResponse.Choices[0].FinishReason)
+	assert.Equal(t, "I can't help with that.", openaiResponse.Choices[0].Message.Content)
+}
+
+func TestChatCompletionsStreamRefusal(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"I can't help with that."}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"refusal","stop_sequence":null},"usage":{"output_tokens":7}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	responses := readChatStream(t, stream)
+	content := ""
+	for _, response := range responses {
+		content += response.Choices[0].Delta.Content
+	}
+	assert.Equal(t, "I can't help with that.", content)
+	assert.Equal(t, types.FinishReasonContentFilter, responses[len(responses)-1].Choices[0].FinishReason)
+}
+
+func TestChatCompletionsMultipleChoices(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int
+		plugin   model.PluginType
+		expected int
+	}{
+		{"n=3", 3, nil, 3},
+		{"capped by max_n", 5, model.PluginType{"anthropic": {"max_n": "2"}}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			var calls int32
+			server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+				call := atomic.AddInt32(&calls, 1)
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"id":"msg_0%d","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Answer %d"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`, call, call)
+			})
+
+			channel := getClaudeChannel(url)
+			if tt.plugin != nil {
+				setClaudeChannelPlugin(&channel, tt.plugin)
+			}
+			chatProvider := getChatProvider(&channel, context)
+			usage := &types.Usage{}
+			chatProvider.SetUsage(usage)
+
+			chatRequest := getChatRequestFromJSON(fmt.Sprintf(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"n":%d}`, tt.n))
+			openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			assert.Nil(t, errWithCode)
+
+			assert.Equal(t, int32(tt.expected), atomic.LoadInt32(&calls))
+			assert.Len(t, openaiResponse.Choices, tt.expected)
+			contents := make(map[any]bool)
+			for i, choice := range openaiResponse.Choices {
+				assert.Equal(t, i, choice.Index)
+				assert.Equal(t, types.FinishReasonStop, choice.FinishReason)
+				contents[choice.Message.Content] = true
+			}
+			assert.Len(t, contents, tt.expected)
+
+			assert.Equal(t, 12*tt.expected, openaiResponse.Usage.PromptTokens)
+			assert.Equal(t, 3*tt.expected, openaiResponse.Usage.CompletionTokens)
+			assert.Equal(t, 15*tt.expected, openaiResponse.Usage.TotalTokens)
+			assert.Equal(t, *openaiResponse.Usage, *usage)
+		})
+	}
+}
+
+// 并发请求同时累加用量和写入响应头，需要通过 go test -race 检查
+func TestChatCompletionsMultipleChoicesUsage(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	var calls int32
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		call := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("request-id", fmt.Sprintf("req_%d", call))
+		fmt.Fprintf(w, `{"id":"msg_0%d","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Answer %d"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":10,"output_tokens":%d,"cache_read_input_tokens":2}}`, call, call, call)
+	})
+
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"max_n": "8"}})
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"n":8}`)
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Len(t, openaiResponse.Choices, 8)
+
+	// 输出 tokens 为 1 到 8
+	assert.Equal(t, 12*8, usage.PromptTokens)
+	assert.Equal(t, 36, usage.CompletionTokens)
+	assert.Equal(t, 12*8+36, usage.TotalTokens)
+	assert.Equal(t, 2*8, usage.CacheReadInputTokens)
+	assert.Equal(t, 2*8, usage.PromptTokensDetails.CachedTokens)
+	assert.Equal(t, *openaiResponse.Usage, *usage)
+}
+
+func TestChatCompletionsUnsupportedParamsWarning(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  string
+		expected []string
+	}{
+		{
+			"logit_bias",
+			`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"logit_bias":{"50256":-100}}`,
+			[]string{`299 - "logit_bias is not supported by Claude and was ignored"`},
+		},
+		{
+			"empty logit_bias",
+			`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"logit_bias":{}}`,
+			nil,
+		},
+		{
+			"seed",
+			`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"seed":42}`,
+			[]string{`299 - "seed is not supported by Claude and was ignored, sampling is not deterministic"`},
+		},
+		{
+			"seed and logit_bias",
+			`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"seed":0,"logit_bias":{"50256":-100}}`,
+			[]string{
+				`299 - "logit_bias is not supported by Claude and was ignored"`,
+				`299 - "seed is not supported by Claude and was ignored, sampling is not deterministic"`,
+			},
+		},
+	}
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, w := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			openaiResponse, errWithCode := chatProvider.CreateChatCompletion(getChatRequestFromJSON(tt.request))
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, "Hi!", openaiResponse.Choices[0].Message.Content)
+			assert.Equal(t, tt.expected, w.Header().Values("Warning"))
+			assert.NotContains(t, requestBody, "seed")
+		})
+	}
+}
+
+func TestChatCompletionsLogprobsUnsupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   string
+		expected bool
+	}{
+		{"logprobs", `"logprobs": true,`, true},
+		{"top_logprobs", `"top_logprobs": 5,`, true},
+		{"logprobs false", `"logprobs": false,`, false},
+	}
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			var calls int
+			server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				handleClaudeEndpoint(nil, response)(w, r)
+			})
+
+			chatRequest := getChatRequestFromJSON(`{
+				"model": "claude-3-5-sonnet-20241022",
+				` + tt.params + `
+				"messages": [{"role": "user", "content": "Hello!"}]
+			}`)
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			if !tt.expected {
+				assert.Nil(t, errWithCode)
+				assert.Equal(t, 1, calls)
+				return
+			}
+
+			assert.NotNil(t, errWithCode)
+			assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+			assert.Equal(t, "unsupported_parameter", errWithCode.Code)
+			assert.Contains(t, errWithCode.Message, "logprobs")
+			assert.Equal(t, 0, calls)
+		})
+	}
+}
+
+func TestChatCompletionsInterleavedContent(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	var requestBody map[string]any
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"They match."}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	image := `{"type":"image_url","image_url":{"url":"data:image/png;base64,` + testPNG + `"}}`
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":[{"type":"text","text":"  "},` + image + `,{"type":"text","text":""},{"type":"text","text":"Compare with"},` + image + `,{"type":"text","text":"\n"}]}]}`)
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	messages := requestBody["messages"].([]any)
+	content := messages[0].(map[string]any)["content"].([]any)
+	var blockTypes []string
+	for _, block := range content {
+		blockTypes = append(blockTypes, block.(map[string]any)["type"].(string))
+	}
+	assert.Equal(t, []string{"image", "text", "image"}, blockTypes)
+	assert.Equal(t, "Compare with", content[1].(map[string]any)["text"])
+}
+
+func TestChatCompletionsStreamCancel(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	ctx, cancel := gocontext.WithCancel(context.Request.Context())
+	defer cancel()
+	context.Request = context.Request.WithContext(ctx)
+
+	upstreamClosed := make(chan struct{})
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range slowStreamEvents[:3] {
+			fmt.Fprint(w, event+"\n\n")
+		}
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+			close(upstreamClosed)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Tell me a story"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+	defer stream.Close()
+
+	dataChan, errChan := stream.Recv()
+	for data := range dataChan {
+		if strings.Contains(data, "Once upon") {
+			break
+		}
+	}
+
+	// 模拟客户端断开
+	cancel()
+
+	select {
+	case err := <-errChan:
+		assert.ErrorIs(t, err, gocontext.Canceled)
+	case <-dataChan:
+		t.Fatal("expected no more data after cancel")
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream was not stopped after cancel")
+	}
+
+	select {
+	case <-upstreamClosed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream connection was not closed after cancel")
+	}
+}
+
+const citationsPDF = "JVBERi0xLjQKJcfsj6IKMSAwIG9iago8PC9UeXBlL0NhdGFsb2c+PgplbmRvYmoKdHJhaWxlcgo8PC9Sb290IDEgMCBSPj4KJSVFT0YK"
+
+func TestChatCompletionsCitations(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	headers := test.RequestJSONConfig()
+	headers["x-anthropic-citations"] = "true"
+	context, _ := test.GetContext("POST", "/v1/chat/completions", headers, nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"The grass is green.","citations":[{"type":"page_location","cited_text":"The grass is green.","document_index":0,"document_title":"test.pdf","start_page_number":1,"end_page_number":2}]}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":5}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":[
+		{"type":"file","file":{"filename":"test.pdf","file_data":"data:application/pdf;base64,` + citationsPDF + `"}},
+		{"type":"text","text":"What color is the grass?"}
+	]}]}`)
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	document := requestBody["messages"].([]any)[0].(map[string]any)["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, map[string]any{"enabled": true}, document["citations"])
+
+	message := openaiResponse.Choices[0].Message
+	assert.Equal(t, "The grass is green.", message.Content)
+
+	// 以客户端收到的 JSON 为准
+	responseBody, _ := json.Marshal(openaiResponse)
+	var body map[string]any
+	json.Unmarshal(responseBody, &body)
+	annotations := body["choices"].([]any)[0].(map[string]any)["message"].(map[string]any)["annotations"]
+	assert.Equal(t, []any{map[string]any{
+		"type":              "page_location",
+		"cited_text":        "The grass is green.",
+		"document_index":    float64(0),
+		"document_title":    "test.pdf",
+		"start_page_number": float64(1),
+		"end_page_number":   float64(2),
+	}}, annotations)
+}
+
+func TestChatCompletionsStreamCitations(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":"","citations":[]}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"citations_delta","citation":{"type":"char_location","cited_text":"The grass is green.","document_index":0,"document_title":"notes","start_char_index":0,"end_char_index":20}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"The grass is green."}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":5}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":"What color is the grass?"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	var annotations []any
+	content := ""
+	for _, response := range readChatStream(t, stream) {
+		content += response.Choices[0].Delta.Content
+		if response.Choices[0].Delta.Annotations != nil {
+			annotations = append(annotations, response.Choices[0].Delta.Annotations.([]any)...)
+		}
+	}
+	assert.Equal(t, "The grass is green.", content)
+	assert.Equal(t, []any{map[string]any{
+		"type":             "char_location",
+		"cited_text":       "The grass is green.",
+		"document_index":   float64(0),
+		"document_title":   "notes",
+		"start_char_index": float64(0),
+		"end_char_index":   float64(20),
+	}}, annotations)
+}
+
+func TestChatCompletionsDryRun(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	var calls int32
+	var requestBody json.RawMessage
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		requestBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, response)
+	})
+
+	request := `{"model":"claude-3-opus-20240229","messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"Hello!"}],"max_tokens":100,"temperature":0.5}`
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	dryRunProvider, ok := chatProvider.(base.ChatDryRunInterface)
+	assert.True(t, ok)
+
+	claudeRequest, errWithCode := dryRunProvider.ConvertChatRequest(getChatRequestFromJSON(request))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+
+	dryRunBody, err := json.Marshal(claudeRequest)
+	assert.Nil(t, err)
+
+	_, errWithCode = chatProvider.CreateChatCompletion(getChatRequestFromJSON(request))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.JSONEq(t, string(requestBody), string(dryRunBody))
+}
+
+func TestChatCompletionsStreamMultipleBlocksIndex(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":472,"output_tokens":2}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me check both."}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_01","name":"get_current_weather","input":{}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"location\": \"Boston, MA\"}"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":1}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_02","name":"get_current_weather","input":{}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"location\": \"Paris\"}"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":2}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":89}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	chatRequest := test.GetChatCompletionRequest("function", "claude-3-opus-20240229", "true")
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	responses := readChatStream(t, stream)
+
+	var content string
+	toolIds := map[int]string{}
+	for _, response := range responses {
+		for _, choice := range response.Choices {
+			assert.Equal(t, 0, choice.Index)
+			content += choice.Delta.Content
+			for _, toolCall := range choice.Delta.ToolCalls {
+				if toolCall.Id != "" {
+					toolIds[toolCall.Index] = toolCall.Id
+				}
+			}
+		}
+	}
+
+	assert.Equal(t, "Let me check both.", content)
+	assert.Equal(t, map[int]string{0: "toolu_01", 1: "toolu_02"}, toolIds)
+}
+
+func TestChatCompletionsDefaultSystemPrompt(t *testing.T) {
+	tests := []struct {
+		name     string
+		plugin   model.PluginType
+		messages string
+		expected any
+	}{
+		{
+			"no default",
+			nil,
+			`[{"role":"system","content":"Be brief."},{"role":"user","content":"Hello!"}]`,
+			"Be brief.",
+		},
+		{
+			"without client system",
+			model.PluginType{"anthropic": {"system_prompt": "You are Acme's assistant."}},
+			`[{"role":"user","content":"Hello!"}]`,
+			"You are Acme's assistant.",
+		},
+		{
+			"prepend",
+			model.PluginType{"anthropic": {"system_prompt": "You are Acme's assistant."}},
+			`[{"role":"system","content":"Be brief."},{"role":"user","content":"Hello!"}]`,
+			"You are Acme's assistant.\nBe brief.",
+		},
+		{
+			"append",
+			model.PluginType{"anthropic": {"system_prompt": "You are Acme's assistant.", "system_prompt_position": "append"}},
+			`[{"role":"system","content":"Be brief."},{"role":"user","content":"Hello!"}]`,
+			"Be brief.\nYou are Acme's assistant.",
+		},
+	}
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			channel := getClaudeChannel(url)
+			if tt.plugin != nil {
+				setClaudeChannelPlugin(&channel, tt.plugin)
+			}
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			_, errWithCode := chatProvider.CreateChatCompletion(getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":` + tt.messages + `}`))
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, tt.expected, requestBody["system"])
+		})
+	}
+}
+
+func TestChatCompletionsStreamNonSSEResponse(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	statusCode := http.StatusBadGateway
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(statusCode)
+		fmt.Fprint(w, "<html>\r\n<head><title>502 Bad Gateway</title></head>\r\n<body>\r\n<center><h1>502 Bad Gateway</h1></center>\r\n</body>\r\n</html>\r\n")
+	})
+
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"max_retries": 0}})
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"stream":true}`)
+
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadGateway, errWithCode.StatusCode)
+
+	// 状态码正常但响应不是 SSE
+	statusCode = http.StatusOK
+	chatProvider = getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode = chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadGateway, errWithCode.StatusCode)
+	assert.Equal(t, "invalid_stream_response", errWithCode.Code)
+	assert.Contains(t, errWithCode.Message, "502 Bad Gateway")
+
+	// 缺少 Content-Type 的 SSE 响应正常处理
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil
+		fmt.Fprint(w, "event: message_start\n"+`data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`+"\n\n")
+		fmt.Fprint(w, "event: content_block_delta\n"+`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi!"}}`+"\n\n")
+		fmt.Fprint(w, "event: message_stop\n"+`data: {"type":"message_stop"}`+"\n\n")
+	})
+	chatProvider = getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	content := ""
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			content += choice.Delta.Content
+		}
+	}
+	assert.Equal(t, "Hi!", content)
+}
+
+func TestChatCompletionsStreamSplitUTF8(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	// 😀 的 UTF-8 编码为 F0 9F 98 80，被拆分到两个增量中，转义的代理对 \uD83D\uDE00 也可能被拆分
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi \xf0\x9f\"}}",
+		"event: content_block_delta\n" + "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"\x98\x80\\n\\u4f60\"}}",
+		"event: content_block_delta\n" + "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"\xe5\xa5\"}}",
+		"event: content_block_delta\n" + "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"\xbd!\"}}",
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" \uD83D\uDE00 \uD83D"}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"\uDE00\\uD83D"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":5}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	content := ""
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			assert.True(t, utf8.ValidString(choice.Delta.Content))
+			assert.NotContains(t, choice.Delta.Content, string(utf8.RuneError))
+			content += choice.Delta.Content
+		}
+	}
+	assert.Equal(t, "Hi 😀\n你好! 😀 😀\\uD83D", content)
+}
+
+func TestChatCompletionsPrefillJSON(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"\"name\": \"Alice\", \"age\": 30"}],"stop_reason":"stop_sequence","stop_sequence":"}","usage":{"input_tokens":20,"output_tokens":10}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-5-sonnet-20241022",
+		"stop": ["}"],
+		"messages": [
+			{"role": "user", "content": "Return Alice's profile as JSON."},
+			{"role": "assistant", "content": "{"}
+		]
+	}`)
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, `{"name": "Alice", "age": 30`, openaiResponse.Choices[0].Message.Content)
+
+	messages := requestBody["messages"].([]any)
+	assert.Len(t, messages, 2)
+	assert.Equal(t, map[string]any{
+		"role":    "assistant",
+		"content": []any{map[string]any{"type": "text", "text": "{"}},
+	}, messages[1])
+	assert.Equal(t, []any{"}"}, requestBody["stop_sequences"])
+
+	// 空的 assistant 消息不作为预填充发送
+	chatRequest = getChatRequestFromJSON(`{
+		"model": "claude-3-5-sonnet-20241022",
+		"messages": [
+			{"role": "user", "content": "Return Alice's profile as JSON."},
+			{"role": "assistant", "content": ""}
+		]
+	}`)
+	openaiResponse, errWithCode = chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, `"name": "Alice", "age": 30`, openaiResponse.Choices[0].Message.Content)
+	assert.Len(t, requestBody["messages"].([]any), 1)
+}
+
+func TestChatCompletionsStreamPrefillJSON(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":20,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"\"name\": \"Alice\","}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" \"age\": 30"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"stop_sequence","stop_sequence":"}"},"usage":{"output_tokens":10}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-5-sonnet-20241022",
+		"stream": true,
+		"stop": ["}"],
+		"messages": [
+			{"role": "user", "content": "Return Alice's profile as JSON."},
+			{"role": "assistant", "content": "{"}
+		]
+	}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	content := ""
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			content += choice.Delta.Content
+		}
+	}
+
+	// 客户端补上停止序列后即为完整的 JSON
+	assert.Equal(t, `{"name": "Alice", "age": 30`, content)
+	assert.True(t, json.Valid([]byte(content+"}")))
+}
+
+func TestChatCompletionsMergeSameRoleMessages(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Both are fine."}],"stop_reason":"end_turn","usage":{"input_tokens":30,"output_tokens":4}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	tests := []struct {
+		name     string
+		messages string
+		expected []any
+	}{
+		{
+			"consecutive user messages",
+			`[
+				{"role": "system", "content": "Be brief."},
+				{"role": "user", "content": "Here is my first question."},
+				{"role": "user", "content": [{"type": "text", "text": "And a second one."}, {"type": "image_url", "image_url": {"url": "data:image/png;base64,` + testPNG + `"}}]}
+			]`,
+			[]any{
+				map[string]any{"role": "user", "content": []any{
+					map[string]any{"type": "text", "text": "Here is my first question."},
+					map[string]any{"type": "text", "text": "And a second one."},
+					map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": testPNG}},
+				}},
+			},
+		},
+		{
+			"consecutive assistant messages",
+			`[
+				{"role": "user", "content": "Tell me two facts."},
+				{"role": "assistant", "content": "Fact one."},
+				{"role": "assistant", "content": "Fact two."},
+				{"role": "user", "content": "Thanks!"}
+			]`,
+			[]any{
+				map[string]any{"role": "user", "content": []any{map[string]any{"type": "text", "text": "Tell me two facts."}}},
+				map[string]any{"role": "assistant", "content": []any{
+					map[string]any{"type": "text", "text": "Fact one."},
+					map[string]any{"type": "text", "text": "Fact two."},
+				}},
+				map[string]any{"role": "user", "content": []any{map[string]any{"type": "text", "text": "Thanks!"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			_, errWithCode := chatProvider.CreateChatCompletion(getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":` + tt.messages + `}`))
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, tt.expected, requestBody["messages"])
+		})
+	}
+}
+
+func TestChatCompletionsPrefillTrailingWhitespace(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":" 42"}],"stop_reason":"end_turn","usage":{"input_tokens":20,"output_tokens":2}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-5-sonnet-20241022",
+		"messages": [
+			{"role": "user", "content": "What is six times seven?"},
+			{"role": "assistant", "content": "  The answer is: \n"}
+		]
+	}`)
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	// 只去除末尾的空白，开头的空白保留
+	messages := requestBody["messages"].([]any)
+	assert.Equal(t, map[string]any{
+		"role":    "assistant",
+		"content": []any{map[string]any{"type": "text", "text": "  The answer is:"}},
+	}, messages[1])
+	assert.Equal(t, "  The answer is: 42", openaiResponse.Choices[0].Message.Content)
+}
+
+func TestChatCompletionsOnlySystemMessage(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	var requested bool
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	})
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-5-sonnet-20241022",
+		"messages": [
+			{"role": "system", "content": "You are a helpful assistant."}
+		]
+	}`)
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	assert.Equal(t, "invalid_request_error", errWithCode.Type)
+	assert.Contains(t, errWithCode.Message, "at least one user or assistant message is required")
+	assert.False(t, requested)
+}
+
+func TestChatCompletionsSystemContentParts(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-5-sonnet-20241022",
+		"messages": [
+			{"role": "system", "content": [{"type": "text", "text": "You are a helpful assistant."}, {"type": "text", "text": "Answer briefly."}]},
+			{"role": "system", "content": "Use English."},
+			{"role": "user", "content": [{"type": "text", "text": "Hello!"}]}
+		]
+	}`)
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "You are a helpful assistant.\nAnswer briefly.\nUse English.", requestBody["system"])
+	assert.Equal(t, []any{map[string]any{"role": "user", "content": []any{map[string]any{"type": "text", "text": "Hello!"}}}}, requestBody["messages"])
+}
+
+func TestChatCompletionsMaxTokensTruncation(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Once upon a time, there"}],"stop_reason":"max_tokens","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":5}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	chatRequest := test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "false")
+	chatRequest.MaxTokens = 5
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Once upon a time, there", openaiResponse.Choices[0].Message.Content)
+	assert.Equal(t, types.FinishReasonLength, openaiResponse.Choices[0].FinishReason)
+}
+
+func TestChatCompletionsStreamMaxTokensTruncation(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Once upon a time,"}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" there"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"max_tokens","stop_sequence":null},"usage":{"output_tokens":5}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	chatRequest := test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "true")
+	chatRequest.MaxTokens = 5
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	responses := readChatStream(t, stream)
+	assert.NotEmpty(t, responses)
+
+	// 只有最后一个增量带有 finish_reason
+	var content string
+	for i, response := range responses {
+		choice := response.Choices[0]
+		content += choice.Delta.Content
+		if i < len(responses)-1 {
+			assert.Nil(t, choice.FinishReason)
+		}
+	}
+	assert.Equal(t, "Once upon a time, there", content)
+	assert.Equal(t, types.FinishReasonLength, responses[len(responses)-1].Choices[0].FinishReason)
+}
@@ -0,0 +1,140 @@
+package claude_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/common/requester"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/providers"
+	providers_base "one-api/providers/base"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+)
+
+func setupClaudeTestServer() (baseUrl string, server *test.ServerTest, teardown func()) {
+	server = test.NewTestServer()
+	ts := server.TestServer(func(w http.ResponseWriter, r *http.Request) bool {
+		return test.ClaudeCheck(w, r)
+	})
+	ts.Start()
+	teardown = ts.Close
+
+	baseUrl = ts.URL
+	return
+}
+
+func getClaudeChannel(baseUrl string) model.Channel {
+	return test.GetChannel(common.ChannelTypeAnthropic, baseUrl, "", "", "")
+}
+
+func setClaudeChannelPlugin(channel *model.Channel, plugin model.PluginType) {
+	pluginData := datatypes.NewJSONType(plugin)
+	channel.Plugin = &pluginData
+}
+
+func getChatProvider(channel *model.Channel, context *gin.Context) providers_base.ChatInterface {
+	provider := providers.GetProvider(channel, context)
+	chatProvider, _ := provider.(providers_base.ChatInterface)
+
+	return chatProvider
+}
+
+// 将渠道加入渠道分组，测试结束后移除
+func registerChannel(t *testing.T, channel *model.Channel) {
+	model.ChannelGroup.Lock()
+	if model.ChannelGroup.Channels == nil {
+		model.ChannelGroup.Channels = make(map[int]*model.ChannelChoice)
+	}
+	model.ChannelGroup.Channels[channel.Id] = &model.ChannelChoice{Channel: channel}
+	model.ChannelGroup.Unlock()
+
+	t.Cleanup(func() {
+		model.ChannelGroup.Lock()
+		delete(model.ChannelGroup.Channels, channel.Id)
+		model.ChannelGroup.Unlock()
+	})
+}
+
+// 记录请求次数，并返回指定状态码的响应
+func handleClaudeStatus(calls *int, statusCode int, response string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		fmt.Fprint(w, response)
+	}
+}
+
+// 1x1 的 PNG 图片
+const testPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR4nGP4z8DwHwAFAAH/iZk9HQAAAABJRU5ErkJggg=="
+
+// 记录上游收到的请求体，并返回固定的响应
+func handleClaudeEndpoint(requestBody *map[string]any, response string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+		if requestBody != nil {
+			json.NewDecoder(r.Body).Decode(requestBody)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, response)
+	}
+}
+
+// 按行返回 SSE 流
+func handleClaudeStreamEndpoint(requestBody *map[string]any, events []string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+		if requestBody != nil {
+			json.NewDecoder(r.Body).Decode(requestBody)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprint(w, event+"\n\n")
+		}
+	}
+}
+
+// 读取流式响应，直到流结束
+func readChatStream(t *testing.T, stream requester.StreamReaderInterface[string]) []types.ChatCompletionStreamResponse {
+	defer stream.Close()
+	dataChan, errChan := stream.Recv()
+
+	var responses []types.ChatCompletionStreamResponse
+	for {
+		select {
+		case data := <-dataChan:
+			var response types.ChatCompletionStreamResponse
+			assert.Nil(t, json.Unmarshal([]byte(data), &response))
+			responses = append(responses, response)
+		case err := <-errChan:
+			if !errors.Is(err, io.EOF) {
+				t.Error(err)
+			}
+			return responses
+		}
+	}
+}
+
+func getChatRequestFromJSON(chatJSON string) *types.ChatCompletionRequest {
+	chatRequest := &types.ChatCompletionRequest{}
+	json.NewDecoder(strings.NewReader(chatJSON)).Decode(chatRequest)
+	return chatRequest
+}
@@ -0,0 +1,69 @@
+package claude
+
+import (
+	"encoding/json"
+	"one-api/types"
+)
+
+// computer use 的工具类型，名称固定，需要开启对应的 beta
+var computerUseTools = map[string]struct {
+	toolType string
+	name     string
+	beta     string
+}{
+	"computer":             {"computer_20241022", "computer", "computer-use-2024-10-22"},
+	"computer_20241022":    {"computer_20241022", "computer", "computer-use-2024-10-22"},
+	"computer_20250124":    {"computer_20250124", "computer", "computer-use-2025-01-24"},
+	"bash":                 {"bash_20241022", "bash", "computer-use-2024-10-22"},
+	"bash_20241022":        {"bash_20241022", "bash", "computer-use-2024-10-22"},
+	"bash_20250124":        {"bash_20250124", "bash", "computer-use-2025-01-24"},
+	"text_editor":          {"text_editor_20241022", "str_replace_editor", "computer-use-2024-10-22"},
+	"text_editor_20241022": {"text_editor_20241022", "str_replace_editor", "computer-use-2024-10-22"},
+	"text_editor_20250124": {"text_editor_20250124", "str_replace_editor", "computer-use-2025-01-24"},
+}
+
+// computer 工具的屏幕参数，通过 function.parameters 传入
+type computerToolParams struct {
+	DisplayWidthPx  int  `json:"display_width_px"`
+	DisplayHeightPx int  `json:"display_height_px"`
+	DisplayNumber   *int `json:"display_number"`
+}
+
+// 转换工具定义，type 为 computer、bash、text_editor 等时转换为 Claude 的 computer use 工具
+func (r *ClaudeRequest) addTool(tool *types.ChatCompletionTool) {
+	computerTool, ok := computerUseTools[tool.Type]
+	if !ok {
+		r.Tools = append(r.Tools, Tools{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: tool.Function.Parameters,
+		})
+		return
+	}
+
+	claudeTool := Tools{
+		Type: computerTool.toolType,
+		Name: computerTool.name,
+	}
+	if computerTool.name == "computer" && tool.Function.Parameters != nil {
+		params := computerToolParams{}
+		if data, err := json.Marshal(tool.Function.Parameters); err == nil {
+			json.Unmarshal(data, &params)
+		}
+		claudeTool.DisplayWidthPx = params.DisplayWidthPx
+		claudeTool.DisplayHeightPx = params.DisplayHeightPx
+		claudeTool.DisplayNumber = params.DisplayNumber
+	}
+
+	r.Tools = append(r.Tools, claudeTool)
+	r.addBeta(computerTool.beta)
+}
+
+func (r *ClaudeRequest) addBeta(beta string) {
+	for _, exists := range r.betas {
+		if exists == beta {
+			return
+		}
+	}
+	r.betas = append(r.betas, beta)
+}
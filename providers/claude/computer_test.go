@@ -0,0 +1,67 @@
+package claude_test
+
+import (
+	"net/http"
+	"one-api/common/test"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatCompletionsComputerUse(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	var requestHeader http.Header
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"tool_use","id":"toolu_02","name":"computer","input":{"action":"left_click","coordinate":[512,384]}}],"stop_reason":"tool_use","usage":{"input_tokens":1200,"output_tokens":40}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		requestHeader = r.Header.Clone()
+		handleClaudeEndpoint(&requestBody, response)(w, r)
+	})
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-5-sonnet-20241022",
+		"messages": [
+			{"role": "user", "content": "Open the settings."},
+			{"role": "assistant", "content": "", "tool_calls": [{"id": "toolu_01", "type": "function", "function": {"name": "computer", "arguments": "{\"action\":\"screenshot\"}"}}]},
+			{"role": "tool", "tool_call_id": "toolu_01", "content": [{"type": "image_url", "image_url": {"url": "` + getGradientPNG(8, 8) + `"}}]}
+		],
+		"tools": [
+			{"type": "computer", "function": {"name": "computer", "parameters": {"display_width_px": 1024, "display_height_px": 768, "display_number": 1}}},
+			{"type": "bash_20241022", "function": {"name": "bash"}},
+			{"type": "function", "function": {"name": "get_current_weather", "parameters": {"type": "object", "properties": {}}}}
+		]
+	}`)
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	// computer use 工具按 Claude 的格式定义，并开启对应的 beta
+	assert.Equal(t, []any{
+		map[string]any{"type": "computer_20241022", "name": "computer", "display_width_px": float64(1024), "display_height_px": float64(768), "display_number": float64(1)},
+		map[string]any{"type": "bash_20241022", "name": "bash"},
+		map[string]any{"name": "get_current_weather", "input_schema": map[string]any{"type": "object", "properties": map[string]any{}}},
+	}, requestBody["tools"])
+	assert.Equal(t, "computer-use-2024-10-22", requestHeader.Get("anthropic-beta"))
+
+	// 截图作为 tool_result 的图片内容块发送
+	messages := requestBody["messages"].([]any)
+	toolResult := messages[2].(map[string]any)["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "tool_result", toolResult["type"])
+	assert.Equal(t, "toolu_01", toolResult["tool_use_id"])
+	assert.Equal(t, "image", toolResult["content"].([]any)[0].(map[string]any)["type"])
+
+	// 返回的 computer 工具调用转换为 tool_calls
+	toolCalls := openaiResponse.Choices[0].Message.ToolCalls
+	assert.Len(t, toolCalls, 1)
+	assert.Equal(t, "toolu_02", toolCalls[0].Id)
+	assert.Equal(t, "computer", toolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"action":"left_click","coordinate":[512,384]}`, toolCalls[0].Function.Arguments)
+	assert.Equal(t, types.FinishReasonToolCalls, openaiResponse.Choices[0].FinishReason)
+}
@@ -0,0 +1,62 @@
+package claude
+
+import (
+	"one-api/types"
+	"strconv"
+	"strings"
+)
+
+const estimatedCostHeader = "X-Estimated-Cost"
+
+// 各模型每百万 tokens 的美元价格，按前缀匹配，更具体的前缀放在前面
+var modelPrices = []struct {
+	prefix string
+	input  float64
+	output float64
+}{
+	{"claude-opus-4", 15, 75},
+	{"claude-sonnet-4", 3, 15},
+	{"claude-3-7-sonnet", 3, 15},
+	{"claude-3-5-sonnet", 3, 15},
+	{"claude-3-5-haiku", 0.8, 4},
+	{"claude-3-opus", 15, 75},
+	{"claude-3-sonnet", 3, 15},
+	{"claude-3-haiku", 0.25, 1.25},
+	{"claude-2", 8, 24},
+	{"claude-instant", 0.8, 2.4},
+}
+
+// 按用量估算请求的美元费用，缓存的 tokens 按折扣价计算，未知模型返回 false
+func estimateCost(modelName string, usage *types.Usage) (float64, bool) {
+	// Bedrock 的模型 ID 带有 anthropic. 前缀，可能还有跨区域推理的区域前缀
+	if index := strings.Index(modelName, "anthropic."); index >= 0 {
+		modelName = modelName[index+len("anthropic."):]
+	}
+
+	for _, price := range modelPrices {
+		if !strings.HasPrefix(modelName, price.prefix) {
+			continue
+		}
+
+		cost := float64(usage.GetUncachedPromptTokens())*price.input +
+			float64(usage.CacheCreationInputTokens)*price.input*types.CacheCreationTokensRatio +
+			float64(usage.CacheReadInputTokens)*price.input*types.CacheReadTokensRatio +
+			float64(usage.CompletionTokens)*price.output
+		return cost / 1000000, true
+	}
+
+	return 0, false
+}
+
+// 在响应头中返回估算的费用，流式响应的响应头在用量确定前已发送，不返回
+func (p *ClaudeProvider) setEstimatedCost(modelName string) {
+	if p.Usage == nil {
+		return
+	}
+
+	cost, ok := estimateCost(modelName, p.Usage)
+	if !ok {
+		return
+	}
+	p.Context.Writer.Header().Set(estimatedCostHeader, strconv.FormatFloat(cost, 'f', 6, 64))
+}
@@ -0,0 +1,44 @@
+package claude_test
+
+import (
+	"one-api/common/test"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimatedCost(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":1000,"cache_creation_input_tokens":2000,"cache_read_input_tokens":10000,"output_tokens":500}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	context, writer := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "false"))
+	assert.Nil(t, errWithCode)
+
+	// 输入 $3、输出 $15 每百万 tokens，缓存写入按 1.25 倍、读取按 0.1 倍计算：
+	// 1000*3 + 2000*3.75 + 10000*0.3 + 500*15 = 21000
+	assert.Equal(t, "0.021000", writer.Header().Get("X-Estimated-Cost"))
+}
+
+func TestEstimatedCostUnknownModel(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"custom-model","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	context, writer := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "custom-model", "false"))
+	assert.Nil(t, errWithCode)
+	assert.Empty(t, writer.Header().Get("X-Estimated-Cost"))
+}
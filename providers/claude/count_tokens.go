@@ -0,0 +1,52 @@
+package claude
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/types"
+)
+
+const countTokensURL = "/v1/messages/count_tokens"
+
+// 发送前计算提示词的 token 数
+func (p *ClaudeProvider) CountTokens(request *types.ChatCompletionRequest) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
+	fullRequestURL := p.GetFullRequestURL(countTokensURL, request.Model)
+
+	headers := p.GetRequestHeaders()
+
+	claudeRequest, errWithCode := p.convertFromChatOpenai(request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	// count_tokens 不接受 max_tokens、stream 等生成参数
+	countTokensRequest := &ClaudeCountTokensRequest{
+		Model:      claudeRequest.Model,
+		System:     claudeRequest.System,
+		Messages:   claudeRequest.Messages,
+		Tools:      claudeRequest.Tools,
+		ToolChoice: claudeRequest.ToolChoice,
+		Thinking:   claudeRequest.Thinking,
+	}
+
+	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(countTokensRequest), p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	defer req.Body.Close()
+
+	countTokensResponse := &ClaudeCountTokensResponse{}
+	_, errWithCode = p.Requester.SendRequest(req, countTokensResponse, false)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	if errWithCode := errorHandleWithStatusCode(&countTokensResponse.Error); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	return &types.Usage{
+		PromptTokens: countTokensResponse.InputTokens,
+		TotalTokens:  countTokensResponse.InputTokens,
+	}, nil
+}
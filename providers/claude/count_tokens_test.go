@@ -0,0 +1,68 @@
+package claude_test
+
+import (
+	"net/http"
+	"one-api/common/test"
+	_ "one-api/common/test/init"
+	"one-api/providers"
+	"one-api/providers/claude"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountTokens(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	server.RegisterHandler("/v1/messages/count_tokens", handleClaudeEndpoint(&requestBody, `{"input_tokens":26}`))
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-opus-20240229",
+		"max_tokens": 1024,
+		"stream": true,
+		"messages": [
+			{"role": "system", "content": "You are a helpful assistant."},
+			{"role": "user", "content": "Hello!"}
+		]
+	}`)
+
+	channel := getClaudeChannel(url)
+	provider, ok := providers.GetProvider(&channel, context).(*claude.ClaudeProvider)
+	assert.True(t, ok)
+
+	usage, errWithCode := provider.CountTokens(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 26, usage.PromptTokens)
+	assert.Equal(t, 26, usage.TotalTokens)
+
+	assert.Equal(t, "claude-3-opus-20240229", requestBody["model"])
+	assert.Equal(t, "You are a helpful assistant.", requestBody["system"])
+	assert.Equal(t, []any{map[string]any{"role": "user", "content": []any{map[string]any{"type": "text", "text": "Hello!"}}}}, requestBody["messages"])
+	assert.NotContains(t, requestBody, "max_tokens")
+	assert.NotContains(t, requestBody, "stream")
+}
+
+func TestCountTokensError(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	server.RegisterHandler("/v1/messages/count_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"error","error":{"type":"invalid_request_error","message":"model: field required"}}`))
+	})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}]}`)
+
+	channel := getClaudeChannel(url)
+	provider, _ := providers.GetProvider(&channel, context).(*claude.ClaudeProvider)
+
+	usage, errWithCode := provider.CountTokens(chatRequest)
+	assert.Nil(t, usage)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+}
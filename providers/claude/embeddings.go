@@ -0,0 +1,97 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/requester"
+	"one-api/types"
+	"strings"
+)
+
+const (
+	voyageBaseURL       = "https://api.voyageai.com"
+	voyageEmbeddingsURL = "/v1/embeddings"
+)
+
+// Anthropic 没有 embeddings 接口，官方推荐使用 Voyage，密钥在渠道的 voyage 插件中配置
+func (p *ClaudeProvider) CreateEmbeddings(request *types.EmbeddingRequest) (*types.EmbeddingResponse, *types.OpenAIErrorWithStatusCode) {
+	voyageKey := p.getPluginParam("voyage", "key")
+	if voyageKey == "" {
+		return nil, common.StringErrorWrapper("voyage api key is not configured for this channel", "invalid_voyage_config", http.StatusInternalServerError)
+	}
+
+	baseURL := p.getPluginParam("voyage", "base_url")
+	if baseURL == "" {
+		baseURL = voyageBaseURL
+	}
+	fullRequestURL := fmt.Sprintf("%s%s", strings.TrimSuffix(baseURL, "/"), voyageEmbeddingsURL)
+
+	headers := make(map[string]string)
+	p.CommonRequestHeaders(headers)
+	headers["Authorization"] = fmt.Sprintf("Bearer %s", voyageKey)
+
+	voyageRequest := &VoyageEmbeddingRequest{
+		Model: request.Model,
+		Input: request.ParseInput(),
+	}
+	if len(voyageRequest.Input) == 0 {
+		return nil, common.StringErrorWrapper("input is required", "invalid_input", http.StatusBadRequest)
+	}
+
+	voyageRequester := requester.NewHTTPRequester(*p.Channel.Proxy, voyageErrorHandle)
+	voyageRequester.SetContext(p.Context.Request.Context())
+	req, err := voyageRequester.NewRequest(http.MethodPost, fullRequestURL, voyageRequester.WithBody(voyageRequest), voyageRequester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	defer req.Body.Close()
+
+	voyageResponse := &VoyageEmbeddingResponse{}
+	_, errWithCode := voyageRequester.SendRequest(req, voyageResponse, false)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	return p.convertToEmbeddingOpenai(voyageResponse, request)
+}
+
+func (p *ClaudeProvider) convertToEmbeddingOpenai(response *VoyageEmbeddingResponse, request *types.EmbeddingRequest) (*types.EmbeddingResponse, *types.OpenAIErrorWithStatusCode) {
+	openaiResponse := &types.EmbeddingResponse{
+		Object: "list",
+		Data:   make([]types.Embedding, 0, len(response.Data)),
+		Model:  request.Model,
+		Usage: &types.Usage{
+			PromptTokens: response.Usage.TotalTokens,
+			TotalTokens:  response.Usage.TotalTokens,
+		},
+	}
+
+	for _, item := range response.Data {
+		openaiResponse.Data = append(openaiResponse.Data, types.Embedding{
+			Object:    "embedding",
+			Index:     item.Index,
+			Embedding: item.Embedding,
+		})
+	}
+
+	*p.Usage = *openaiResponse.Usage
+
+	return openaiResponse, nil
+}
+
+// Voyage 的错误格式为 {"detail": "..."}
+func voyageErrorHandle(resp *http.Response) *types.OpenAIError {
+	voyageError := &VoyageError{}
+	err := json.NewDecoder(resp.Body).Decode(voyageError)
+	if err != nil || voyageError.Detail == "" {
+		return nil
+	}
+
+	return &types.OpenAIError{
+		Message: voyageError.Detail,
+		Type:    "voyage_error",
+		Code:    resp.StatusCode,
+	}
+}
@@ -0,0 +1,120 @@
+package claude_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common/test"
+	_ "one-api/common/test/init"
+	"one-api/model"
+	"one-api/providers"
+	providers_base "one-api/providers/base"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupVoyageTestServer() (baseUrl string, server *test.ServerTest, teardown func()) {
+	server = test.NewTestServer()
+	ts := server.TestServer(func(w http.ResponseWriter, r *http.Request) bool {
+		return test.OpenAICheck(w, r)
+	})
+	ts.Start()
+	teardown = ts.Close
+
+	baseUrl = ts.URL
+	return
+}
+
+func getEmbeddingsProvider(channel *model.Channel) providers_base.EmbeddingsInterface {
+	context, _ := test.GetContext("POST", "/v1/embeddings", test.RequestJSONConfig(), nil)
+	provider := providers.GetProvider(channel, context)
+	embeddingsProvider, _ := provider.(providers_base.EmbeddingsInterface)
+
+	return embeddingsProvider
+}
+
+func TestCreateEmbeddings(t *testing.T) {
+	voyageURL, server, teardown := setupVoyageTestServer()
+	defer teardown()
+
+	requestBody := map[string]any{}
+	server.RegisterHandler("/v1/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&requestBody)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"object":"list","data":[{"object":"embedding","embedding":[0.1,0.2],"index":0},{"object":"embedding","embedding":[0.3,0.4],"index":1}],"model":"voyage-3","usage":{"total_tokens":10}}`)
+	})
+
+	channel := getClaudeChannel("")
+	setClaudeChannelPlugin(&channel, model.PluginType{"voyage": {"key": test.GetTestToken(), "base_url": voyageURL}})
+	embeddingsProvider := getEmbeddingsProvider(&channel)
+	usage := &types.Usage{}
+	embeddingsProvider.SetUsage(usage)
+
+	request := &types.EmbeddingRequest{
+		Model: "voyage-3",
+		Input: []any{"Hello", "World"},
+	}
+	response, errWithCode := embeddingsProvider.CreateEmbeddings(request)
+
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, map[string]any{"model": "voyage-3", "input": []any{"Hello", "World"}}, requestBody)
+	assert.Equal(t, "list", response.Object)
+	assert.Len(t, response.Data, 2)
+	assert.Equal(t, 1, response.Data[1].Index)
+	assert.Equal(t, []float64{0.3, 0.4}, response.Data[1].Embedding)
+	assert.Equal(t, 10, response.Usage.PromptTokens)
+	assert.Equal(t, 10, usage.TotalTokens)
+}
+
+func TestCreateEmbeddingsStringInput(t *testing.T) {
+	voyageURL, server, teardown := setupVoyageTestServer()
+	defer teardown()
+
+	requestBody := map[string]any{}
+	server.RegisterHandler("/v1/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&requestBody)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"object":"list","data":[{"object":"embedding","embedding":[0.1,0.2],"index":0}],"model":"voyage-3","usage":{"total_tokens":2}}`)
+	})
+
+	channel := getClaudeChannel("")
+	setClaudeChannelPlugin(&channel, model.PluginType{"voyage": {"key": test.GetTestToken(), "base_url": voyageURL}})
+	embeddingsProvider := getEmbeddingsProvider(&channel)
+	embeddingsProvider.SetUsage(&types.Usage{})
+
+	response, errWithCode := embeddingsProvider.CreateEmbeddings(&types.EmbeddingRequest{Model: "voyage-3", Input: "Hello"})
+
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, []any{"Hello"}, requestBody["input"])
+	assert.Len(t, response.Data, 1)
+}
+
+func TestCreateEmbeddingsErrors(t *testing.T) {
+	voyageURL, server, teardown := setupVoyageTestServer()
+	defer teardown()
+
+	server.RegisterHandler("/v1/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"detail":"Model voyage-0 is not supported."}`)
+	})
+
+	channel := getClaudeChannel("")
+	embeddingsProvider := getEmbeddingsProvider(&channel)
+	embeddingsProvider.SetUsage(&types.Usage{})
+
+	_, errWithCode := embeddingsProvider.CreateEmbeddings(&types.EmbeddingRequest{Model: "voyage-3", Input: "Hello"})
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "invalid_voyage_config", errWithCode.Code)
+
+	setClaudeChannelPlugin(&channel, model.PluginType{"voyage": {"key": test.GetTestToken(), "base_url": voyageURL}})
+	embeddingsProvider = getEmbeddingsProvider(&channel)
+	embeddingsProvider.SetUsage(&types.Usage{})
+
+	_, errWithCode = embeddingsProvider.CreateEmbeddings(&types.EmbeddingRequest{Model: "voyage-0", Input: "Hello"})
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	assert.Contains(t, errWithCode.Message, "Model voyage-0 is not supported.")
+}
@@ -0,0 +1,19 @@
+package claude
+
+import (
+	"strconv"
+	"strings"
+)
+
+// 渠道插件 fallback_channels 为逗号分隔的备用渠道 id，当前渠道出错时 relay 重试按顺序优先使用
+func (p *ClaudeProvider) GetFallbackChannelIds() []int {
+	var channelIds []int
+	for _, value := range strings.Split(p.getPluginParam("anthropic", "fallback_channels"), ",") {
+		channelId, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || channelId <= 0 || channelId == p.Channel.Id {
+			continue
+		}
+		channelIds = append(channelIds, channelId)
+	}
+	return channelIds
+}
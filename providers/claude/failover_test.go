@@ -0,0 +1,48 @@
+package claude_test
+
+import (
+	"one-api/common/test"
+	"one-api/model"
+	providers_base "one-api/providers/base"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFallbackChannelIds(t *testing.T) {
+	channel := getClaudeChannel("")
+	channel.Id = 8701
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"fallback_channels": "8702, x, 8701,0,8703"},
+	})
+
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	provider, ok := getChatProvider(&channel, context).(providers_base.FallbackChannelsInterface)
+	assert.True(t, ok)
+	// 跳过无效的 id 和当前渠道
+	assert.Equal(t, []int{8702, 8703}, provider.GetFallbackChannelIds())
+}
+
+func TestChatCompletionsFallbackByRelay(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	var calls int
+	server.RegisterHandler("/v1/messages", handleClaudeStatus(&calls, 529, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+
+	channel := getClaudeChannel(url)
+	channel.Id = 8711
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"max_retries": "0", "fallback_channels": "8712"},
+	})
+
+	// 备用渠道由 relay 的重试使用，provider 只请求当前渠道并返回错误
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "false"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, 529, errWithCode.StatusCode)
+	assert.Equal(t, 1, calls)
+}
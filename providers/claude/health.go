@@ -0,0 +1,59 @@
+package claude
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/common/requester"
+	"one-api/types"
+	"time"
+)
+
+const defaultHealthCheckTimeout = 10
+
+// 发送只生成 1 个 token 的请求检查渠道是否可用，不重试，使用独立的超时时间
+func (p *ClaudeProvider) TestChannel(modelName string) *types.ChannelTestResult {
+	request := &types.ChatCompletionRequest{
+		Model:     modelName,
+		MaxTokens: 1,
+		Messages: []types.ChatCompletionMessage{
+			{Role: types.ChatMessageRoleUser, Content: "hi"},
+		},
+	}
+
+	p.healthCheck = true
+	start := time.Now()
+	errWithCode := p.sendHealthCheck(request)
+
+	return &types.ChannelTestResult{
+		Success: errWithCode == nil,
+		Latency: time.Since(start),
+		Error:   errWithCode,
+	}
+}
+
+func (p *ClaudeProvider) sendHealthCheck(request *types.ChatCompletionRequest) *types.OpenAIErrorWithStatusCode {
+	req, errWithCode := p.getChatRequest(request)
+	if errWithCode != nil {
+		return errWithCode
+	}
+	defer req.Body.Close()
+
+	timeout := p.getPluginIntParam("anthropic", "health_check_timeout", defaultHealthCheckTimeout)
+	resp, err := requester.NewHTTPClientWithTimeout(time.Duration(timeout) * time.Second).Do(req)
+	if err != nil {
+		return common.ErrorWrapper(err, "http_request_failed", http.StatusInternalServerError)
+	}
+	p.Requester.HandleResponse(resp)
+
+	if p.Requester.IsFailureStatusCode(resp) {
+		return requester.HandleErrorResp(resp, p.Requester.ErrorHandler)
+	}
+	defer resp.Body.Close()
+
+	claudeResponse := &ClaudeResponse{}
+	if err := requester.DecodeResponse(resp.Body, claudeResponse); err != nil {
+		return common.ErrorWrapper(err, "decode_response_failed", http.StatusInternalServerError)
+	}
+
+	return errorHandleWithStatusCode(&claudeResponse.Error)
+}
@@ -0,0 +1,99 @@
+package claude_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common/test"
+	"one-api/model"
+	providers_base "one-api/providers/base"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getChannelTester(t *testing.T, channel *model.Channel) providers_base.ChannelTestInterface {
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	chatProvider := getChatProvider(channel, context)
+	tester, ok := chatProvider.(providers_base.ChannelTestInterface)
+	assert.True(t, ok)
+	return tester
+}
+
+func TestChannelHealthCheck(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-haiku-20241022","content":[{"type":"text","text":"Hi"}],"stop_reason":"max_tokens","usage":{"input_tokens":8,"output_tokens":1}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"min_max_tokens": "256"}})
+	result := getChannelTester(t, &channel).TestChannel("claude-3-5-haiku-20241022")
+	assert.True(t, result.Success)
+	assert.Nil(t, result.Error)
+	assert.Greater(t, result.Latency, time.Duration(0))
+
+	// 只请求 1 个 token，不受 min_max_tokens 影响
+	assert.Equal(t, "claude-3-5-haiku-20241022", requestBody["model"])
+	assert.Equal(t, float64(1), requestBody["max_tokens"])
+	assert.Len(t, requestBody["messages"], 1)
+}
+
+func TestChannelHealthCheckFailure(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	calls := 0
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+	})
+
+	channel := getClaudeChannel(url)
+	result := getChannelTester(t, &channel).TestChannel("claude-3-5-haiku-20241022")
+	assert.False(t, result.Success)
+	assert.Equal(t, http.StatusUnauthorized, result.Error.StatusCode)
+	assert.Equal(t, "invalid_api_key", result.Error.Code)
+	assert.Contains(t, result.Error.Message, "invalid x-api-key")
+
+	// 结果可以直接序列化返回给前端
+	data, err := json.Marshal(result)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"success":false`)
+
+	// 健康检查不重试
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(529)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	})
+	calls = 0
+	result = getChannelTester(t, &channel).TestChannel("claude-3-5-haiku-20241022")
+	assert.False(t, result.Success)
+	assert.Equal(t, 1, calls)
+}
+
+func TestChannelHealthCheckTimeout(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"health_check_timeout": "1"}})
+	result := getChannelTester(t, &channel).TestChannel("claude-3-5-haiku-20241022")
+	assert.False(t, result.Success)
+	assert.Equal(t, "http_request_failed", result.Error.Code)
+	assert.Less(t, result.Latency, 2*time.Second)
+}
@@ -0,0 +1,410 @@
+package claude_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/types"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatCompletionsImageDataURL(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"A red dot."}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	png := testPNG
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":[
+		{"type":"text","text":"What is in this image?"},
+		{"type":"image_url","image_url":{"url":"data:image/png;base64,` + png + `"}}
+	]}]}`)
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	content := requestBody["messages"].([]any)[0].(map[string]any)["content"].([]any)
+	assert.Equal(t, map[string]any{
+		"type": "image",
+		"source": map[string]any{
+			"type":       "base64",
+			"media_type": "image/png",
+			"data":       png,
+		},
+	}, content[1])
+
+	chatRequest = getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":[
+		{"type":"image_url","image_url":{"url":"data:image/png;base64,@@@"}}
+	]}]}`)
+	_, errWithCode = chatProvider.CreateChatCompletion(chatRequest)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "image_url_invalid", errWithCode.Code)
+}
+
+func TestChatCompletionsImageContentType(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"A pixel."}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	png, _ := base64.StdEncoding.DecodeString(testPNG)
+	imageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pixel.png":
+			w.Header().Set("Content-Type", "image/png; qs=0.7")
+			w.Write(png)
+		case "/error.png":
+			// HEAD 返回图片类型，GET 实际返回错误页面
+			if r.Method == http.MethodHead {
+				w.Header().Set("Content-Type", "image/png")
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, "<html><body>Not Found</body></html>")
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, "<html><body>Login</body></html>")
+		}
+	}))
+	defer imageServer.Close()
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	getImageRequest := func(imageURL string) *types.ChatCompletionRequest {
+		return getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":[
+			{"type":"image_url","image_url":{"url":"` + imageURL + `"}},
+			{"type":"text","text":"What is this?"}
+		]}]}`)
+	}
+
+	_, errWithCode := chatProvider.CreateChatCompletion(getImageRequest(imageServer.URL + "/pixel.png"))
+	assert.Nil(t, errWithCode)
+	source := requestBody["messages"].([]any)[0].(map[string]any)["content"].([]any)[0].(map[string]any)["source"].(map[string]any)
+	assert.Equal(t, "image/png", source["media_type"])
+
+	for _, imageURL := range []string{
+		imageServer.URL + "/login",
+		imageServer.URL + "/error.png",
+		"data:image/svg+xml;base64,PHN2Zz48L3N2Zz4=",
+	} {
+		requestBody = map[string]any{}
+		_, errWithCode = chatProvider.CreateChatCompletion(getImageRequest(imageURL))
+		assert.NotNil(t, errWithCode, imageURL)
+		assert.Equal(t, "image_url_invalid", errWithCode.Code)
+		assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+		assert.Empty(t, requestBody)
+	}
+}
+
+func TestChatCompletionsImageTranscode(t *testing.T) {
+	// 1x1 的 24 位 bmp
+	bmpData := "Qk06AAAAAAAAADYAAAAoAAAAAQAAAAEAAAABABgAAAAAAAQAAAAAAAAAAAAAAAAAAAAAAAAA/wAAAA=="
+	chatRequest := `{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":[
+		{"type":"image_url","image_url":{"url":"data:image/bmp;base64,` + bmpData + `"}},
+		{"type":"text","text":"What is this?"}
+	]}]}`
+
+	tests := []struct {
+		name      string
+		transcode any
+	}{
+		{"disabled", nil},
+		{"enabled", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"A pixel."}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			channel := getClaudeChannel(url)
+			if tt.transcode != nil {
+				setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"transcode_images": tt.transcode}})
+			}
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+
+			_, errWithCode := chatProvider.CreateChatCompletion(getChatRequestFromJSON(chatRequest))
+			if tt.transcode == nil {
+				assert.NotNil(t, errWithCode)
+				assert.Equal(t, "image_url_invalid", errWithCode.Code)
+				assert.Empty(t, requestBody)
+				return
+			}
+
+			assert.Nil(t, errWithCode)
+			source := requestBody["messages"].([]any)[0].(map[string]any)["content"].([]any)[0].(map[string]any)["source"].(map[string]any)
+			assert.Equal(t, "image/png", source["media_type"])
+			decoded, err := base64.StdEncoding.DecodeString(source["data"].(string))
+			assert.Nil(t, err)
+			assert.True(t, bytes.HasPrefix(decoded, []byte("\x89PNG")))
+		})
+	}
+}
+
+func TestChatCompletionsRepeatedImage(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Same pixel."}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	png, _ := base64.StdEncoding.DecodeString(testPNG)
+	var fetches int32
+	imageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&fetches, 1)
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}))
+	defer imageServer.Close()
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	image := `{"type":"image_url","image_url":{"url":"` + imageServer.URL + `/pixel.png"}}`
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[
+		{"role":"user","content":[` + image + `,{"type":"text","text":"What is this?"}]},
+		{"role":"assistant","content":"A pixel."},
+		{"role":"user","content":[` + image + `,` + image + `,{"type":"text","text":"And these?"}]}
+	]}`)
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	var images int
+	for _, message := range requestBody["messages"].([]any) {
+		for _, block := range message.(map[string]any)["content"].([]any) {
+			if block.(map[string]any)["type"] == "image" {
+				images++
+				assert.Equal(t, testPNG, block.(map[string]any)["source"].(map[string]any)["data"])
+			}
+		}
+	}
+	assert.Equal(t, 3, images)
+
+	// 缓存只在单个请求内有效
+	_, errWithCode = chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
+
+// 生成渐变色的 png，返回 data URI
+func getGradientPNG(width, height int) string {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 6), G: uint8(y * 12), B: 128, A: 255})
+		}
+	}
+
+	var buffer bytes.Buffer
+	png.Encode(&buffer, img)
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes())
+}
+
+func TestChatCompletionsImageSizeLimit(t *testing.T) {
+	imageURL := getGradientPNG(40, 20)
+
+	tests := []struct {
+		name   string
+		plugin model.PluginType
+		width  int
+		height int
+		code   string
+	}{
+		{"within limits", nil, 40, 20, ""},
+		{"downscaled", model.PluginType{"anthropic": {"max_image_dimension": "10"}}, 10, 5, ""},
+		{"too large", model.PluginType{"anthropic": {"max_image_bytes": "10"}}, 0, 0, "image_too_large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"A gradient."}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			channel := getClaudeChannel(url)
+			if tt.plugin != nil {
+				setClaudeChannelPlugin(&channel, tt.plugin)
+			}
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":[
+				{"type":"image_url","image_url":{"url":"` + imageURL + `"}},
+				{"type":"text","text":"What is this?"}
+			]}]}`)
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			if tt.code != "" {
+				assert.NotNil(t, errWithCode)
+				assert.Equal(t, tt.code, errWithCode.Code)
+				assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+				assert.Empty(t, requestBody)
+				return
+			}
+
+			assert.Nil(t, errWithCode)
+			source := requestBody["messages"].([]any)[0].(map[string]any)["content"].([]any)[0].(map[string]any)["source"].(map[string]any)
+			decoded, _ := base64.StdEncoding.DecodeString(source["data"].(string))
+			config, _, err := image.DecodeConfig(bytes.NewReader(decoded))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.width, config.Width)
+			assert.Equal(t, tt.height, config.Height)
+		})
+	}
+}
+
+func TestChatCompletionsImageDetail(t *testing.T) {
+	imageURL := getGradientPNG(1200, 600)
+
+	tests := []struct {
+		detail string
+		width  int
+		height int
+	}{
+		{"low", 512, 256},
+		{"high", 1200, 600},
+		{"auto", 1200, 600},
+		{"", 1200, 600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.detail, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"A gradient."}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":[
+				{"type":"image_url","image_url":{"url":"` + imageURL + `","detail":"` + tt.detail + `"}},
+				{"type":"text","text":"What is this?"}
+			]}]}`)
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			assert.Nil(t, errWithCode)
+
+			source := requestBody["messages"].([]any)[0].(map[string]any)["content"].([]any)[0].(map[string]any)["source"].(map[string]any)
+			decoded, _ := base64.StdEncoding.DecodeString(source["data"].(string))
+			config, _, err := image.DecodeConfig(bytes.NewReader(decoded))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.width, config.Width)
+			assert.Equal(t, tt.height, config.Height)
+		})
+	}
+}
+
+func TestChatCompletionsMultipleImages(t *testing.T) {
+	common.ApproximateTokenEnabled = true
+	defer func() { common.ApproximateTokenEnabled = false }()
+
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	usage := &types.Usage{PromptTokens: 1}
+	var provisionalTokens int
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Three gradients."}],"stop_reason":"end_turn","usage":{"input_tokens":1600,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		provisionalTokens = usage.PromptTokens
+		handleClaudeEndpoint(&requestBody, response)(w, r)
+	})
+
+	// 三张图片都请求到之后才返回，图片需要并发获取
+	pngs := map[string]string{
+		"/a.png": getGradientPNG(1000, 1000),
+		"/b.png": getGradientPNG(200, 150),
+		"/c.png": getGradientPNG(300, 300),
+	}
+	var fetches int32
+	allFetched := make(chan struct{})
+	imageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			if atomic.AddInt32(&fetches, 1) == 3 {
+				close(allFetched)
+			}
+			select {
+			case <-allFetched:
+			case <-time.After(5 * time.Second):
+			}
+		}
+		data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(pngs[r.URL.Path], "data:image/png;base64,"))
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	defer imageServer.Close()
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(usage)
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":[
+		{"type":"text","text":"Compare these."},
+		{"type":"image_url","image_url":{"url":"` + imageServer.URL + `/a.png"}},
+		{"type":"image_url","image_url":{"url":"` + imageServer.URL + `/b.png"}},
+		{"type":"image_url","image_url":{"url":"` + imageServer.URL + `/c.png"}}
+	]}]}`)
+	start := time.Now()
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches))
+
+	// 每张图片是一个单独的 image 块，顺序不变
+	content := requestBody["messages"].([]any)[0].(map[string]any)["content"].([]any)
+	assert.Len(t, content, 4)
+	assert.Equal(t, "text", content[0].(map[string]any)["type"])
+	for i, path := range []string{"/a.png", "/b.png", "/c.png"} {
+		block := content[i+1].(map[string]any)
+		assert.Equal(t, "image", block["type"])
+		assert.Equal(t, strings.TrimPrefix(pngs[path], "data:image/png;base64,"), block["source"].(map[string]any)["data"])
+	}
+
+	// 提示 tokens 为每张图片按尺寸估算的和：1000*1000/750 + 200*150/750 + 300*300/750
+	textTokens := common.CountTokenText("Compare these.", "claude-3-5-sonnet-20241022")
+	assert.Equal(t, textTokens+1334+40+120+6, provisionalTokens)
+}
@@ -0,0 +1,68 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"time"
+)
+
+// 一次流式请求的耗时，从发送请求开始计算，FirstTokenLatency 为 0 表示没有收到任何内容
+type StreamMetrics struct {
+	ChannelId         int           `json:"channel_id"`
+	Model             string        `json:"model"`
+	FirstTokenLatency time.Duration `json:"first_token_latency"`
+	Duration          time.Duration `json:"duration"`
+	Error             string        `json:"error,omitempty"`
+}
+
+// 流结束时调用，默认写入系统日志，可替换为上报到监控系统
+var StreamMetricsHandler = func(ctx context.Context, metrics *StreamMetrics) {
+	common.LogInfo(ctx, fmt.Sprintf("claude stream: channel %d, model %s, first token %dms, duration %dms", metrics.ChannelId, metrics.Model, metrics.FirstTokenLatency.Milliseconds(), metrics.Duration.Milliseconds()))
+}
+
+type streamTimer struct {
+	ctx        context.Context
+	channelId  int
+	start      time.Time
+	firstToken time.Time
+}
+
+func (p *ClaudeProvider) newStreamTimer() *streamTimer {
+	timer := &streamTimer{
+		ctx:       context.Background(),
+		channelId: p.Channel.Id,
+		start:     time.Now(),
+	}
+	// gin.Context 在请求结束后会被复用，只保留请求的 context
+	if p.Context != nil {
+		timer.ctx = p.Context.Request.Context()
+	}
+	return timer
+}
+
+// 记录第一个文本、思考或工具调用内容到达的时间
+func (t *streamTimer) recordFirstToken() {
+	if t == nil || !t.firstToken.IsZero() {
+		return
+	}
+	t.firstToken = time.Now()
+}
+
+func (t *streamTimer) finish(model, errMessage string) {
+	if t == nil || StreamMetricsHandler == nil {
+		return
+	}
+
+	metrics := &StreamMetrics{
+		ChannelId: t.channelId,
+		Model:     model,
+		Duration:  time.Since(t.start),
+		Error:     errMessage,
+	}
+	if !t.firstToken.IsZero() {
+		metrics.FirstTokenLatency = t.firstToken.Sub(t.start)
+	}
+
+	StreamMetricsHandler(t.ctx, metrics)
+}
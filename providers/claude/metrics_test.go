@@ -0,0 +1,130 @@
+package claude_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"one-api/common/test"
+	"one-api/providers/claude"
+	"one-api/types"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func captureStreamMetrics(t *testing.T) *[]*claude.StreamMetrics {
+	var metrics []*claude.StreamMetrics
+	handler := claude.StreamMetricsHandler
+	claude.StreamMetricsHandler = func(ctx context.Context, m *claude.StreamMetrics) {
+		metrics = append(metrics, m)
+	}
+	t.Cleanup(func() { claude.StreamMetricsHandler = handler })
+	return &metrics
+}
+
+func TestStreamMetricsFirstToken(t *testing.T) {
+	metrics := captureStreamMetrics(t)
+
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	// 第一个内容增量在 message_start 之后 50ms 到达
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\n"+`data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`+"\n\n")
+		fmt.Fprint(w, "event: content_block_start\n"+`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`+"\n\n")
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, "event: content_block_delta\n"+`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi!"}}`+"\n\n")
+		w.(http.Flusher).Flush()
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, "event: message_delta\n"+`data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":3}}`+"\n\n")
+		fmt.Fprint(w, "event: message_stop\n"+`data: {"type":"message_stop"}`+"\n\n")
+	})
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "true"))
+	assert.Nil(t, errWithCode)
+	readChatStream(t, stream)
+
+	assert.Len(t, *metrics, 1)
+	m := (*metrics)[0]
+	assert.Equal(t, channel.Id, m.ChannelId)
+	assert.Equal(t, "claude-3-5-sonnet-20241022", m.Model)
+	assert.GreaterOrEqual(t, m.FirstTokenLatency, 50*time.Millisecond)
+	assert.GreaterOrEqual(t, m.Duration, m.FirstTokenLatency+20*time.Millisecond)
+	assert.Empty(t, m.Error)
+}
+
+func TestStreamMetricsNoContent(t *testing.T) {
+	metrics := captureStreamMetrics(t)
+
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		"event: error\n" + `data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "true"))
+	assert.Nil(t, errWithCode)
+	defer stream.Close()
+
+	dataChan, errChan := stream.Recv()
+	for done := false; !done; {
+		select {
+		case <-dataChan:
+		case <-errChan:
+			done = true
+		}
+	}
+
+	// 没有收到内容时首字延迟为 0
+	assert.Len(t, *metrics, 1)
+	assert.Equal(t, time.Duration(0), (*metrics)[0].FirstTokenLatency)
+	assert.Equal(t, "Overloaded", (*metrics)[0].Error)
+}
+
+func TestStreamMetricsFirstToolCall(t *testing.T) {
+	metrics := captureStreamMetrics(t)
+
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	// 工具调用开始后 100ms 才收到参数，首字时间按工具调用开始计算
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\n"+`data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`+"\n\n")
+		fmt.Fprint(w, "event: content_block_start\n"+`data: {"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_01","name":"get_weather","input":{}}}`+"\n\n")
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, "event: content_block_delta\n"+`data: {"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{}"}}`+"\n\n")
+		fmt.Fprint(w, "event: content_block_stop\n"+`data: {"type":"content_block_stop","index":0}`+"\n\n")
+		fmt.Fprint(w, "event: message_delta\n"+`data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":3}}`+"\n\n")
+		fmt.Fprint(w, "event: message_stop\n"+`data: {"type":"message_stop"}`+"\n\n")
+	})
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "true"))
+	assert.Nil(t, errWithCode)
+	readChatStream(t, stream)
+
+	assert.Len(t, *metrics, 1)
+	m := (*metrics)[0]
+	assert.Greater(t, m.FirstTokenLatency, time.Duration(0))
+	assert.Less(t, m.FirstTokenLatency, 100*time.Millisecond)
+	assert.GreaterOrEqual(t, m.Duration, 100*time.Millisecond)
+}
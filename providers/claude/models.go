@@ -0,0 +1,237 @@
+package claude
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/common/requester"
+	"one-api/types"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	modelsURL             = "/v1/models"
+	modelsPageLimit       = 1000
+	defaultModelsCacheTTL = 3600
+	modelsRetryInterval   = time.Minute
+	maxModelSuggestions   = 3
+)
+
+type modelsCacheEntry struct {
+	models    []string
+	expiresAt time.Time
+}
+
+// 按渠道缓存模型列表，不同的密钥可用的模型可能不同
+var (
+	modelsCacheLock sync.Mutex
+	modelsCache     = make(map[int]modelsCacheEntry)
+	// 获取模型列表失败的渠道，在此之前不再重新获取
+	modelsUnavailable = make(map[int]time.Time)
+	// 正在后台获取模型列表的渠道
+	modelsRefreshing = make(map[int]bool)
+)
+
+// 从 Anthropic 的 /v1/models 获取渠道可用的模型，结果按渠道的 models_cache_ttl（秒）缓存
+func (p *ClaudeProvider) ListModels() ([]string, *types.OpenAIErrorWithStatusCode) {
+	if p.isBedrock() || p.isVertex() {
+		return nil, common.StringErrorWrapper(fmt.Sprintf("listing models is not supported on %s", p.getPluginParam("anthropic", "platform")), "unsupported_api", http.StatusNotImplemented)
+	}
+
+	if models := p.getCachedModels(); models != nil {
+		return models, nil
+	}
+
+	models, errWithCode := p.fetchModels(p.Requester, p.GetRequestHeaders())
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	setCachedModels(p.Channel.Id, models, p.getModelsCacheTTL())
+
+	return models, nil
+}
+
+func (p *ClaudeProvider) getModelsCacheTTL() int {
+	return p.getPluginIntParam("anthropic", "models_cache_ttl", defaultModelsCacheTTL)
+}
+
+// 返回未过期的模型列表，没有缓存时返回 nil
+func (p *ClaudeProvider) getCachedModels() []string {
+	modelsCacheLock.Lock()
+	defer modelsCacheLock.Unlock()
+
+	entry, ok := modelsCache[p.Channel.Id]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil
+	}
+	return entry.models
+}
+
+func setCachedModels(channelId int, models []string, ttl int) {
+	if ttl <= 0 {
+		return
+	}
+
+	modelsCacheLock.Lock()
+	defer modelsCacheLock.Unlock()
+	modelsCache[channelId] = modelsCacheEntry{
+		models:    models,
+		expiresAt: time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// 按 after_id 分页获取全部模型
+func (p *ClaudeProvider) fetchModels(modelsRequester *requester.HTTPRequester, headers map[string]string) ([]string, *types.OpenAIErrorWithStatusCode) {
+	models := make([]string, 0)
+	afterId := ""
+	for {
+		query := url.Values{"limit": {fmt.Sprint(modelsPageLimit)}}
+		if afterId != "" {
+			query.Set("after_id", afterId)
+		}
+		fullRequestURL := p.GetFullRequestURL(modelsURL, "") + "?" + query.Encode()
+
+		req, err := modelsRequester.NewRequest(http.MethodGet, fullRequestURL, modelsRequester.WithHeader(headers))
+		if err != nil {
+			return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+		}
+
+		modelsResponse := &ClaudeModelsResponse{}
+		_, errWithCode := modelsRequester.SendRequest(req, modelsResponse, false)
+		if errWithCode != nil {
+			return nil, errWithCode
+		}
+
+		for _, model := range modelsResponse.Data {
+			models = append(models, model.Id)
+		}
+		if !modelsResponse.HasMore || modelsResponse.LastId == "" {
+			return models, nil
+		}
+		afterId = modelsResponse.LastId
+	}
+}
+
+// 渠道插件 model_validation 为 on 时，检查模型是否在渠道可用的模型列表中，不在时返回 404 并按编辑距离给出相近的模型
+// 只使用已缓存的模型列表，没有缓存时在后台获取并放行请求，不会因获取模型列表阻塞或失败；
+// Bedrock、Vertex 和不缓存模型列表的渠道不检查
+func (p *ClaudeProvider) checkModelSupported(modelName string) *types.OpenAIErrorWithStatusCode {
+	if p.getPluginParam("anthropic", "model_validation") != "on" || p.isBedrock() || p.isVertex() || p.getModelsCacheTTL() <= 0 {
+		return nil
+	}
+
+	models := p.getCachedModels()
+	if models == nil {
+		p.refreshModels()
+		return nil
+	}
+	if len(models) == 0 || isModelListed(modelName, models) {
+		return nil
+	}
+
+	message := fmt.Sprintf("model %s is not supported by this channel", modelName)
+	if suggestions := getSimilarModels(modelName, models); len(suggestions) > 0 {
+		message += ", did you mean " + strings.Join(suggestions, ", ") + "?"
+	}
+	return common.StringErrorWrapper(message, "model_not_found", http.StatusNotFound)
+}
+
+// 在后台获取模型列表，同一渠道同时只获取一次，获取失败时一段时间内不再重试
+func (p *ClaudeProvider) refreshModels() {
+	channelId := p.Channel.Id
+	modelsCacheLock.Lock()
+	retryAt, unavailable := modelsUnavailable[channelId]
+	if modelsRefreshing[channelId] || (unavailable && time.Now().Before(retryAt)) {
+		modelsCacheLock.Unlock()
+		return
+	}
+	modelsRefreshing[channelId] = true
+	modelsCacheLock.Unlock()
+
+	// 使用独立的 requester，不随当前请求结束而取消
+	modelsRequester := requester.NewHTTPRequester(*p.Channel.Proxy, p.Requester.ErrorHandler)
+	headers := p.GetRequestHeaders()
+	ttl := p.getModelsCacheTTL()
+	go func() {
+		models, errWithCode := p.fetchModels(modelsRequester, headers)
+
+		modelsCacheLock.Lock()
+		delete(modelsRefreshing, channelId)
+		if errWithCode != nil {
+			modelsUnavailable[channelId] = time.Now().Add(modelsRetryInterval)
+		}
+		modelsCacheLock.Unlock()
+
+		if errWithCode != nil {
+			common.SysError(fmt.Sprintf("failed to list claude models for channel #%d: %s", channelId, errWithCode.Message))
+			return
+		}
+		setCachedModels(channelId, models, ttl)
+	}()
+}
+
+// 模型列表只包含带日期的 id，-latest 和 -0 之类的别名按前缀匹配
+func isModelListed(modelName string, models []string) bool {
+	prefix := strings.TrimSuffix(strings.TrimSuffix(modelName, "-latest"), "-0") + "-"
+	for _, model := range models {
+		if model == modelName || strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 按编辑距离返回最相近的模型，距离超过模型名长度的五分之一时不认为相近
+func getSimilarModels(modelName string, models []string) []string {
+	type candidate struct {
+		model    string
+		distance int
+	}
+
+	var candidates []candidate
+	for _, model := range models {
+		if distance := levenshtein(modelName, model); distance <= len(modelName)/5 {
+			candidates = append(candidates, candidate{model, distance})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var similar []string
+	for i := 0; i < len(candidates) && i < maxModelSuggestions; i++ {
+		similar = append(similar, candidates[i].model)
+	}
+	return similar
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
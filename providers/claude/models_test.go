@@ -0,0 +1,411 @@
+package claude_test
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/test"
+	"one-api/model"
+	providers_base "one-api/providers/base"
+	"one-api/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getModelLister(t *testing.T, channel *model.Channel) providers_base.ModelListInterface {
+	context, _ := test.GetContext("GET", "/v1/models", test.RequestJSONConfig(), nil)
+	chatProvider := getChatProvider(channel, context)
+	lister, ok := chatProvider.(providers_base.ModelListInterface)
+	assert.True(t, ok)
+	return lister
+}
+
+func TestListModels(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	// 分两页返回
+	var queries []string
+	server.RegisterHandler("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("after_id") == "" {
+			fmt.Fprint(w, `{"data":[{"type":"model","id":"claude-3-7-sonnet-20250219","display_name":"Claude 3.7 Sonnet","created_at":"2025-02-19T00:00:00Z"},{"type":"model","id":"claude-3-5-sonnet-20241022","display_name":"Claude 3.5 Sonnet (New)","created_at":"2024-10-22T00:00:00Z"}],"has_more":true,"first_id":"claude-3-7-sonnet-20250219","last_id":"claude-3-5-sonnet-20241022"}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":[{"type":"model","id":"claude-3-5-haiku-20241022","display_name":"Claude 3.5 Haiku","created_at":"2024-10-22T00:00:00Z"}],"has_more":false,"first_id":"claude-3-5-haiku-20241022","last_id":"claude-3-5-haiku-20241022"}`)
+	})
+
+	channel := getClaudeChannel(url)
+	channel.Id = 6601
+	models, errWithCode := getModelLister(t, &channel).ListModels()
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, []string{"claude-3-7-sonnet-20250219", "claude-3-5-sonnet-20241022", "claude-3-5-haiku-20241022"}, models)
+	assert.Equal(t, []string{"limit=1000", "after_id=claude-3-5-sonnet-20241022&limit=1000"}, queries)
+
+	// 缓存未过期时不再请求
+	models, errWithCode = getModelLister(t, &channel).ListModels()
+	assert.Nil(t, errWithCode)
+	assert.Len(t, models, 3)
+	assert.Len(t, queries, 2)
+
+	// 其他渠道单独缓存
+	other := getClaudeChannel(url)
+	other.Id = 6602
+	_, errWithCode = getModelLister(t, &other).ListModels()
+	assert.Nil(t, errWithCode)
+	assert.Len(t, queries, 4)
+}
+
+func TestListModelsNoCache(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	calls := 0
+	server.RegisterHandler("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":[{"type":"model","id":"claude-3-5-haiku-20241022","display_name":"Claude 3.5 Haiku","created_at":"2024-10-22T00:00:00Z"}],"has_more":false}`)
+	})
+
+	channel := getClaudeChannel(url)
+	channel.Id = 6603
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"models_cache_ttl": "0"}})
+	for i := 0; i < 2; i++ {
+		models, errWithCode := getModelLister(t, &channel).ListModels()
+		assert.Nil(t, errWithCode)
+		assert.Equal(t, []string{"claude-3-5-haiku-20241022"}, models)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestListModelsError(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	server.RegisterHandler("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+	})
+
+	channel := getClaudeChannel(url)
+	channel.Id = 6604
+	_, errWithCode := getModelLister(t, &channel).ListModels()
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusUnauthorized, errWithCode.StatusCode)
+
+	// Bedrock 渠道不支持
+	channel = getBedrockChannel(url)
+	_, errWithCode = getModelLister(t, &channel).ListModels()
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "unsupported_api", errWithCode.Code)
+}
+
+func TestChatCompletionsModelSuggestions(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	server.RegisterHandler("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":[{"type":"model","id":"claude-3-7-sonnet-20250219"},{"type":"model","id":"claude-3-5-sonnet-20241022"},{"type":"model","id":"claude-3-5-haiku-20241022"}],"has_more":false}`)
+	})
+	var calls int
+	server.RegisterHandler("/v1/messages", handleClaudeStatus(&calls, http.StatusOK, `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`))
+
+	validation := map[string]any{"model_validation": "on"}
+	tests := []struct {
+		name    string
+		model   string
+		params  map[string]any
+		message string
+	}{
+		{"typo", "claude-3-5-sonet-20241022", validation, "model claude-3-5-sonet-20241022 is not supported by this channel, did you mean claude-3-5-sonnet-20241022, claude-3-5-haiku-20241022?"},
+		{"no similar model", "gpt-4o", validation, "model gpt-4o is not supported by this channel"},
+		{"listed model", "claude-3-5-haiku-20241022", validation, ""},
+		{"latest alias", "claude-3-7-sonnet-latest", validation, ""},
+		{"model alias", "claude-best", map[string]any{"model_validation": "on", "model_alias": `{"claude-best":"claude-3-7-sonnet-20250219"}`}, ""},
+		{"validation off by default", "claude-3-5-sonet-20241022", nil, ""},
+	}
+
+	// 校验只使用已缓存的模型列表
+	channel := getClaudeChannel(url)
+	channel.Id = 6605
+	_, errWithCode := getModelLister(t, &channel).ListModels()
+	assert.Nil(t, errWithCode)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			channel := getClaudeChannel(url)
+			channel.Id = 6605
+			if tt.params != nil {
+				setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": tt.params})
+			}
+
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", tt.model, "false"))
+
+			if tt.message == "" {
+				assert.Nil(t, errWithCode)
+				assert.Equal(t, 1, calls)
+				return
+			}
+
+			// 不发送到上游
+			assert.NotNil(t, errWithCode)
+			assert.Equal(t, http.StatusNotFound, errWithCode.StatusCode)
+			assert.Equal(t, "model_not_found", errWithCode.Code)
+			assert.Equal(t, tt.message, errWithCode.Message)
+			assert.Equal(t, 0, calls)
+		})
+	}
+}
+
+func TestChatCompletionsModelValidationColdCache(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	listed := make(chan struct{}, 1)
+	server.RegisterHandler("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":[{"type":"model","id":"claude-3-5-sonnet-20241022"}],"has_more":false}`)
+		listed <- struct{}{}
+	})
+	var calls int
+	server.RegisterHandler("/v1/messages", handleClaudeStatus(&calls, http.StatusOK, `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`))
+
+	channel := getClaudeChannel(url)
+	channel.Id = 6606
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"model_validation": "on"}})
+	send := func() *types.OpenAIErrorWithStatusCode {
+		context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+		chatProvider := getChatProvider(&channel, context)
+		chatProvider.SetUsage(&types.Usage{})
+		_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-5-sonet-20241022", "false"))
+		return errWithCode
+	}
+
+	// 没有缓存时不等待模型列表，直接转发
+	assert.Nil(t, send())
+	assert.Equal(t, 1, calls)
+
+	// 后台获取到模型列表后开始校验
+	<-listed
+	assert.Eventually(t, func() bool {
+		errWithCode := send()
+		return errWithCode != nil && errWithCode.Code == "model_not_found"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestChatCompletionsModelAlias(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-7-sonnet-20250219","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == "text/event-stream" {
+			handleClaudeStreamEndpoint(&requestBody, []string{
+				"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-7-sonnet-20250219","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+				"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi!"}}`,
+				"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":3}}`,
+				"event: message_stop\n" + `data: {"type":"message_stop"}`,
+			})(w, r)
+			return
+		}
+		handleClaudeEndpoint(&requestBody, response)(w, r)
+	})
+
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"model_alias": `{"claude-best": "claude-3-7-sonnet-20250219"}`},
+	})
+
+	// 别名转换为实际模型，响应中返回别名
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-best", "false"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "claude-3-7-sonnet-20250219", requestBody["model"])
+	assert.Equal(t, "claude-best", openaiResponse.Model)
+
+	context, _ = test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	chatProvider = getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", "claude-best", "true"))
+	assert.Nil(t, errWithCode)
+	for _, streamResponse := range readChatStream(t, stream) {
+		assert.Equal(t, "claude-best", streamResponse.Model)
+	}
+	assert.Equal(t, "claude-3-7-sonnet-20250219", requestBody["model"])
+
+	// 不是别名时原样请求，返回 Claude 实际使用的模型
+	context, _ = test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	chatProvider = getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode = chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-7-sonnet-latest", "false"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "claude-3-7-sonnet-latest", requestBody["model"])
+	assert.Equal(t, "claude-3-7-sonnet-20250219", openaiResponse.Model)
+}
+
+func TestChatCompletionsModelDefaults(t *testing.T) {
+	modelDefaults := `{"claude-3-opus":{"max_tokens":1024,"temperature":0.5,"top_p":0.9},"claude-3-5":{"max_tokens":2048}}`
+	tests := []struct {
+		name     string
+		model    string
+		params   string
+		expected map[string]any
+	}{
+		{"defaults apply", "claude-3-opus-20240229", `"messages":[{"role":"user","content":"Hello!"}]`, map[string]any{"max_tokens": float64(1024), "temperature": 0.5, "top_p": 0.9}},
+		{"client values win", "claude-3-opus-20240229", `"messages":[{"role":"user","content":"Hello!"}],"max_tokens":300,"temperature":0.2,"top_p":0.7`, map[string]any{"max_tokens": float64(300), "temperature": 0.2, "top_p": 0.7}},
+		{"partial client values", "claude-3-opus-20240229", `"messages":[{"role":"user","content":"Hello!"}],"temperature":0.2`, map[string]any{"max_tokens": float64(1024), "temperature": 0.2, "top_p": 0.9}},
+		{"explicit zero kept", "claude-3-opus-20240229", `"messages":[{"role":"user","content":"Hello!"}],"temperature":0,"top_p":0`, map[string]any{"max_tokens": float64(1024), "temperature": float64(0), "top_p": float64(0)}},
+		{"prefix match", "claude-3-5-sonnet-20241022", `"messages":[{"role":"user","content":"Hello!"}]`, map[string]any{"max_tokens": float64(2048), "temperature": nil, "top_p": nil}},
+		{"no defaults", "claude-3-haiku-20240307", `"messages":[{"role":"user","content":"Hello!"}]`, map[string]any{"max_tokens": float64(4096), "temperature": nil, "top_p": nil}},
+		{"thinking keeps sampling unset", "claude-3-opus-20240229", `"messages":[{"role":"user","content":"Hello!"}],"max_tokens":4000,"thinking":{"type":"enabled","budget_tokens":2000}`, map[string]any{"max_tokens": float64(4000), "temperature": nil, "top_p": nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			response := `{"id":"msg_01","type":"message","role":"assistant","model":"` + tt.model + `","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			channel := getClaudeChannel(url)
+			setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"model_defaults": modelDefaults}})
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+
+			chatRequest := getChatRequestFromJSON(`{"model":"` + tt.model + `",` + tt.params + `}`)
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			assert.Nil(t, errWithCode)
+
+			for key, value := range tt.expected {
+				assert.Equal(t, value, requestBody[key], key)
+			}
+		})
+	}
+}
+
+func TestChatCompletionsResponseModel(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-latest", "false"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "claude-3-5-sonnet-20241022", openaiResponse.Model)
+
+	// 未返回 model 时使用请求的模型
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, strings.Replace(response, `"model":"claude-3-5-sonnet-20241022",`, "", 1)))
+	openaiResponse, errWithCode = chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-latest", "false"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "claude-3-5-sonnet-latest", openaiResponse.Model)
+}
+
+func TestChatCompletionsStreamResponseModel(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":25,"output_tokens":1}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi!"}}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":3}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-latest", "true"))
+	assert.Nil(t, errWithCode)
+
+	responses := readChatStream(t, stream)
+	assert.NotEmpty(t, responses)
+	for _, response := range responses {
+		assert.Equal(t, "claude-3-5-sonnet-20241022", response.Model)
+	}
+}
+
+func TestChatCompletionsContextWindow(t *testing.T) {
+	common.ApproximateTokenEnabled = true
+	defer func() { common.ApproximateTokenEnabled = false }()
+
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	var requests int
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-2.0","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		handleClaudeEndpoint(&requestBody, response)(w, r)
+	})
+
+	// 第一条消息约 114000 tokens，超过 claude-2.0 的 100000
+	getRequest := func() *types.ChatCompletionRequest {
+		return &types.ChatCompletionRequest{
+			Model:     "claude-2.0",
+			MaxTokens: 1000,
+			Messages: []types.ChatCompletionMessage{
+				{Role: types.ChatMessageRoleUser, Content: strings.Repeat("a", 300000)},
+				{Role: types.ChatMessageRoleAssistant, Content: "OK"},
+				{Role: types.ChatMessageRoleUser, Content: "Hello!"},
+			},
+		}
+	}
+
+	// 默认在发送前拒绝
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(getRequest())
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	assert.Equal(t, "context_length_exceeded", errWithCode.Code)
+	assert.Equal(t, 0, requests)
+
+	// truncate 时删除最早的消息，保证第一条消息为 user
+	context, _ = test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"context_window_policy": "truncate"},
+	})
+	chatProvider = getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+	_, errWithCode = chatProvider.CreateChatCompletion(getRequest())
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, []any{
+		map[string]any{"role": "user", "content": []any{map[string]any{"type": "text", "text": "Hello!"}}},
+	}, requestBody["messages"])
+
+	// 只剩一条消息仍然超出时拒绝
+	request := getRequest()
+	request.Messages = request.Messages[:1]
+	_, errWithCode = chatProvider.CreateChatCompletion(request)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "context_length_exceeded", errWithCode.Code)
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,180 @@
+package claude_test
+
+import (
+	"net/http"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatCompletionsTopK(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"top_k":40}`)
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, float64(40), requestBody["top_k"])
+
+	requestBody = map[string]any{}
+	chatRequest = getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}]}`)
+	_, errWithCode = chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.NotContains(t, requestBody, "top_k")
+}
+
+func TestChatCompletionsMaxTokensLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     string
+		policy    string
+		params    string
+		maxTokens float64
+		errCode   string
+	}{
+		{"clamp claude-3-opus", "claude-3-opus-20240229", "", `"max_tokens":200000`, 4096, ""},
+		{"clamp claude-3-5-sonnet", "claude-3-5-sonnet-20241022", "clamp", `"max_tokens":200000`, 8192, ""},
+		{"clamp claude-3-7-sonnet", "claude-3-7-sonnet-20250219", "", `"max_tokens":200000`, 64000, ""},
+		{"within limit", "claude-3-5-sonnet-20241022", "", `"max_tokens":8000`, 8000, ""},
+		{"unknown model passes through", "claude-next", "", `"max_tokens":200000`, 200000, ""},
+		{"reject claude-3-opus", "claude-3-opus-20240229", "reject", `"max_tokens":200000`, 0, "invalid_max_tokens"},
+		{"reject claude-3-5-haiku", "claude-3-5-haiku-20241022", "reject", `"max_tokens":8193`, 0, "invalid_max_tokens"},
+		{"clamp below thinking budget", "claude-3-5-sonnet-20241022", "", `"max_tokens":20000,"thinking":{"type":"enabled","budget_tokens":10000}`, 0, "invalid_thinking"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			response := `{"id":"msg_01","type":"message","role":"assistant","model":"` + tt.model + `","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			channel := getClaudeChannel(url)
+			if tt.policy != "" {
+				setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"max_tokens_policy": tt.policy}})
+			}
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+
+			chatRequest := getChatRequestFromJSON(`{"model":"` + tt.model + `","messages":[{"role":"user","content":"Hello!"}],` + tt.params + `}`)
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+			if tt.errCode != "" {
+				assert.NotNil(t, errWithCode)
+				assert.Equal(t, tt.errCode, errWithCode.Code)
+				assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+				assert.Empty(t, requestBody)
+				return
+			}
+
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, tt.maxTokens, requestBody["max_tokens"])
+		})
+	}
+}
+
+func TestChatCompletionsMinMaxTokens(t *testing.T) {
+	tests := []struct {
+		name         string
+		minMaxTokens string
+		params       string
+		maxTokens    float64
+	}{
+		{"raised to floor", "16", `,"max_tokens":1`, 16},
+		{"above floor", "16", `,"max_tokens":100`, 100},
+		{"default max_tokens", "16", "", 4096},
+		{"no floor", "", `,"max_tokens":1`, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			channel := getClaudeChannel(url)
+			if tt.minMaxTokens != "" {
+				setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"min_max_tokens": tt.minMaxTokens}})
+			}
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}]` + tt.params + `}`)
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, tt.maxTokens, requestBody["max_tokens"])
+		})
+	}
+}
+
+func TestChatCompletionsSamplingParamsRange(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		params      string
+		temperature any
+		topP        any
+		rejected    bool
+	}{
+		{"clamp temperature", "", `"temperature":1.5`, float64(1), nil, false},
+		{"clamp temperature explicitly", "clamp", `"temperature":1.5,"top_p":0.9`, float64(1), 0.9, false},
+		{"clamp negative top_p", "", `"temperature":0.7,"top_p":-0.5`, 0.7, float64(0), false},
+		{"within range", "reject", `"temperature":0.7,"top_p":0.9`, 0.7, 0.9, false},
+		{"reject temperature", "reject", `"temperature":1.5`, nil, nil, true},
+		{"reject top_p", "reject", `"top_p":1.2`, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			channel := getClaudeChannel(url)
+			if tt.policy != "" {
+				setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"temperature_policy": tt.policy}})
+			}
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],` + tt.params + `}`)
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+			if tt.rejected {
+				assert.NotNil(t, errWithCode)
+				assert.Equal(t, "invalid_sampling_params", errWithCode.Code)
+				assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+				assert.Contains(t, errWithCode.Message, "between 0 and 1")
+				assert.Empty(t, requestBody)
+				return
+			}
+
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, tt.temperature, requestBody["temperature"])
+			assert.Equal(t, tt.topP, requestBody["top_p"])
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package claude
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/types"
+)
+
+// 透传原生的 Anthropic Messages 请求，请求体和响应体都不做转换，只从响应中解析用量用于计费
+func (p *ClaudeProvider) CreateMessages(body []byte, stream bool) (*http.Response, *types.OpenAIErrorWithStatusCode) {
+	if p.isBedrock() || p.isVertex() {
+		return nil, common.StringErrorWrapper("passthrough is only supported for the Anthropic API", "passthrough_not_supported", http.StatusBadRequest)
+	}
+
+	url, errWithCode := p.GetSupportedAPIUri(common.RelayModeChatCompletions)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	fullRequestURL := p.GetFullRequestURL(url, "")
+	if fullRequestURL == "" {
+		return nil, common.ErrorWrapper(nil, "invalid_claude_config", http.StatusInternalServerError)
+	}
+
+	headers := p.GetRequestHeaders()
+	if stream {
+		headers["Accept"] = "text/event-stream"
+	}
+
+	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(bytes.NewReader(body)), p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+
+	// 上游的错误响应在转换为 OpenAI 错误前保留一份，原样返回给客户端
+	var errorResp *http.Response
+	errorHandler := p.Requester.ErrorHandler
+	p.Requester.ErrorHandler = func(resp *http.Response) *types.OpenAIError {
+		data, _ := io.ReadAll(resp.Body)
+		errorResp = &http.Response{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header.Clone(),
+			Body:       io.NopCloser(bytes.NewReader(data)),
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		return errorHandler(resp)
+	}
+	defer func() {
+		p.Requester.ErrorHandler = errorHandler
+	}()
+
+	resp, errWithCode := p.sendRequestWithBreaker(req, stream)
+	if errWithCode != nil {
+		return errorResp, errWithCode
+	}
+
+	if stream {
+		resp.Body = &passthroughStreamBody{body: resp.Body, provider: p}
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "read_response_failed", http.StatusInternalServerError)
+	}
+
+	claudeResponse := &ClaudeResponse{}
+	if err := json.Unmarshal(data, claudeResponse); err != nil {
+		return nil, common.ErrorWrapper(err, "decode_response_failed", http.StatusInternalServerError)
+	}
+	p.setPassthroughUsage(&claudeResponse.Usage, true)
+
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
+
+// 用上游返回的用量覆盖预估值，流式响应中 message_delta 只有 output_tokens
+func (p *ClaudeProvider) setPassthroughUsage(usage *Usage, withPrompt bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.Usage == nil {
+		return
+	}
+	if withPrompt && usage.GetPromptTokens() > 0 {
+		p.Usage.PromptTokens = usage.GetPromptTokens()
+		p.Usage.CacheCreationInputTokens = usage.CacheCreationInputTokens
+		p.Usage.CacheReadInputTokens = usage.CacheReadInputTokens
+	}
+	if usage.ServiceTier != "" {
+		p.Usage.ServiceTier = usage.ServiceTier
+	}
+	p.Usage.CompletionTokens = usage.OutputTokens
+	p.Usage.TotalTokens = p.Usage.PromptTokens + p.Usage.CompletionTokens
+	setUsageDetails(p.Usage)
+}
+
+// 原样返回上游的 SSE 数据，读取时解析其中的用量
+type passthroughStreamBody struct {
+	body     io.ReadCloser
+	provider *ClaudeProvider
+	line     []byte
+}
+
+func (b *passthroughStreamBody) Read(data []byte) (int, error) {
+	n, err := b.body.Read(data)
+	b.line = append(b.line, data[:n]...)
+
+	// 只解析完整的行，剩余部分等待下次读取
+	for {
+		index := bytes.IndexByte(b.line, '\n')
+		if index < 0 {
+			break
+		}
+		b.handleLine(bytes.TrimRight(b.line[:index], "\r"))
+		b.line = b.line[index+1:]
+	}
+
+	return n, err
+}
+
+func (b *passthroughStreamBody) handleLine(line []byte) {
+	if !bytes.HasPrefix(line, []byte("data:")) {
+		return
+	}
+
+	var claudeResponse ClaudeStreamResponse
+	if err := json.Unmarshal(bytes.TrimSpace(line[len("data:"):]), &claudeResponse); err != nil {
+		return
+	}
+
+	switch claudeResponse.Type {
+	case "message_start":
+		b.provider.setPassthroughUsage(&claudeResponse.Message.Usage, true)
+	case "message_delta":
+		b.provider.setPassthroughUsage(&claudeResponse.Usage, claudeResponse.Usage.GetPromptTokens() > 0)
+	}
+}
+
+func (b *passthroughStreamBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,125 @@
+package claude_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/providers"
+	providers_base "one-api/providers/base"
+	"one-api/types"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateMessages(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	headers := test.RequestJSONConfig()
+	headers["anthropic-beta"] = "mcp-client-2025-04-04"
+	context, _ := test.GetContext("POST", "/v1/messages", headers, nil)
+	defer teardown()
+
+	// 请求体中包含 OpenAI 格式没有的字段，需要原样转发
+	body := `{"model":"claude-3-5-sonnet-20241022","max_tokens":1024,"system":[{"type":"text","text":"Be brief.","cache_control":{"type":"ephemeral"}}],"messages":[{"role":"user","content":"Hello!"}],"mcp_servers":[{"type":"url","url":"https://example.com/sse","name":"example"}]}`
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":20,"output_tokens":3,"cache_read_input_tokens":5}}`
+
+	var upstreamBody, upstreamBetas string
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		upstreamBody = string(data)
+		upstreamBetas = r.Header.Get("anthropic-beta")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, response)
+	})
+
+	channel := getClaudeChannel(url)
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": {"beta": "prompt-caching-2024-07-31,mcp-client-2025-04-04"}})
+	provider, ok := providers.GetProvider(&channel, context).(providers_base.MessagesPassthroughInterface)
+	assert.True(t, ok)
+	usage := &types.Usage{PromptTokens: 100}
+	provider.SetUsage(usage)
+
+	resp, errWithCode := provider.CreateMessages([]byte(body), false)
+	assert.Nil(t, errWithCode)
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+
+	assert.Equal(t, body, upstreamBody)
+	// 客户端的 beta 与渠道配置的合并去重
+	assert.Equal(t, "prompt-caching-2024-07-31,mcp-client-2025-04-04", upstreamBetas)
+	assert.Equal(t, response, string(data))
+	assert.Equal(t, 25, usage.PromptTokens)
+	assert.Equal(t, 3, usage.CompletionTokens)
+	assert.Equal(t, 28, usage.TotalTokens)
+	assert.Equal(t, 5, usage.CacheReadInputTokens)
+}
+
+func TestCreateMessagesStream(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/messages", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":25,"output_tokens":1,"cache_creation_input_tokens":10}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: ping\n" + `data: {"type":"ping"}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":15}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	provider := providers.GetProvider(&channel, context).(providers_base.MessagesPassthroughInterface)
+	usage := &types.Usage{PromptTokens: 100}
+	provider.SetUsage(usage)
+
+	body := `{"model":"claude-3-5-sonnet-20241022","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"Hello!"}]}`
+	resp, errWithCode := provider.CreateMessages([]byte(body), true)
+	assert.Nil(t, errWithCode)
+	defer resp.Body.Close()
+
+	// 每次只读一个字节，用量需要在行读取完整后解析
+	data, err := io.ReadAll(iotest.OneByteReader(resp.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join(events, "\n\n")+"\n\n", string(data))
+
+	assert.Equal(t, 35, usage.PromptTokens)
+	assert.Equal(t, 15, usage.CompletionTokens)
+	assert.Equal(t, 50, usage.TotalTokens)
+	assert.Equal(t, 10, usage.CacheCreationInputTokens)
+}
+
+func TestCreateMessagesError(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/messages", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: Field required"}}`)
+	})
+
+	channel := getClaudeChannel(url)
+	provider := providers.GetProvider(&channel, context).(providers_base.MessagesPassthroughInterface)
+	provider.SetUsage(&types.Usage{})
+
+	resp, errWithCode := provider.CreateMessages([]byte(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":"Hello!"}]}`), false)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	assert.Contains(t, errWithCode.Message, "max_tokens: Field required")
+
+	// 同时返回上游的原始响应
+	assert.NotNil(t, resp)
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: Field required"}}`, string(data))
+}
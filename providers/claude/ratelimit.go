@@ -0,0 +1,134 @@
+package claude
+
+import (
+	"net/http"
+	"one-api/model"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Anthropic 在响应头 anthropic-ratelimit-* 中返回的限额，未返回的字段为零值
+type RateLimit struct {
+	RequestsLimit         int       `json:"requests_limit"`
+	RequestsRemaining     int       `json:"requests_remaining"`
+	RequestsReset         time.Time `json:"requests_reset"`
+	TokensLimit           int       `json:"tokens_limit"`
+	TokensRemaining       int       `json:"tokens_remaining"`
+	TokensReset           time.Time `json:"tokens_reset"`
+	InputTokensLimit      int       `json:"input_tokens_limit"`
+	InputTokensRemaining  int       `json:"input_tokens_remaining"`
+	InputTokensReset      time.Time `json:"input_tokens_reset"`
+	OutputTokensLimit     int       `json:"output_tokens_limit"`
+	OutputTokensRemaining int       `json:"output_tokens_remaining"`
+	OutputTokensReset     time.Time `json:"output_tokens_reset"`
+	UpdatedAt             time.Time `json:"updated_at"`
+}
+
+var (
+	rateLimitsLock sync.RWMutex
+	rateLimits     = make(map[int]*RateLimit)
+)
+
+// 解析限额响应头，没有任何限额头时返回 nil
+func ParseRateLimitHeaders(header http.Header) *RateLimit {
+	rateLimit := &RateLimit{}
+	found := false
+
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{"requests-limit", &rateLimit.RequestsLimit},
+		{"requests-remaining", &rateLimit.RequestsRemaining},
+		{"tokens-limit", &rateLimit.TokensLimit},
+		{"tokens-remaining", &rateLimit.TokensRemaining},
+		{"input-tokens-limit", &rateLimit.InputTokensLimit},
+		{"input-tokens-remaining", &rateLimit.InputTokensRemaining},
+		{"output-tokens-limit", &rateLimit.OutputTokensLimit},
+		{"output-tokens-remaining", &rateLimit.OutputTokensRemaining},
+	}
+	for _, field := range ints {
+		if number, err := strconv.Atoi(header.Get("anthropic-ratelimit-" + field.name)); err == nil {
+			*field.value = number
+			found = true
+		}
+	}
+
+	// 重置时间为 RFC 3339 格式
+	times := []struct {
+		name  string
+		value *time.Time
+	}{
+		{"requests-reset", &rateLimit.RequestsReset},
+		{"tokens-reset", &rateLimit.TokensReset},
+		{"input-tokens-reset", &rateLimit.InputTokensReset},
+		{"output-tokens-reset", &rateLimit.OutputTokensReset},
+	}
+	for _, field := range times {
+		if resetTime, err := time.Parse(time.RFC3339, header.Get("anthropic-ratelimit-"+field.name)); err == nil {
+			*field.value = resetTime
+			found = true
+		}
+	}
+
+	if !found {
+		return nil
+	}
+
+	rateLimit.UpdatedAt = time.Now()
+	return rateLimit
+}
+
+// 返回用尽的限额中最晚的重置时间，没有用尽时返回零值
+func (r *RateLimit) exhaustedUntil() time.Time {
+	var until time.Time
+	limits := []struct {
+		limit     int
+		remaining int
+		reset     time.Time
+	}{
+		{r.RequestsLimit, r.RequestsRemaining, r.RequestsReset},
+		{r.TokensLimit, r.TokensRemaining, r.TokensReset},
+		{r.InputTokensLimit, r.InputTokensRemaining, r.InputTokensReset},
+		{r.OutputTokensLimit, r.OutputTokensRemaining, r.OutputTokensReset},
+	}
+	for _, limit := range limits {
+		// 未返回限额时 limit 为 0
+		if limit.limit > 0 && limit.remaining <= 0 && limit.reset.After(until) {
+			until = limit.reset
+		}
+	}
+
+	return until
+}
+
+// 记录渠道最近一次返回的限额，限额用尽时渠道冷却到重置时间，不再被选中
+func saveRateLimit(channelId int, header http.Header) {
+	rateLimit := ParseRateLimitHeaders(header)
+	if rateLimit == nil {
+		return
+	}
+
+	if until := rateLimit.exhaustedUntil(); until.After(time.Now()) {
+		model.ChannelGroup.CooldownsUntil(channelId, until.Unix())
+	}
+
+	rateLimitsLock.Lock()
+	defer rateLimitsLock.Unlock()
+	rateLimits[channelId] = rateLimit
+}
+
+// 获取渠道最近一次返回的限额，没有记录时返回 nil
+func GetRateLimit(channelId int) *RateLimit {
+	rateLimitsLock.RLock()
+	defer rateLimitsLock.RUnlock()
+
+	rateLimit, ok := rateLimits[channelId]
+	if !ok {
+		return nil
+	}
+
+	copied := *rateLimit
+	return &copied
+}
@@ -0,0 +1,127 @@
+package claude_test
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/providers/claude"
+	"one-api/types"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setRateLimitHeaders(header http.Header) {
+	header.Set("anthropic-ratelimit-requests-limit", "50")
+	header.Set("anthropic-ratelimit-requests-remaining", "49")
+	header.Set("anthropic-ratelimit-requests-reset", "2024-05-01T12:00:05Z")
+	header.Set("anthropic-ratelimit-tokens-limit", "40000")
+	header.Set("anthropic-ratelimit-tokens-remaining", "39000")
+	header.Set("anthropic-ratelimit-tokens-reset", "2024-05-01T12:00:10Z")
+	header.Set("anthropic-ratelimit-input-tokens-limit", "30000")
+	header.Set("anthropic-ratelimit-input-tokens-remaining", "29500")
+	header.Set("anthropic-ratelimit-input-tokens-reset", "2024-05-01T12:00:08Z")
+	header.Set("anthropic-ratelimit-output-tokens-limit", "10000")
+	header.Set("anthropic-ratelimit-output-tokens-remaining", "9500")
+	header.Set("anthropic-ratelimit-output-tokens-reset", "2024-05-01T12:00:02Z")
+}
+
+func TestParseRateLimitHeaders(t *testing.T) {
+	header := http.Header{}
+	assert.Nil(t, claude.ParseRateLimitHeaders(header))
+
+	setRateLimitHeaders(header)
+	rateLimit := claude.ParseRateLimitHeaders(header)
+	assert.NotNil(t, rateLimit)
+	assert.Equal(t, 50, rateLimit.RequestsLimit)
+	assert.Equal(t, 49, rateLimit.RequestsRemaining)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 5, 0, time.UTC), rateLimit.RequestsReset.UTC())
+	assert.Equal(t, 40000, rateLimit.TokensLimit)
+	assert.Equal(t, 39000, rateLimit.TokensRemaining)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 10, 0, time.UTC), rateLimit.TokensReset.UTC())
+	assert.Equal(t, 30000, rateLimit.InputTokensLimit)
+	assert.Equal(t, 29500, rateLimit.InputTokensRemaining)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 8, 0, time.UTC), rateLimit.InputTokensReset.UTC())
+	assert.Equal(t, 10000, rateLimit.OutputTokensLimit)
+	assert.Equal(t, 9500, rateLimit.OutputTokensRemaining)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 2, 0, time.UTC), rateLimit.OutputTokensReset.UTC())
+
+	// 格式错误的值被忽略
+	header = http.Header{}
+	header.Set("anthropic-ratelimit-requests-remaining", "many")
+	header.Set("anthropic-ratelimit-tokens-remaining", "100")
+	rateLimit = claude.ParseRateLimitHeaders(header)
+	assert.Equal(t, 0, rateLimit.RequestsRemaining)
+	assert.Equal(t, 100, rateLimit.TokensRemaining)
+}
+
+func TestChatCompletionsRateLimit(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		setRateLimitHeaders(w.Header())
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, response)
+	})
+
+	channel := getClaudeChannel(url)
+	channel.Id = 5201
+	assert.Nil(t, claude.GetRateLimit(channel.Id))
+
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false"))
+	assert.Nil(t, errWithCode)
+
+	rateLimit := claude.GetRateLimit(channel.Id)
+	assert.NotNil(t, rateLimit)
+	assert.Equal(t, 49, rateLimit.RequestsRemaining)
+	assert.Equal(t, 39000, rateLimit.TokensRemaining)
+}
+
+func TestChatCompletionsRateLimitCooldown(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	reset := time.Now().Add(30 * time.Second).UTC().Truncate(time.Second)
+	remaining := "1"
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("anthropic-ratelimit-requests-limit", "50")
+		w.Header().Set("anthropic-ratelimit-requests-remaining", remaining)
+		w.Header().Set("anthropic-ratelimit-requests-reset", reset.Format(time.RFC3339))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, response)
+	})
+
+	channel := getClaudeChannel(url)
+	channel.Id = 5202
+	registerChannel(t, &channel)
+	send := func() {
+		context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+		chatProvider := getChatProvider(&channel, context)
+		chatProvider.SetUsage(&types.Usage{})
+		_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-opus-20240229", "false"))
+		assert.Nil(t, errWithCode)
+	}
+	cooldownsTime := func() int64 {
+		model.ChannelGroup.RLock()
+		defer model.ChannelGroup.RUnlock()
+		return model.ChannelGroup.Channels[channel.Id].CooldownsTime
+	}
+
+	// 还有剩余请求数时不冷却
+	send()
+	assert.Equal(t, int64(0), cooldownsTime())
+
+	// 请求数用尽后冷却到重置时间
+	remaining = "0"
+	send()
+	assert.Equal(t, reset.Unix(), cooldownsTime())
+	assert.Nil(t, model.ChannelGroup.Balancer([]int{channel.Id}))
+}
@@ -0,0 +1,384 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"io"
+	"one-api/common/requester"
+	"one-api/types"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 每个流默认最多缓存的字节数，可通过渠道插件 stream_resume_max_bytes 修改，所有流合计最多缓存 maxStreamBuffersSize 字节
+const (
+	defaultStreamBufferSize = 8 << 20
+	maxStreamBuffersSize    = 256 << 20
+)
+
+// 缓存的流式响应，按渠道、令牌和请求内容区分，客户端断开后上游请求继续，写完后保留 stream_resume_ttl 秒
+// 客户端断开超过 stream_resume_ttl 秒没有重连时取消上游请求；超出缓存上限后不再支持续传，
+// 只保留读取者还没读到的数据，并按最慢的读取者的速度读取上游，没有读取者时取消上游请求
+type streamBuffer struct {
+	lock    sync.Mutex
+	chunks  []string
+	base    int // chunks[0] 的序号，超出上限后丢弃已读取的数据时增加
+	err     error
+	done    bool
+	updated chan struct{}
+
+	key        string
+	size       int
+	maxSize    int
+	ttl        time.Duration
+	overflowed bool
+	cancel     context.CancelFunc
+	usage      *types.Usage // 上游的流结束前只由流处理写入
+
+	// 正在读取的流及下一条要读取的序号，读取进度变化时关闭 progress
+	readers   map[*bufferedStream]int
+	progress  chan struct{}
+	idleTimer *time.Timer
+}
+
+var errStreamNotResumable = errors.New("stream exceeded the resume buffer limit and can no longer be resumed")
+
+var (
+	streamBuffersLock sync.Mutex
+	streamBuffers     = make(map[string]*streamBuffer)
+	streamBuffersSize int
+)
+
+func (p *ClaudeProvider) getStreamResumeTTL() time.Duration {
+	return time.Duration(p.getPluginIntParam("anthropic", "stream_resume_ttl", 0)) * time.Second
+}
+
+// 客户端重连时在 Last-Event-ID 中传回最后收到的事件 id
+func (p *ClaudeProvider) getLastEventId() int {
+	lastEventId, err := strconv.Atoi(strings.TrimSpace(p.Context.Request.Header.Get("Last-Event-ID")))
+	if err != nil || lastEventId < 0 {
+		return 0
+	}
+	return lastEventId
+}
+
+// 开启断点续传时，上游请求不随客户端断开而取消，由返回的函数在续传窗口结束后取消，需要在创建请求前调用
+func (p *ClaudeProvider) detachStreamContext() context.CancelFunc {
+	if p.getStreamResumeTTL() <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Requester.SetContext(ctx)
+	return cancel
+}
+
+// 返回已缓存的流，从 Last-Event-ID 之后开始返回，没有缓存时返回 nil
+func (p *ClaudeProvider) resumeStream(key string) requester.StreamReaderInterface[string] {
+	lastEventId := p.getLastEventId()
+	if key == "" || lastEventId == 0 {
+		return nil
+	}
+
+	streamBuffersLock.Lock()
+	buffer, ok := streamBuffers[key]
+	streamBuffersLock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	// 原请求已经计费
+	if p.Usage != nil {
+		*p.Usage = types.Usage{}
+	}
+	return newBufferedStream(buffer, lastEventId)
+}
+
+// 记录上游的流，返回从头开始读取的流，cancel 用于取消上游请求，usage 为流处理写入的用量
+// 返回的流关闭时等待上游的流结束，将最终的用量复制到 p.Usage 后再由 relay 计费
+func (p *ClaudeProvider) recordStream(key string, stream requester.StreamReaderInterface[string], cancel context.CancelFunc, usage *types.Usage) requester.StreamReaderInterface[string] {
+	buffer := &streamBuffer{
+		updated:  make(chan struct{}),
+		key:      key,
+		maxSize:  p.getPluginIntParam("anthropic", "stream_resume_max_bytes", defaultStreamBufferSize),
+		ttl:      p.getStreamResumeTTL(),
+		cancel:   cancel,
+		usage:    usage,
+		readers:  make(map[*bufferedStream]int),
+		progress: make(chan struct{}),
+	}
+	streamBuffersLock.Lock()
+	streamBuffers[key] = buffer
+	streamBuffersLock.Unlock()
+
+	go func() {
+		defer stream.Close()
+		defer cancel()
+		dataChan, errChan := stream.Recv()
+		canceled := false
+		for {
+			select {
+			case data := <-dataChan:
+				// 取消后继续读取到上游的流退出，之后用量不再变化
+				if !canceled && !buffer.append(data) {
+					canceled = true
+					cancel()
+				}
+			case err := <-errChan:
+				if canceled {
+					err = context.Canceled
+				}
+				buffer.finish(err)
+				time.AfterFunc(buffer.ttl, buffer.remove)
+				return
+			}
+		}
+	}()
+
+	first := newBufferedStream(buffer, 0)
+	first.usage = p.Usage
+	return first
+}
+
+// 写入一条数据，超出上限且没有读取者时返回 false，不再读取上游
+func (b *streamBuffer) append(data string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.overflowed && (b.size+len(data) > b.maxSize || !reserveStreamBuffer(len(data))) {
+		b.overflowed = true
+		b.removeLocked()
+	}
+
+	if b.overflowed {
+		// 等待所有读取者读完已缓存的数据后丢弃
+		for !b.readersCaughtUp() {
+			if len(b.readers) == 0 {
+				return false
+			}
+			progress := b.progress
+			b.lock.Unlock()
+			<-progress
+			b.lock.Lock()
+		}
+		if len(b.readers) == 0 {
+			return false
+		}
+		b.base += len(b.chunks)
+		b.chunks = nil
+	} else {
+		b.size += len(data)
+	}
+
+	b.chunks = append(b.chunks, data)
+	close(b.updated)
+	b.updated = make(chan struct{})
+	return true
+}
+
+func (b *streamBuffer) readersCaughtUp() bool {
+	for _, index := range b.readers {
+		if index < b.base+len(b.chunks) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *streamBuffer) finish(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.err = err
+	b.done = true
+	if b.idleTimer != nil {
+		b.idleTimer.Stop()
+	}
+	close(b.updated)
+	b.updated = make(chan struct{})
+}
+
+// 等待上游的流结束，返回最终的用量
+func (b *streamBuffer) finalUsage() types.Usage {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for !b.done {
+		updated := b.updated
+		b.lock.Unlock()
+		<-updated
+		b.lock.Lock()
+	}
+	return *b.usage
+}
+
+// 不再支持续传，释放占用的缓存大小
+func (b *streamBuffer) remove() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.removeLocked()
+}
+
+func (b *streamBuffer) removeLocked() {
+	streamBuffersLock.Lock()
+	defer streamBuffersLock.Unlock()
+
+	if streamBuffers[b.key] == b {
+		delete(streamBuffers, b.key)
+	}
+	streamBuffersSize -= b.size
+	b.size = 0
+}
+
+// 所有流合计的缓存没有超出上限时占用 size 字节
+func reserveStreamBuffer(size int) bool {
+	streamBuffersLock.Lock()
+	defer streamBuffersLock.Unlock()
+
+	if streamBuffersSize+size > maxStreamBuffersSize {
+		return false
+	}
+	streamBuffersSize += size
+	return true
+}
+
+func (b *streamBuffer) addReader(s *bufferedStream) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.readers[s] = s.offset
+	if b.idleTimer != nil {
+		b.idleTimer.Stop()
+		b.idleTimer = nil
+	}
+}
+
+func (b *streamBuffer) setReaderIndex(s *bufferedStream, index int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.readers[s]; ok {
+		b.readers[s] = index
+		b.notifyProgress()
+	}
+}
+
+// 最后一个读取者断开后，超过 ttl 没有重连时取消上游请求，已超出缓存上限时立即取消
+func (b *streamBuffer) removeReader(s *bufferedStream) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.readers[s]; !ok {
+		return
+	}
+	delete(b.readers, s)
+	b.notifyProgress()
+	if len(b.readers) > 0 || b.done {
+		return
+	}
+	if b.overflowed {
+		b.cancel()
+		return
+	}
+
+	b.idleTimer = time.AfterFunc(b.ttl, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if len(b.readers) == 0 && !b.done {
+			b.removeLocked()
+			b.cancel()
+		}
+	})
+}
+
+func (b *streamBuffer) notifyProgress() {
+	close(b.progress)
+	b.progress = make(chan struct{})
+}
+
+// 从缓存中依次读取数据，读到末尾时等待上游继续写入
+type bufferedStream struct {
+	buffer *streamBuffer
+	offset int
+	usage  *types.Usage // 原请求的用量，续传的请求不再计费
+
+	DataChan chan string
+	ErrChan  chan error
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// 创建时即作为读取者，上游不会在开始读取前因超出缓存上限被取消
+func newBufferedStream(buffer *streamBuffer, offset int) *bufferedStream {
+	stream := &bufferedStream{
+		buffer:   buffer,
+		offset:   offset,
+		DataChan: make(chan string),
+		ErrChan:  make(chan error),
+		closed:   make(chan struct{}),
+	}
+	buffer.addReader(stream)
+	return stream
+}
+
+func (s *bufferedStream) Recv() (<-chan string, <-chan error) {
+	go s.process()
+
+	return s.DataChan, s.ErrChan
+}
+
+func (s *bufferedStream) process() {
+	for index := s.offset; ; {
+		s.buffer.lock.Lock()
+		chunks, base, err, done, updated := s.buffer.chunks, s.buffer.base, s.buffer.err, s.buffer.done, s.buffer.updated
+		s.buffer.lock.Unlock()
+
+		// 重连时缓存刚好超出上限，断点之后的数据已被丢弃
+		if index < base {
+			err, done = errStreamNotResumable, true
+		} else if index < base+len(chunks) {
+			select {
+			case s.DataChan <- chunks[index-base]:
+				index++
+				s.buffer.setReaderIndex(s, index)
+			case <-s.closed:
+				return
+			}
+			continue
+		}
+
+		if done {
+			if err == nil {
+				err = io.EOF
+			}
+			select {
+			case s.ErrChan <- err:
+			case <-s.closed:
+			}
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// 只停止读取，上游的流继续写入缓存；原请求的流等待上游结束，按完整的用量计费
+func (s *bufferedStream) Close() {
+	s.once.Do(func() {
+		close(s.closed)
+		s.buffer.removeReader(s)
+		if s.usage != nil {
+			*s.usage = s.buffer.finalUsage()
+		}
+	})
+}
+
+func (s *bufferedStream) FirstEventId() int {
+	return s.offset + 1
+}
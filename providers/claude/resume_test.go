@@ -0,0 +1,190 @@
+package claude_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common/requester"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/types"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var resumeStreamEvents = []string{
+	"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":25,"output_tokens":1}}}`,
+	"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"The"}}`,
+	"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" quick"}}`,
+	"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" brown"}}`,
+	"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" fox"}}`,
+	"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":15}}`,
+	"event: message_stop\n" + `data: {"type":"message_stop"}`,
+}
+
+func TestChatCompletionsStreamResume(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	var requests int
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		handleClaudeStreamEndpoint(nil, resumeStreamEvents)(w, r)
+	})
+
+	channel := getClaudeChannel(url)
+	channel.Id = 7801
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"stream_resume_ttl": "60"},
+	})
+
+	// 读取两条数据后断开
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	chatProvider := getChatProvider(&channel, context)
+	firstUsage := &types.Usage{}
+	chatProvider.SetUsage(firstUsage)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "true"))
+	assert.Nil(t, errWithCode)
+	eventIdStream, ok := stream.(requester.StreamEventIdInterface)
+	assert.True(t, ok)
+	assert.Equal(t, 1, eventIdStream.FirstEventId())
+
+	var content string
+	dataChan, _ := stream.Recv()
+	for i := 0; i < 2; i++ {
+		var response types.ChatCompletionStreamResponse
+		assert.Nil(t, json.Unmarshal([]byte(<-dataChan), &response))
+		content += response.Choices[0].Delta.Content
+	}
+	stream.Close()
+	assert.Equal(t, "The", content)
+	// 关闭时等待上游结束，断开后生成的 tokens 也计入原请求
+	assert.Equal(t, 25, firstUsage.PromptTokens)
+	assert.Equal(t, 15, firstUsage.CompletionTokens)
+
+	// 带上最后收到的事件 id 重连，从缓存继续返回，不再请求上游
+	headers := test.RequestJSONConfig()
+	headers["Last-Event-ID"] = "2"
+	context, _ = test.GetContext("POST", "/v1/chat/completions", headers, nil)
+	chatProvider = getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+	stream, errWithCode = chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "true"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 3, stream.(requester.StreamEventIdInterface).FirstEventId())
+
+	var finishReason any
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			content += choice.Delta.Content
+			if choice.FinishReason != nil {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+	assert.Equal(t, "The quick brown fox", content)
+	assert.Equal(t, types.FinishReasonStop, finishReason)
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, 0, usage.TotalTokens)
+
+	// 请求内容不同时不使用缓存
+	request := test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "true")
+	request.Messages[1].Content = "Hello again!"
+	context, _ = test.GetContext("POST", "/v1/chat/completions", headers, nil)
+	chatProvider = getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode = chatProvider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 1, stream.(requester.StreamEventIdInterface).FirstEventId())
+	readChatStream(t, stream)
+	assert.Equal(t, 2, requests)
+}
+
+func TestChatCompletionsStreamResumeLimit(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	var requests int
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		handleClaudeStreamEndpoint(nil, resumeStreamEvents)(w, r)
+	})
+
+	channel := getClaudeChannel(url)
+	channel.Id = 7802
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"stream_resume_ttl": "60", "stream_resume_max_bytes": "300"},
+	})
+
+	// 超出缓存上限后客户端仍能读到完整的流
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "true"))
+	assert.Nil(t, errWithCode)
+
+	var content string
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			content += choice.Delta.Content
+		}
+	}
+	assert.Equal(t, "The quick brown fox", content)
+
+	// 不再支持续传，重连时重新请求上游
+	headers := test.RequestJSONConfig()
+	headers["Last-Event-ID"] = "2"
+	context, _ = test.GetContext("POST", "/v1/chat/completions", headers, nil)
+	chatProvider = getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode = chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "true"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 1, stream.(requester.StreamEventIdInterface).FirstEventId())
+	readChatStream(t, stream)
+	assert.Equal(t, 2, requests)
+}
+
+func TestChatCompletionsStreamResumeCancel(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	defer teardown()
+
+	// 上游发送两条数据后一直不结束
+	canceled := make(chan struct{})
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range resumeStreamEvents[:2] {
+			fmt.Fprint(w, event+"\n\n")
+		}
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	channel := getClaudeChannel(url)
+	channel.Id = 7803
+	setClaudeChannelPlugin(&channel, model.PluginType{
+		"anthropic": {"stream_resume_ttl": "1"},
+	})
+
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "true"))
+	assert.Nil(t, errWithCode)
+
+	dataChan, _ := stream.Recv()
+	<-dataChan
+	stream.Close()
+
+	// 客户端断开后续传窗口内没有重连，取消上游请求
+	select {
+	case <-canceled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("upstream request was not canceled after the resume window")
+	}
+}
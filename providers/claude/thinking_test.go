@@ -0,0 +1,201 @@
+package claude_test
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/common/test"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatCompletionsThinking(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-7-sonnet-20250219","content":[{"type":"thinking","thinking":"The user says hello, I should greet back.","signature":"EqQBCgIYAhIM1gbcDa9GJwZA2b3hGgxBdjrkzLoky3dl1pkiMOYds"},{"type":"text","text":"Hello!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":30}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	chatRequest := test.GetChatCompletionRequest("default", "claude-3-7-sonnet-20250219", "false")
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hello!", openaiResponse.Choices[0].Message.Content)
+	assert.Equal(t, "The user says hello, I should greet back.", openaiResponse.Choices[0].Message.ReasoningContent)
+}
+
+func TestChatCompletionsStreamThinking(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-7-sonnet-20250219","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"The user says hello, "}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"I should greet back."}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"EqQBCgIYAhIM1gbcDa9GJwZA2b3hGgxBdjrkzLoky3dl1pkiMOYds"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hello!"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":1}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":30}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	chatRequest := test.GetChatCompletionRequest("default", "claude-3-7-sonnet-20250219", "true")
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	var content, reasoningContent string
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			content += choice.Delta.Content
+			reasoningContent += choice.Delta.ReasoningContent
+		}
+	}
+
+	assert.Equal(t, "Hello!", content)
+	assert.Equal(t, "The user says hello, I should greet back.", reasoningContent)
+}
+
+func TestChatCompletionsReasoningTokens(t *testing.T) {
+	common.ApproximateTokenEnabled = true
+	defer func() { common.ApproximateTokenEnabled = false }()
+
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-7-sonnet-20250219","content":[{"type":"thinking","thinking":"The user says hello, I should greet back.","signature":"EqQBCgIYAhIM1gbcDa9GJwZA2b3hGgxBdjrkzLoky3dl1pkiMOYds"},{"type":"text","text":"Hello!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":30}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-7-sonnet-20250219", "false"))
+	assert.Nil(t, errWithCode)
+
+	// 思考内容 41 个字符，按近似计算为 15 个 tokens，其余为回答
+	assert.Equal(t, 30, openaiResponse.Usage.CompletionTokens)
+	assert.Equal(t, 15, openaiResponse.Usage.CompletionTokensDetails.ReasoningTokens)
+	assert.Equal(t, 15, usage.CompletionTokensDetails.ReasoningTokens)
+
+	// 没有思考内容时为 0
+	response = `{"id":"msg_02","type":"message","role":"assistant","model":"claude-3-7-sonnet-20250219","content":[{"type":"text","text":"Hello!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+	openaiResponse, errWithCode = chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-7-sonnet-20250219", "false"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 0, openaiResponse.Usage.CompletionTokensDetails.ReasoningTokens)
+}
+
+func TestChatCompletionsStreamReasoningTokens(t *testing.T) {
+	common.ApproximateTokenEnabled = true
+	defer func() { common.ApproximateTokenEnabled = false }()
+
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-7-sonnet-20250219","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"The user says hello, "}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"I should greet back."}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hello!"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":1}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":30}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	chatRequest := test.GetChatCompletionRequest("default", "claude-3-7-sonnet-20250219", "true")
+	chatRequest.StreamOptions = &types.ChatCompletionStreamOptions{IncludeUsage: true}
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	var streamUsage *types.Usage
+	for _, response := range readChatStream(t, stream) {
+		if response.Usage != nil {
+			streamUsage = response.Usage
+		}
+	}
+
+	assert.NotNil(t, streamUsage)
+	assert.Equal(t, 30, streamUsage.CompletionTokens)
+	assert.Equal(t, 15, streamUsage.CompletionTokensDetails.ReasoningTokens)
+	assert.Equal(t, 15, usage.CompletionTokensDetails.ReasoningTokens)
+}
+
+func TestChatCompletionsThinkingBudget(t *testing.T) {
+	tests := []struct {
+		name           string
+		params         string
+		budgetTokens   float64
+		maxTokens      float64
+		errCode        string
+		expectThinking bool
+	}{
+		{"effort low", `"reasoning_effort":"low"`, 1024, 1024 + 4096, "", true},
+		{"effort high with max_tokens", `"reasoning_effort":"high","max_tokens":32000`, 24576, 32000, "", true},
+		{"explicit budget", `"thinking":{"type":"enabled","budget_tokens":2048}`, 2048, 2048 + 4096, "", true},
+		{"disabled", `"thinking":{"type":"disabled"}`, 0, 4096, "", false},
+		{"max_tokens not greater than budget", `"reasoning_effort":"medium","max_tokens":8192`, 0, 0, "invalid_thinking", false},
+		{"budget too small", `"thinking":{"type":"enabled","budget_tokens":512}`, 0, 0, "invalid_thinking", false},
+		{"unknown effort", `"reasoning_effort":"extreme"`, 0, 0, "invalid_thinking", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-7-sonnet-20250219","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-7-sonnet-20250219","messages":[{"role":"user","content":"Hello!"}],` + tt.params + `}`)
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+			if tt.errCode != "" {
+				assert.NotNil(t, errWithCode)
+				assert.Equal(t, tt.errCode, errWithCode.Code)
+				assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+				assert.Empty(t, requestBody)
+				return
+			}
+
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, tt.maxTokens, requestBody["max_tokens"])
+			if !tt.expectThinking {
+				assert.NotContains(t, requestBody, "thinking")
+				return
+			}
+			assert.Equal(t, map[string]any{"type": "enabled", "budget_tokens": tt.budgetTokens}, requestBody["thinking"])
+		})
+	}
+}
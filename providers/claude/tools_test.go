@@ -0,0 +1,586 @@
+package claude_test
+
+import (
+	"one-api/common/test"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatCompletionsTools(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01Aq9w938a90dw8q","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"<thinking>I need to use get_current_weather.</thinking>"},{"type":"tool_use","id":"toolu_01A09q90qw90lq917835lq9","name":"get_current_weather","input":{"location":"Boston, MA","unit":"celsius"}}],"stop_reason":"tool_use","usage":{"input_tokens":390,"output_tokens":58}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	chatRequest := test.GetChatCompletionRequest("function", "claude-3-opus-20240229", "false")
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+	assert.Nil(t, errWithCode)
+
+	tools, ok := requestBody["tools"].([]any)
+	assert.True(t, ok)
+	assert.Len(t, tools, 1)
+	tool := tools[0].(map[string]any)
+	assert.Equal(t, "get_current_weather", tool["name"])
+	assert.Equal(t, "Get the current weather in a given location", tool["description"])
+	assert.Equal(t, "object", tool["input_schema"].(map[string]any)["type"])
+	assert.Equal(t, map[string]any{"type": "auto"}, requestBody["tool_choice"])
+
+	toolCalls := openaiResponse.Choices[0].Message.ToolCalls
+	assert.Len(t, toolCalls, 1)
+	assert.Equal(t, "toolu_01A09q90qw90lq917835lq9", toolCalls[0].Id)
+	assert.Equal(t, "function", toolCalls[0].Type)
+	assert.Equal(t, "get_current_weather", toolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"location":"Boston, MA","unit":"celsius"}`, toolCalls[0].Function.Arguments)
+	assert.Equal(t, types.FinishReasonToolCalls, openaiResponse.Choices[0].FinishReason)
+	assert.Equal(t, 448, usage.TotalTokens)
+}
+
+func TestChatCompletionsToolResult(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01Aq9w938a90dw8q","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"It is 15 degrees in Boston."}],"stop_reason":"end_turn","usage":{"input_tokens":429,"output_tokens":12}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-opus-20240229",
+		"messages": [
+			{"role": "user", "content": "What is the weather like in Boston?"},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "toolu_01A09q90qw90lq917835lq9", "type": "function", "function": {"name": "get_current_weather", "arguments": "{\"location\":\"Boston, MA\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "toolu_01A09q90qw90lq917835lq9", "content": "15 degrees"}
+		]
+	}`)
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "It is 15 degrees in Boston.", openaiResponse.Choices[0].Message.Content)
+
+	messages := requestBody["messages"].([]any)
+	assert.Len(t, messages, 3)
+
+	assistant := messages[1].(map[string]any)
+	assert.Equal(t, "assistant", assistant["role"])
+	toolUse := assistant["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "tool_use", toolUse["type"])
+	assert.Equal(t, "toolu_01A09q90qw90lq917835lq9", toolUse["id"])
+	assert.Equal(t, "get_current_weather", toolUse["name"])
+	assert.Equal(t, map[string]any{"location": "Boston, MA"}, toolUse["input"])
+
+	toolResultMessage := messages[2].(map[string]any)
+	assert.Equal(t, "user", toolResultMessage["role"])
+	toolResult := toolResultMessage["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "tool_result", toolResult["type"])
+	assert.Equal(t, "toolu_01A09q90qw90lq917835lq9", toolResult["tool_use_id"])
+	assert.Equal(t, "15 degrees", toolResult["content"])
+}
+
+func TestChatCompletionsStreamTools(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_014p7gG3wDgGV9EUtLvnow3U","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":472,"output_tokens":2}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: ping\n" + `data: {"type": "ping"}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Okay, let me check."}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_01T1x1fJ34qAmk2tNTrN7Up6","name":"get_current_weather","input":{}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"location\":"}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":" \"Boston, MA\"}"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":1}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":89}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	chatRequest := test.GetChatCompletionRequest("function", "claude-3-opus-20240229", "true")
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	responses := readChatStream(t, stream)
+
+	var content, arguments, toolId, toolName string
+	var finishReason any
+	for _, response := range responses {
+		for _, choice := range response.Choices {
+			content += choice.Delta.Content
+			if choice.FinishReason != nil {
+				finishReason = choice.FinishReason
+			}
+			for _, toolCall := range choice.Delta.ToolCalls {
+				assert.Equal(t, 0, toolCall.Index)
+				if toolCall.Id != "" {
+					toolId = toolCall.Id
+				}
+				toolName += toolCall.Function.Name
+				arguments += toolCall.Function.Arguments
+			}
+		}
+	}
+
+	assert.Equal(t, "Okay, let me check.", content)
+	assert.Equal(t, "toolu_01T1x1fJ34qAmk2tNTrN7Up6", toolId)
+	assert.Equal(t, "get_current_weather", toolName)
+	assert.JSONEq(t, `{"location":"Boston, MA"}`, arguments)
+	assert.Equal(t, types.FinishReasonToolCalls, finishReason)
+	assert.Equal(t, 472, usage.PromptTokens)
+	assert.Equal(t, 89, usage.CompletionTokens)
+}
+
+func TestChatCompletionsStreamMultipleTools(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	// 两个工具调用的增量交替返回
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":472,"output_tokens":2}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking both cities."}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_01A","name":"get_current_weather","input":{}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_01B","name":"get_current_weather","input":{}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"location\":"}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"location\":"}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":" \"Paris\"}"}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":" \"Boston, MA\"}"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":2}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":1}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":120}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("function", "claude-3-opus-20240229", "true"))
+	assert.Nil(t, errWithCode)
+
+	toolIds := make(map[int]string)
+	arguments := make(map[int]string)
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			for _, toolCall := range choice.Delta.ToolCalls {
+				// 同一个工具调用的每个增量都带有相同的 id
+				if id, ok := toolIds[toolCall.Index]; ok {
+					assert.Equal(t, id, toolCall.Id)
+				}
+				toolIds[toolCall.Index] = toolCall.Id
+				arguments[toolCall.Index] += toolCall.Function.Arguments
+			}
+		}
+	}
+
+	assert.Equal(t, map[int]string{0: "toolu_01A", 1: "toolu_01B"}, toolIds)
+	assert.JSONEq(t, `{"location":"Boston, MA"}`, arguments[0])
+	assert.JSONEq(t, `{"location":"Paris"}`, arguments[1])
+}
+
+func TestChatCompletionsReplayToolConversation(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_03","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Boston is warmer than Paris."}],"stop_reason":"end_turn","usage":{"input_tokens":520,"output_tokens":9}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-opus-20240229",
+		"messages": [
+			{"role": "user", "content": "Is Boston warmer than Paris?"},
+			{"role": "assistant", "content": "", "tool_calls": [
+				{"id": "toolu_01", "type": "function", "function": {"name": "get_current_weather", "arguments": "{\"location\":\"Boston, MA\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "toolu_01", "content": "15 degrees"},
+			{"role": "assistant", "content": [{"type": "text", "text": "Now let me check Paris."}], "tool_calls": [
+				{"id": "toolu_02", "type": "function", "function": {"name": "get_current_weather", "arguments": "{\"location\":\"Paris\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "toolu_02", "content": "9 degrees"}
+		]
+	}`)
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Boston is warmer than Paris.", openaiResponse.Choices[0].Message.Content)
+
+	messages := requestBody["messages"].([]any)
+	assert.Len(t, messages, 5)
+
+	roles := make([]string, 0, len(messages))
+	for _, message := range messages {
+		roles = append(roles, message.(map[string]any)["role"].(string))
+	}
+	assert.Equal(t, []string{"user", "assistant", "user", "assistant", "user"}, roles)
+
+	firstTurn := messages[1].(map[string]any)["content"].([]any)
+	assert.Equal(t, []any{
+		map[string]any{"type": "tool_use", "id": "toolu_01", "name": "get_current_weather", "input": map[string]any{"location": "Boston, MA"}},
+	}, firstTurn)
+
+	secondTurn := messages[3].(map[string]any)["content"].([]any)
+	assert.Equal(t, []any{
+		map[string]any{"type": "text", "text": "Now let me check Paris."},
+		map[string]any{"type": "tool_use", "id": "toolu_02", "name": "get_current_weather", "input": map[string]any{"location": "Paris"}},
+	}, secondTurn)
+
+	toolResult := messages[4].(map[string]any)["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "toolu_02", toolResult["tool_use_id"])
+	assert.Equal(t, "9 degrees", toolResult["content"])
+}
+
+func TestChatCompletionsLegacyFunctions(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"tool_use","id":"toolu_01","name":"get_current_weather","input":{"location":"Boston, MA"}},{"type":"tool_use","id":"toolu_02","name":"get_current_weather","input":{"location":"Paris"}}],"stop_reason":"tool_use","usage":{"input_tokens":429,"output_tokens":40}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-opus-20240229",
+		"messages": [
+			{"role": "user", "content": "What is the weather like in Boston?"},
+			{"role": "assistant", "content": null, "function_call": {"name": "get_current_weather", "arguments": "{\"location\":\"Boston\"}"}},
+			{"role": "function", "name": "get_current_weather", "content": "unknown city"},
+			{"role": "user", "content": "Try Boston, MA."}
+		],
+		"functions": [{"name": "get_current_weather", "description": "Get the current weather", "parameters": {"type": "object", "properties": {"location": {"type": "string"}}}}],
+		"function_call": {"name": "get_current_weather"}
+	}`)
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	tools := requestBody["tools"].([]any)
+	assert.Len(t, tools, 1)
+	assert.Equal(t, "get_current_weather", tools[0].(map[string]any)["name"])
+	assert.Equal(t, map[string]any{"type": "tool", "name": "get_current_weather"}, requestBody["tool_choice"])
+
+	// 工具结果和之后的用户消息合并为一条
+	messages := requestBody["messages"].([]any)
+	assert.Len(t, messages, 3)
+	assert.Len(t, messages[2].(map[string]any)["content"], 2)
+	toolUse := messages[1].(map[string]any)["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "tool_use", toolUse["type"])
+	assert.Equal(t, map[string]any{"location": "Boston"}, toolUse["input"])
+	toolResult := messages[2].(map[string]any)["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "tool_result", toolResult["type"])
+	assert.Equal(t, toolUse["id"], toolResult["tool_use_id"])
+	assert.Equal(t, "unknown city", toolResult["content"])
+
+	choice := openaiResponse.Choices[0]
+	assert.Equal(t, types.FinishReasonFunctionCall, choice.FinishReason)
+	assert.Nil(t, choice.Message.ToolCalls)
+	assert.Equal(t, "get_current_weather", choice.Message.FunctionCall.Name)
+	assert.JSONEq(t, `{"location":"Boston, MA"}`, choice.Message.FunctionCall.Arguments)
+}
+
+func TestChatCompletionsStreamLegacyFunctions(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":472,"output_tokens":2}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_01","name":"get_current_weather","input":{}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"location\": \"Boston, MA\"}"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_02","name":"get_current_weather","input":{}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"location\": \"Paris\"}"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":1}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":89}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	chatRequest := test.GetChatCompletionRequest("tools", "claude-3-opus-20240229", "true")
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	responses := readChatStream(t, stream)
+
+	var name, arguments string
+	var finishReason any
+	for _, response := range responses {
+		for _, choice := range response.Choices {
+			assert.Nil(t, choice.Delta.ToolCalls)
+			if choice.Delta.FunctionCall != nil {
+				name += choice.Delta.FunctionCall.Name
+				arguments += choice.Delta.FunctionCall.Arguments
+			}
+			if choice.FinishReason != nil {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+
+	assert.Equal(t, "get_current_weather", name)
+	assert.JSONEq(t, `{"location":"Boston, MA"}`, arguments)
+	assert.Equal(t, types.FinishReasonFunctionCall, finishReason)
+}
+
+func TestChatCompletionsParallelToolCalls(t *testing.T) {
+	tests := []struct {
+		name       string
+		parallel   string
+		toolChoice string
+		expected   any
+	}{
+		{"unset", "", `"auto"`, map[string]any{"type": "auto"}},
+		{"true", `"parallel_tool_calls": true,`, `"auto"`, map[string]any{"type": "auto"}},
+		{"false", `"parallel_tool_calls": false,`, `"auto"`, map[string]any{"type": "auto", "disable_parallel_tool_use": true}},
+		{"false without tool_choice", `"parallel_tool_calls": false,`, `null`, map[string]any{"type": "auto", "disable_parallel_tool_use": true}},
+		{"false with named tool", `"parallel_tool_calls": false,`, `{"type": "function", "function": {"name": "get_current_weather"}}`, map[string]any{"type": "tool", "name": "get_current_weather", "disable_parallel_tool_use": true}},
+	}
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			chatRequest := getChatRequestFromJSON(`{
+				"model": "claude-3-opus-20240229",
+				"messages": [{"role": "user", "content": "What is the weather like in Boston?"}],
+				` + tt.parallel + `
+				"tools": [{"type": "function", "function": {"name": "get_current_weather", "parameters": {"type": "object"}}}],
+				"tool_choice": ` + tt.toolChoice + `
+			}`)
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, tt.expected, requestBody["tool_choice"])
+		})
+	}
+}
+
+func TestChatCompletionsToolChoice(t *testing.T) {
+	tests := []struct {
+		name       string
+		toolChoice string
+		expected   any
+	}{
+		{"auto", `"auto"`, map[string]any{"type": "auto"}},
+		{"required", `"required"`, map[string]any{"type": "any"}},
+		{"none", `"none"`, nil},
+		{"named", `{"type": "function", "function": {"name": "get_current_weather"}}`, map[string]any{"type": "tool", "name": "get_current_weather"}},
+	}
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			chatRequest := getChatRequestFromJSON(`{
+				"model": "claude-3-opus-20240229",
+				"messages": [{"role": "user", "content": "What is the weather like in Boston?"}],
+				"tools": [{"type": "function", "function": {"name": "get_current_weather", "parameters": {"type": "object"}}}],
+				"tool_choice": ` + tt.toolChoice + `
+			}`)
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			assert.Nil(t, errWithCode)
+			assert.Equal(t, tt.expected, requestBody["tool_choice"])
+		})
+	}
+}
+
+func TestChatCompletionsToolChoiceNone(t *testing.T) {
+	tests := []struct {
+		name               string
+		messages           string
+		tools              string
+		expectedTools      bool
+		expectedToolChoice any
+	}{
+		{
+			"tools",
+			`[{"role": "user", "content": "What is the weather like in Boston?"}]`,
+			`"tools": [{"type": "function", "function": {"name": "get_current_weather", "parameters": {"type": "object"}}}], "tool_choice": "none"`,
+			false, nil,
+		},
+		{
+			"legacy functions",
+			`[{"role": "user", "content": "What is the weather like in Boston?"}]`,
+			`"functions": [{"name": "get_current_weather", "parameters": {"type": "object"}}], "function_call": "none"`,
+			false, nil,
+		},
+		{
+			// 历史消息中有工具调用时必须定义工具
+			"tool history",
+			`[
+				{"role": "user", "content": "What is the weather like in Boston?"},
+				{"role": "assistant", "content": null, "tool_calls": [{"id": "toolu_01", "type": "function", "function": {"name": "get_current_weather", "arguments": "{}"}}]},
+				{"role": "tool", "tool_call_id": "toolu_01", "content": "Sunny"}
+			]`,
+			`"tools": [{"type": "function", "function": {"name": "get_current_weather", "parameters": {"type": "object"}}}], "tool_choice": "none"`,
+			true, map[string]any{"type": "none"},
+		},
+	}
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			requestBody := map[string]any{}
+			server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+			chatRequest := getChatRequestFromJSON(`{
+				"model": "claude-3-opus-20240229",
+				"messages": ` + tt.messages + `,
+				` + tt.tools + `
+			}`)
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+			_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			assert.Nil(t, errWithCode)
+			_, hasTools := requestBody["tools"]
+			assert.Equal(t, tt.expectedTools, hasTools)
+			assert.Equal(t, tt.expectedToolChoice, requestBody["tool_choice"])
+		})
+	}
+}
+
+func TestChatCompletionsGroupToolResults(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_02","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Boston is warmer than Paris."}],"stop_reason":"end_turn","usage":{"input_tokens":520,"output_tokens":9}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-opus-20240229",
+		"messages": [
+			{"role": "user", "content": "Is Boston warmer than Paris?"},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "toolu_01", "type": "function", "function": {"name": "get_current_weather", "arguments": "{\"location\":\"Boston, MA\"}"}},
+				{"id": "toolu_02", "type": "function", "function": {"name": "get_current_weather", "arguments": "{\"location\":\"Paris\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "toolu_01", "content": "15 degrees"},
+			{"role": "tool", "tool_call_id": "toolu_02", "content": "9 degrees"},
+			{"role": "user", "content": "Answer briefly."}
+		]
+	}`)
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	// 两个工具结果和之后的用户消息合并为一条 user 消息，工具结果在前
+	messages := requestBody["messages"].([]any)
+	assert.Len(t, messages, 3)
+	assert.Equal(t, map[string]any{
+		"role": "user",
+		"content": []any{
+			map[string]any{"type": "tool_result", "tool_use_id": "toolu_01", "content": "15 degrees"},
+			map[string]any{"type": "tool_result", "tool_use_id": "toolu_02", "content": "9 degrees"},
+			map[string]any{"type": "text", "text": "Answer briefly."},
+		},
+	}, messages[2])
+}
+
+func TestChatCompletionsToolResultEmptyText(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"id":"msg_02","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"It is 15 degrees in Boston."}],"stop_reason":"end_turn","usage":{"input_tokens":420,"output_tokens":9}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(&requestBody, response))
+
+	chatRequest := getChatRequestFromJSON(`{
+		"model": "claude-3-opus-20240229",
+		"messages": [
+			{"role": "user", "content": "What is the weather like in Boston?"},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "toolu_01", "type": "function", "function": {"name": "get_current_weather", "arguments": "{\"location\":\"Boston, MA\"}"}},
+				{"id": "toolu_02", "type": "function", "function": {"name": "get_alerts", "arguments": "{\"location\":\"Boston, MA\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "toolu_01", "content": [{"type": "text", "text": "15 degrees"}, {"type": "text", "text": ""}]},
+			{"role": "tool", "tool_call_id": "toolu_02", "content": ""},
+			{"role": "user", "content": [{"type": "text", "text": ""}]},
+			{"role": "user", "content": " "}
+		]
+	}`)
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	// 工具结果之后的空文本不生成 text 块，空的工具结果不带 content
+	messages := requestBody["messages"].([]any)
+	assert.Len(t, messages, 3)
+	assert.Equal(t, map[string]any{
+		"role": "user",
+		"content": []any{
+			map[string]any{"type": "tool_result", "tool_use_id": "toolu_01", "content": "15 degrees"},
+			map[string]any{"type": "tool_result", "tool_use_id": "toolu_02"},
+		},
+	}, messages[2])
+}
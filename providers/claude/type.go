@@ -1,17 +1,34 @@
 package claude
 
+import "encoding/json"
+
 type ClaudeError struct {
 	Type    string `json:"type"`
 	Message string `json:"message"`
 }
 
+type ClaudeErrorResponse struct {
+	Type    string      `json:"type"`
+	Error   ClaudeError `json:"error"`
+	Message string      `json:"message,omitempty"` // Bedrock 的错误信息
+}
+
 type ClaudeMetadata struct {
 	UserId string `json:"user_id"`
 }
 
 type ResContent struct {
-	Text string `json:"text"`
-	Type string `json:"type"`
+	Text      string            `json:"text"`
+	Type      string            `json:"type"`
+	Thinking  string            `json:"thinking,omitempty"`
+	Id        string            `json:"id,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Input     json.RawMessage   `json:"input,omitempty"`
+	Citations []json.RawMessage `json:"citations,omitempty"` // 文本块引用的文档位置，原样返回
+}
+
+type Citations struct {
+	Enabled bool `json:"enabled"`
 }
 
 type ContentSource struct {
@@ -20,10 +37,21 @@ type ContentSource struct {
 	Data      string `json:"data"`
 }
 
+type CacheControl struct {
+	Type string `json:"type"`
+}
+
 type MessageContent struct {
-	Type   string         `json:"type"`
-	Text   string         `json:"text,omitempty"`
-	Source *ContentSource `json:"source,omitempty"`
+	Type         string          `json:"type"`
+	Text         string          `json:"text,omitempty"`
+	Source       *ContentSource  `json:"source,omitempty"`
+	Id           string          `json:"id,omitempty"`
+	Name         string          `json:"name,omitempty"`
+	Input        json.RawMessage `json:"input,omitempty"`
+	ToolUseId    string          `json:"tool_use_id,omitempty"`
+	Content      any             `json:"content,omitempty"`
+	CacheControl *CacheControl   `json:"cache_control,omitempty"`
+	Citations    *Citations      `json:"citations,omitempty"`
 }
 
 type Message struct {
@@ -31,23 +59,110 @@ type Message struct {
 	Content []MessageContent `json:"content"`
 }
 
+type Tools struct {
+	Type        string `json:"type,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema,omitempty"`
+
+	// computer use 的 computer 工具的屏幕参数
+	DisplayWidthPx  int  `json:"display_width_px,omitempty"`
+	DisplayHeightPx int  `json:"display_height_px,omitempty"`
+	DisplayNumber   *int `json:"display_number,omitempty"`
+}
+
+type Thinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type ToolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
 type ClaudeRequest struct {
-	Model         string    `json:"model"`
-	System        string    `json:"system,omitempty"`
-	Messages      []Message `json:"messages"`
-	MaxTokens     int       `json:"max_tokens"`
-	StopSequences []string  `json:"stop_sequences,omitempty"`
-	Temperature   float64   `json:"temperature,omitempty"`
-	TopP          float64   `json:"top_p,omitempty"`
-	TopK          int       `json:"top_k,omitempty"`
-	//ClaudeMetadata    `json:"metadata,omitempty"`
-	Stream bool `json:"stream,omitempty"`
+	Model         string          `json:"model,omitempty"`
+	System        any             `json:"system,omitempty"` // string 或 []MessageContent
+	Messages      []Message       `json:"messages"`
+	MaxTokens     int             `json:"max_tokens"`
+	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	TopK          int             `json:"top_k,omitempty"`
+	Tools         []Tools         `json:"tools,omitempty"`
+	ToolChoice    *ToolChoice     `json:"tool_choice,omitempty"`
+	Thinking      *Thinking       `json:"thinking,omitempty"`
+	Metadata      *ClaudeMetadata `json:"metadata,omitempty"`
+	ServiceTier   string          `json:"service_tier,omitempty"`
+	Seed          *int            `json:"seed,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+
+	// Bedrock 在请求体中指定版本和 beta
+	AnthropicVersion string   `json:"anthropic_version,omitempty"`
+	AnthropicBeta    []string `json:"anthropic_beta,omitempty"`
+
+	// 请求内容需要开启的 beta，如 computer use
+	betas []string
+}
+
+// 将 system 转换为带 cache_control 的内容块
+func (r *ClaudeRequest) cacheSystem() {
+	system, ok := r.System.(string)
+	if !ok || system == "" {
+		return
+	}
+
+	r.System = []MessageContent{
+		{
+			Type:         "text",
+			Text:         system,
+			CacheControl: &CacheControl{Type: "ephemeral"},
+		},
+	}
+}
+
+// 在最后一条消息之前的历史消息末尾设置缓存断点，缓存稳定的对话前缀
+func (r *ClaudeRequest) cacheHistory() {
+	if len(r.Messages) < 2 {
+		return
+	}
+
+	history := r.Messages[len(r.Messages)-2]
+	if len(history.Content) == 0 {
+		return
+	}
+	history.Content[len(history.Content)-1].CacheControl = &CacheControl{Type: "ephemeral"}
 }
 
 type Usage struct {
-	InputTokens  int `json:"input_tokens,omitempty"`
-	OutputTokens int `json:"output_tokens,omitempty"`
+	InputTokens              int    `json:"input_tokens,omitempty"`
+	OutputTokens             int    `json:"output_tokens,omitempty"`
+	CacheCreationInputTokens int    `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int    `json:"cache_read_input_tokens,omitempty"`
+	ServiceTier              string `json:"service_tier,omitempty"`
+}
+
+// input_tokens 不包含缓存的 tokens，这里需要加上
+func (u *Usage) GetPromptTokens() int {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+}
+
+type ClaudeCountTokensRequest struct {
+	Model      string      `json:"model"`
+	System     any         `json:"system,omitempty"`
+	Messages   []Message   `json:"messages"`
+	Tools      []Tools     `json:"tools,omitempty"`
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+	Thinking   *Thinking   `json:"thinking,omitempty"`
 }
+
+type ClaudeCountTokensResponse struct {
+	InputTokens int         `json:"input_tokens"`
+	Error       ClaudeError `json:"error,omitempty"`
+}
+
 type ClaudeResponse struct {
 	Id           string       `json:"id"`
 	Type         string       `json:"type"`
@@ -60,18 +175,106 @@ type ClaudeResponse struct {
 	Error        ClaudeError  `json:"error,omitempty"`
 }
 
+type FinishDetails struct {
+	Type string `json:"type"`
+	Stop string `json:"stop,omitempty"`
+}
+
 type Delta struct {
-	Type         string `json:"type,omitempty"`
-	Text         string `json:"text,omitempty"`
-	StopReason   string `json:"stop_reason,omitempty"`
-	StopSequence string `json:"stop_sequence,omitempty"`
+	Type         string          `json:"type,omitempty"`
+	Text         string          `json:"text,omitempty"`
+	Thinking     string          `json:"thinking,omitempty"`
+	PartialJson  string          `json:"partial_json,omitempty"`
+	StopReason   string          `json:"stop_reason,omitempty"`
+	StopSequence string          `json:"stop_sequence,omitempty"`
+	Citation     json.RawMessage `json:"citation,omitempty"`
 }
 
 type ClaudeStreamResponse struct {
-	Type    string         `json:"type"`
-	Message ClaudeResponse `json:"message,omitempty"`
-	Index   int            `json:"index,omitempty"`
-	Delta   Delta          `json:"delta,omitempty"`
-	Usage   Usage          `json:"usage,omitempty"`
-	Error   ClaudeError    `json:"error,omitempty"`
+	Type         string         `json:"type"`
+	Message      ClaudeResponse `json:"message,omitempty"`
+	Index        int            `json:"index,omitempty"`
+	ContentBlock ResContent     `json:"content_block,omitempty"`
+	Delta        Delta          `json:"delta,omitempty"`
+	Usage        Usage          `json:"usage,omitempty"`
+	Error        ClaudeError    `json:"error,omitempty"`
+}
+
+type ClaudeBatchRequestItem struct {
+	CustomId string         `json:"custom_id"`
+	Params   *ClaudeRequest `json:"params"`
+}
+
+type ClaudeBatchRequest struct {
+	Requests []ClaudeBatchRequestItem `json:"requests"`
+}
+
+type ClaudeBatchRequestCounts struct {
+	Processing int `json:"processing"`
+	Succeeded  int `json:"succeeded"`
+	Errored    int `json:"errored"`
+	Canceled   int `json:"canceled"`
+	Expired    int `json:"expired"`
+}
+
+type ClaudeBatch struct {
+	Id               string                   `json:"id"`
+	Type             string                   `json:"type"`
+	ProcessingStatus string                   `json:"processing_status"`
+	RequestCounts    ClaudeBatchRequestCounts `json:"request_counts"`
+	CreatedAt        string                   `json:"created_at"`
+	EndedAt          string                   `json:"ended_at,omitempty"`
+	ExpiresAt        string                   `json:"expires_at"`
+	ResultsUrl       string                   `json:"results_url,omitempty"`
+	Error            ClaudeError              `json:"error,omitempty"`
+}
+
+type ClaudeBatchResult struct {
+	CustomId string `json:"custom_id"`
+	Result   struct {
+		Type    string          `json:"type"` // succeeded, errored, canceled, expired
+		Message *ClaudeResponse `json:"message,omitempty"`
+		Error   *struct {
+			Type  string      `json:"type"`
+			Error ClaudeError `json:"error"`
+		} `json:"error,omitempty"`
+	} `json:"result"`
+}
+
+type VoyageEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type VoyageEmbedding struct {
+	Object    string    `json:"object"`
+	Embedding []float64 `json:"embedding"`
+	Index     int       `json:"index"`
+}
+
+type VoyageEmbeddingResponse struct {
+	Object string            `json:"object"`
+	Data   []VoyageEmbedding `json:"data"`
+	Model  string            `json:"model"`
+	Usage  struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+type VoyageError struct {
+	Detail string `json:"detail"`
+}
+
+type ClaudeModel struct {
+	Type        string `json:"type"`
+	Id          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type ClaudeModelsResponse struct {
+	Data    []ClaudeModel `json:"data"`
+	HasMore bool          `json:"has_more"`
+	FirstId string        `json:"first_id"`
+	LastId  string        `json:"last_id"`
 }
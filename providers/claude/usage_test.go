@@ -0,0 +1,289 @@
+package claude_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"one-api/common"
+	"one-api/common/test"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatCompletionsZeroUsageFallback(t *testing.T) {
+	common.ApproximateTokenEnabled = true
+	defer func() { common.ApproximateTokenEnabled = false }()
+
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":0,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello, how are you today?"}]}`)
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	expected := common.CountTokenMessages(chatRequest.Messages, chatRequest.Model)
+	assert.Greater(t, expected, 0)
+	assert.Equal(t, expected, usage.PromptTokens)
+	assert.Equal(t, expected+3, openaiResponse.Usage.TotalTokens)
+}
+
+func TestChatCompletionsStreamZeroUsageFallback(t *testing.T) {
+	common.ApproximateTokenEnabled = true
+	defer func() { common.ApproximateTokenEnabled = false }()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi!"}}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":3}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+
+	tests := []struct {
+		name        string
+		presetUsage int
+	}{
+		{"tokenize request", 0},
+		{"keep relay estimate", 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			usage := &types.Usage{PromptTokens: tt.presetUsage}
+			chatProvider.SetUsage(usage)
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello, how are you today?"}],"stream":true}`)
+			stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+			assert.Nil(t, errWithCode)
+			readChatStream(t, stream)
+
+			expected := tt.presetUsage
+			if expected == 0 {
+				expected = common.CountTokenMessages(chatRequest.Messages, chatRequest.Model)
+			}
+			assert.Greater(t, expected, 0)
+			assert.Equal(t, expected, usage.PromptTokens)
+			assert.Equal(t, expected+3, usage.TotalTokens)
+		})
+	}
+}
+
+func TestChatCompletionsStreamIncludeUsage(t *testing.T) {
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi!"}}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":3}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+
+	tests := []struct {
+		name          string
+		streamOptions string
+		includeUsage  bool
+	}{
+		{"include usage", `,"stream_options":{"include_usage":true}`, true},
+		{"exclude usage", `,"stream_options":{"include_usage":false}`, false},
+		{"no stream options", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, server, teardown := setupClaudeTestServer()
+			context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+			defer teardown()
+
+			server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			chatProvider.SetUsage(&types.Usage{})
+
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"stream":true` + tt.streamOptions + `}`)
+			stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+			assert.Nil(t, errWithCode)
+
+			responses := readChatStream(t, stream)
+			usageChunks := 0
+			for _, response := range responses {
+				if response.Usage != nil {
+					usageChunks++
+				}
+			}
+
+			if !tt.includeUsage {
+				assert.Equal(t, 0, usageChunks)
+				return
+			}
+
+			assert.Equal(t, 1, usageChunks)
+			last := responses[len(responses)-1]
+			assert.Empty(t, last.Choices)
+			assert.Equal(t, 12, last.Usage.PromptTokens)
+			assert.Equal(t, 3, last.Usage.CompletionTokens)
+			assert.Equal(t, 15, last.Usage.TotalTokens)
+		})
+	}
+}
+
+func TestChatCompletionsPromptEstimate(t *testing.T) {
+	common.ApproximateTokenEnabled = true
+	defer func() { common.ApproximateTokenEnabled = false }()
+
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	usage := &types.Usage{PromptTokens: 1}
+	var provisionalTokens int
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":1500,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		provisionalTokens = usage.PromptTokens
+		handleClaudeEndpoint(nil, response)(w, r)
+	})
+
+	textTokens := common.CountTokenText("What is in the picture?", "claude-3-5-sonnet-20241022")
+	tests := []struct {
+		name     string
+		image    string
+		expected int
+	}{
+		// 每条消息和回复各额外计 3 tokens
+		{"text only", "", textTokens + 6},
+		// 1000x1000 的图片约 1000*1000/750 tokens
+		{"text and image", getGradientPNG(1000, 1000), textTokens + 1334 + 6},
+		// 长边超过 1568 时先缩小，再限制在约 115 万像素内
+		{"large image", getGradientPNG(3000, 1500), textTokens + 1534 + 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := `"What is in the picture?"`
+			if tt.image != "" {
+				content = `[{"type":"text","text":"What is in the picture?"},{"type":"image_url","image_url":{"url":"` + tt.image + `"}}]`
+			}
+			chatRequest := getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":` + content + `}]}`)
+
+			channel := getClaudeChannel(url)
+			chatProvider := getChatProvider(&channel, context)
+			usage.PromptTokens = 1
+			chatProvider.SetUsage(usage)
+			openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+			assert.Nil(t, errWithCode)
+
+			// 发送前为预估值，响应后以实际用量为准
+			assert.Equal(t, tt.expected, provisionalTokens)
+			assert.Equal(t, 1500, openaiResponse.Usage.PromptTokens)
+			assert.Equal(t, 1500, usage.PromptTokens)
+		})
+	}
+}
+
+func TestChatCompletionsPromptEstimateTools(t *testing.T) {
+	common.ApproximateTokenEnabled = true
+	defer func() { common.ApproximateTokenEnabled = false }()
+
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	usage := &types.Usage{}
+	var provisionalTokens int
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":1500,"output_tokens":3}}`
+	server.RegisterHandler("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		provisionalTokens = usage.PromptTokens
+		handleClaudeEndpoint(nil, response)(w, r)
+	})
+
+	estimate := func(tools string) int {
+		chatRequest := getChatRequestFromJSON(`{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":"What is the weather in Paris?"}]` + tools + `}`)
+		channel := getClaudeChannel(url)
+		chatProvider := getChatProvider(&channel, context)
+		chatProvider.SetUsage(usage)
+		_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+		assert.Nil(t, errWithCode)
+		return provisionalTokens
+	}
+
+	withoutTools := estimate("")
+	withTools := estimate(`,"tools":[{"type":"function","function":{"name":"get_weather","description":"Get the weather","parameters":{"type":"object","properties":{"city":{"type":"string"}}}}}]`)
+
+	// 工具使用说明加上序列化后的工具定义
+	toolTokens := common.CountTokenText(`{"name":"get_weather","description":"Get the weather","input_schema":{"properties":{"city":{"type":"string"}},"type":"object"}}`, "claude-3-5-sonnet-20241022")
+	assert.Equal(t, withoutTools+346+toolTokens, withTools)
+}
+
+func TestChatCompletionsUsageDetails(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","usage":{"input_tokens":20,"cache_creation_input_tokens":100,"cache_read_input_tokens":1000,"output_tokens":5}}`
+	server.RegisterHandler("/v1/messages", handleClaudeEndpoint(nil, response))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", "claude-3-5-sonnet-20241022", "false"))
+	assert.Nil(t, errWithCode)
+
+	data, _ := json.Marshal(openaiResponse)
+	var body struct {
+		Usage map[string]any `json:"usage"`
+	}
+	assert.Nil(t, json.Unmarshal(data, &body))
+
+	assert.Equal(t, float64(1120), body.Usage["prompt_tokens"])
+	assert.Equal(t, float64(5), body.Usage["completion_tokens"])
+	assert.Equal(t, map[string]any{"cached_tokens": float64(1000), "cache_creation_tokens": float64(100)}, body.Usage["prompt_tokens_details"])
+	assert.Equal(t, map[string]any{"reasoning_tokens": float64(0)}, body.Usage["completion_tokens_details"])
+}
+
+func TestChatCompletionsStreamCacheUsage(t *testing.T) {
+	url, server, teardown := setupClaudeTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-opus-20240229","content":[],"stop_reason":null,"usage":{"input_tokens":12,"cache_creation_input_tokens":1024,"cache_read_input_tokens":2048,"output_tokens":1}}}`,
+		"event: content_block_start\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi!"}}`,
+		"event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":3}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler("/v1/messages", handleClaudeStreamEndpoint(nil, events))
+
+	channel := getClaudeChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{}
+	chatProvider.SetUsage(usage)
+
+	chatRequest := getChatRequestFromJSON(`{"model":"claude-3-opus-20240229","messages":[{"role":"user","content":"Hello!"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+	readChatStream(t, stream)
+
+	assert.Equal(t, 3084, usage.PromptTokens)
+	assert.Equal(t, 1024, usage.CacheCreationInputTokens)
+	assert.Equal(t, 2048, usage.CacheReadInputTokens)
+	assert.Equal(t, 12, usage.GetUncachedPromptTokens())
+	assert.Equal(t, 3, usage.CompletionTokens)
+	assert.Equal(t, 3087, usage.TotalTokens)
+}
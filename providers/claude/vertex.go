@@ -0,0 +1,249 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/common/requester"
+	"one-api/types"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	platformVertex         = "vertex"
+	vertexAnthropicVersion = "vertex-2023-10-16"
+	defaultVertexRegion    = "us-east5"
+	vertexTokenScope       = "https://www.googleapis.com/auth/cloud-platform"
+	defaultVertexTokenURI  = "https://oauth2.googleapis.com/token"
+)
+
+// 服务账号换取的访问令牌，按 client_email 缓存
+// vertexTokensLock 只保护两个 map，换取令牌时只持有对应服务账号的锁，不阻塞其他渠道
+var (
+	vertexTokensLock   sync.Mutex
+	vertexTokens       = make(map[string]vertexTokenData)
+	vertexAccountLocks = make(map[string]*sync.Mutex)
+)
+
+type vertexTokenData struct {
+	Token      string
+	ExpiryTime time.Time
+}
+
+type vertexServiceAccount struct {
+	ProjectId    string `json:"project_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+	PrivateKeyId string `json:"private_key_id"`
+}
+
+type vertexTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// 渠道插件 platform 为 vertex 时通过 Google Vertex AI 调用 Claude
+func (p *ClaudeProvider) isVertex() bool {
+	return p.getPluginParam("anthropic", "platform") == platformVertex
+}
+
+func (p *ClaudeProvider) getVertexRegion() string {
+	region := p.getPluginParam("anthropic", "region")
+	if region == "" {
+		return defaultVertexRegion
+	}
+	return region
+}
+
+// 渠道密钥为服务账号 JSON 时解析，否则视为访问令牌
+func (p *ClaudeProvider) getVertexServiceAccount() (*vertexServiceAccount, *types.OpenAIErrorWithStatusCode) {
+	key := strings.TrimSpace(p.Channel.Key)
+	if !strings.HasPrefix(key, "{") {
+		return nil, nil
+	}
+
+	account := &vertexServiceAccount{}
+	if err := json.Unmarshal([]byte(key), account); err != nil || account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, common.StringErrorWrapper("invalid vertex service account key", "invalid_claude_config", http.StatusInternalServerError)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = defaultVertexTokenURI
+	}
+	return account, nil
+}
+
+// 项目 ID 优先使用渠道配置，其次使用服务账号中的项目
+func (p *ClaudeProvider) getVertexURL(modelName string, stream bool, account *vertexServiceAccount) (string, *types.OpenAIErrorWithStatusCode) {
+	projectId := p.getPluginParam("anthropic", "project_id")
+	if projectId == "" && account != nil {
+		projectId = account.ProjectId
+	}
+	if projectId == "" {
+		return "", common.StringErrorWrapper("vertex project_id is not configured", "invalid_claude_config", http.StatusInternalServerError)
+	}
+
+	region := p.getVertexRegion()
+	baseURL := p.Channel.GetBaseURL()
+	if baseURL == "" && region == "global" {
+		baseURL = "https://aiplatform.googleapis.com"
+	} else if baseURL == "" {
+		baseURL = fmt.Sprintf("https://%s-aiplatform.googleapis.com", region)
+	}
+
+	action := "rawPredict"
+	if stream {
+		action = "streamRawPredict"
+	}
+
+	return fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/anthropic/models/%s:%s", strings.TrimSuffix(baseURL, "/"), url.PathEscape(projectId), url.PathEscape(region), url.PathEscape(modelName), action), nil
+}
+
+// 获取访问令牌，服务账号换取的令牌在过期前复用
+func (p *ClaudeProvider) getVertexAccessToken(account *vertexServiceAccount) (string, *types.OpenAIErrorWithStatusCode) {
+	if account == nil {
+		return strings.TrimSpace(p.Channel.Key), nil
+	}
+
+	// 同一服务账号同时只换取一次令牌，其他请求等待后复用
+	accountLock := getVertexAccountLock(account.ClientEmail)
+	accountLock.Lock()
+	defer accountLock.Unlock()
+
+	if token := getCachedVertexToken(account.ClientEmail); token != "" {
+		return token, nil
+	}
+
+	tokenData, err := p.requestVertexToken(account)
+	if err != nil {
+		return "", common.ErrorWrapper(err, "vertex_token_failed", http.StatusUnauthorized)
+	}
+
+	vertexTokensLock.Lock()
+	vertexTokens[account.ClientEmail] = *tokenData
+	vertexTokensLock.Unlock()
+
+	return tokenData.Token, nil
+}
+
+func getVertexAccountLock(clientEmail string) *sync.Mutex {
+	vertexTokensLock.Lock()
+	defer vertexTokensLock.Unlock()
+
+	lock, ok := vertexAccountLocks[clientEmail]
+	if !ok {
+		lock = &sync.Mutex{}
+		vertexAccountLocks[clientEmail] = lock
+	}
+	return lock
+}
+
+// 返回未过期的令牌，没有时返回空字符串
+func getCachedVertexToken(clientEmail string) string {
+	vertexTokensLock.Lock()
+	defer vertexTokensLock.Unlock()
+
+	if tokenData, ok := vertexTokens[clientEmail]; ok && time.Now().Before(tokenData.ExpiryTime) {
+		return tokenData.Token
+	}
+	return ""
+}
+
+// 用服务账号签名的 JWT 换取访问令牌
+func (p *ClaudeProvider) requestVertexToken(account *vertexServiceAccount) (*vertexTokenData, error) {
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   account.ClientEmail,
+		"scope": vertexTokenScope,
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if account.PrivateKeyId != "" {
+		token.Header["kid"] = account.PrivateKeyId
+	}
+	assertion, err := token.SignedString(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := p.Requester.NewRequest(http.MethodPost, account.TokenURI, p.Requester.WithBody(strings.NewReader(form.Encode())), p.Requester.WithHeader(map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := requester.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	tokenResponse := &vertexTokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(tokenResponse); err != nil {
+		return nil, err
+	}
+	if tokenResponse.AccessToken == "" {
+		return nil, fmt.Errorf("vertex token request failed: %s %s", tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+
+	// 提前一分钟过期，避免使用时刚好失效
+	return &vertexTokenData{
+		Token:      tokenResponse.AccessToken,
+		ExpiryTime: now.Add(time.Duration(tokenResponse.ExpiresIn)*time.Second - time.Minute),
+	}, nil
+}
+
+// Vertex 的请求体不包含 model，版本和 beta 放在请求体中，使用 Bearer 令牌认证
+func (p *ClaudeProvider) newVertexRequest(claudeRequest *ClaudeRequest) (*http.Request, *types.OpenAIErrorWithStatusCode) {
+	account, errWithCode := p.getVertexServiceAccount()
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	fullRequestURL, errWithCode := p.getVertexURL(claudeRequest.Model, claudeRequest.Stream, account)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	accessToken, errWithCode := p.getVertexAccessToken(account)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	vertexRequest := *claudeRequest
+	vertexRequest.Model = ""
+	vertexRequest.AnthropicVersion = vertexAnthropicVersion
+	vertexRequest.AnthropicBeta = p.getAnthropicBetas(claudeRequest.betas...)
+
+	headers := p.GetRequestHeaders()
+	if claudeRequest.Stream {
+		headers["Accept"] = "text/event-stream"
+	}
+	headers["Authorization"] = "Bearer " + accessToken
+
+	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(vertexRequest), p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+
+	return req, nil
+}
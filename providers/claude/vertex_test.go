@@ -0,0 +1,237 @@
+package claude_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/types"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	vertexModel = "claude-3-5-sonnet-v2@20241022"
+	vertexPath  = "/v1/projects/my-project/locations/us-east5/publishers/anthropic/models/" + vertexModel
+)
+
+func getVertexChannel(baseUrl, key string, plugin map[string]interface{}) model.Channel {
+	channel := getClaudeChannel(baseUrl)
+	channel.Key = key
+	params := map[string]interface{}{"platform": "vertex"}
+	for name, value := range plugin {
+		params[name] = value
+	}
+	setClaudeChannelPlugin(&channel, model.PluginType{"anthropic": params})
+	return channel
+}
+
+func TestVertexChatCompletions(t *testing.T) {
+	url, server, teardown := setupBedrockTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	var authorization string
+	requestBody := map[string]any{}
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler(vertexPath+":rawPredict", func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		handleClaudeEndpoint(&requestBody, response)(w, r)
+	})
+
+	channel := getVertexChannel(url, "ya29.access-token", map[string]interface{}{"project_id": "my-project"})
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", vertexModel, "false"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hi!", openaiResponse.Choices[0].Message.Content)
+
+	assert.Equal(t, "Bearer ya29.access-token", authorization)
+	assert.Equal(t, "vertex-2023-10-16", requestBody["anthropic_version"])
+	assert.NotContains(t, requestBody, "model")
+	assert.Equal(t, "You are a helpful assistant.", requestBody["system"])
+}
+
+func TestVertexChatCompletionsStream(t *testing.T) {
+	url, server, teardown := setupBedrockTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	events := []string{
+		"event: message_start\n" + `data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[],"stop_reason":null,"usage":{"input_tokens":25,"output_tokens":1}}}`,
+		"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello!"}}`,
+		"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":15}}`,
+		"event: message_stop\n" + `data: {"type":"message_stop"}`,
+	}
+	server.RegisterHandler(vertexPath+":streamRawPredict", handleClaudeStreamEndpoint(&requestBody, events))
+
+	channel := getVertexChannel(url, "ya29.access-token", map[string]interface{}{"project_id": "my-project"})
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(test.GetChatCompletionRequest("default", vertexModel, "true"))
+	assert.Nil(t, errWithCode)
+
+	var content string
+	for _, response := range readChatStream(t, stream) {
+		for _, choice := range response.Choices {
+			content += choice.Delta.Content
+		}
+	}
+	assert.Equal(t, "Hello!", content)
+	assert.Equal(t, true, requestBody["stream"])
+	assert.NotContains(t, requestBody, "model")
+}
+
+func TestVertexChatCompletionsServiceAccount(t *testing.T) {
+	url, server, teardown := setupBedrockTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	// 校验 JWT 后返回访问令牌
+	var tokenRequests int32
+	server.RegisterHandler("/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		assert.Nil(t, r.ParseForm())
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(r.PostForm.Get("assertion"), claims, func(token *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "claude@my-project.iam.gserviceaccount.com", claims["iss"])
+		assert.Equal(t, "https://www.googleapis.com/auth/cloud-platform", claims["scope"])
+
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"ya29.service-account-token","expires_in":3599,"token_type":"Bearer"}`)
+	})
+
+	var authorization string
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler(vertexPath+":rawPredict", func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		handleClaudeEndpoint(nil, response)(w, r)
+	})
+
+	serviceAccount, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "my-project",
+		"private_key":  string(privateKeyPEM),
+		"client_email": "claude@my-project.iam.gserviceaccount.com",
+		"token_uri":    url + "/token",
+	})
+	channel := getVertexChannel(url, string(serviceAccount), nil)
+
+	// 令牌在过期前复用
+	for i := 0; i < 2; i++ {
+		chatProvider := getChatProvider(&channel, context)
+		chatProvider.SetUsage(&types.Usage{})
+		_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", vertexModel, "false"))
+		assert.Nil(t, errWithCode)
+		assert.Equal(t, "Bearer ya29.service-account-token", authorization)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenRequests))
+}
+
+func TestVertexTokenExchangeNotBlockingOtherAccounts(t *testing.T) {
+	url, server, teardown := setupBedrockTestServer()
+	defer teardown()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	// slow 账号的令牌接口一直等待，直到测试结束
+	waiting := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	server.RegisterHandler("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		claims := jwt.MapClaims{}
+		jwt.ParseWithClaims(r.PostForm.Get("assertion"), claims, func(token *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		})
+		if claims["iss"] == "slow@my-project.iam.gserviceaccount.com" {
+			close(waiting)
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"ya29.service-account-token","expires_in":3599,"token_type":"Bearer"}`)
+	})
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Hi!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":12,"output_tokens":3}}`
+	server.RegisterHandler(vertexPath+":rawPredict", handleClaudeEndpoint(nil, response))
+
+	send := func(clientEmail string) *types.OpenAIErrorWithStatusCode {
+		serviceAccount, _ := json.Marshal(map[string]string{
+			"type":         "service_account",
+			"project_id":   "my-project",
+			"private_key":  string(privateKeyPEM),
+			"client_email": clientEmail,
+			"token_uri":    url + "/token",
+		})
+		channel := getVertexChannel(url, string(serviceAccount), nil)
+		context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+		chatProvider := getChatProvider(&channel, context)
+		chatProvider.SetUsage(&types.Usage{})
+		_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", vertexModel, "false"))
+		return errWithCode
+	}
+
+	go send("slow@my-project.iam.gserviceaccount.com")
+	<-waiting
+
+	// 其他服务账号不等待 slow 账号换取令牌
+	done := make(chan *types.OpenAIErrorWithStatusCode)
+	go func() {
+		done <- send("fast@my-project.iam.gserviceaccount.com")
+	}()
+	select {
+	case errWithCode := <-done:
+		assert.Nil(t, errWithCode)
+	case <-time.After(3 * time.Second):
+		t.Fatal("token exchange of one account blocked another account")
+	}
+}
+
+func TestVertexChatCompletionsError(t *testing.T) {
+	url, server, teardown := setupBedrockTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	server.RegisterHandler(vertexPath+":rawPredict", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, `{"error":{"code":403,"message":"Permission denied on resource project my-project.","status":"PERMISSION_DENIED"}}`)
+	})
+
+	channel := getVertexChannel(url, "ya29.access-token", map[string]interface{}{"project_id": "my-project"})
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode := chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", vertexModel, "false"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusForbidden, errWithCode.StatusCode)
+	assert.Contains(t, errWithCode.Message, "Permission denied")
+
+	// 未配置项目时不发送请求
+	channel = getVertexChannel(url, "ya29.access-token", nil)
+	chatProvider = getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+	_, errWithCode = chatProvider.CreateChatCompletion(test.GetChatCompletionRequest("default", vertexModel, "false"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "invalid_claude_config", errWithCode.Code)
+}
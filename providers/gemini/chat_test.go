@@ -0,0 +1,220 @@
+package gemini_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/common/requester"
+	"one-api/common/test"
+	_ "one-api/common/test/init"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR4nGP4z8DwHwAFAAH/iZk9HQAAAABJRU5ErkJggg=="
+
+// 记录上游收到的请求体，并返回固定的响应
+func handleGeminiEndpoint(requestBody *map[string]any, response string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+		if requestBody != nil {
+			json.NewDecoder(r.Body).Decode(requestBody)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, response)
+	}
+}
+
+func handleGeminiStreamEndpoint(requestBody *map[string]any, events []string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+		if r.URL.Query().Get("alt") != "sse" {
+			http.Error(w, "alt=sse is required", http.StatusBadRequest)
+			return
+		}
+
+		if requestBody != nil {
+			json.NewDecoder(r.Body).Decode(requestBody)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprint(w, "data: "+event+"\r\n\r\n")
+		}
+	}
+}
+
+func getChatRequestFromJSON(chatJSON string) *types.ChatCompletionRequest {
+	chatRequest := &types.ChatCompletionRequest{}
+	json.NewDecoder(strings.NewReader(chatJSON)).Decode(chatRequest)
+	return chatRequest
+}
+
+func readChatStream(t *testing.T, stream requester.StreamReaderInterface[string]) []types.ChatCompletionStreamResponse {
+	defer stream.Close()
+	dataChan, errChan := stream.Recv()
+
+	var responses []types.ChatCompletionStreamResponse
+	for {
+		select {
+		case data := <-dataChan:
+			var response types.ChatCompletionStreamResponse
+			assert.Nil(t, json.Unmarshal([]byte(data), &response))
+			responses = append(responses, response)
+		case err := <-errChan:
+			if !errors.Is(err, io.EOF) {
+				t.Error(err)
+			}
+			return responses
+		}
+	}
+}
+
+func TestChatCompletions(t *testing.T) {
+	common.ApproximateTokenEnabled = true
+	defer func() { common.ApproximateTokenEnabled = false }()
+
+	url, server, teardown := setupGeminiTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"candidates":[{"content":{"parts":[{"text":"Hello! How can I help you today?"}],"role":"model"},"finishReason":"STOP","index":0,"safetyRatings":[{"category":"HARM_CATEGORY_HARASSMENT","probability":"NEGLIGIBLE"}]}],"promptFeedback":{"safetyRatings":[{"category":"HARM_CATEGORY_HARASSMENT","probability":"NEGLIGIBLE"}]}}`
+	server.RegisterHandler("/v1/models/gemini-pro:generateContent", handleGeminiEndpoint(&requestBody, response))
+
+	channel := getGeminiChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{PromptTokens: 10})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"gemini-pro","messages":[
+		{"role":"system","content":"You are a helpful assistant."},
+		{"role":"user","content":"Hi"},
+		{"role":"assistant","content":"Hi there."},
+		{"role":"user","content":"Hello!"}
+	],"temperature":0.5,"max_tokens":100}`)
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	assert.Equal(t, "chat.completion", openaiResponse.Object)
+	assert.Equal(t, "gemini-pro", openaiResponse.Model)
+	assert.Len(t, openaiResponse.Choices, 1)
+	assert.Equal(t, "Hello! How can I help you today?", openaiResponse.Choices[0].Message.Content)
+	assert.Equal(t, types.FinishReasonStop, openaiResponse.Choices[0].FinishReason)
+	assert.Equal(t, 10, openaiResponse.Usage.PromptTokens)
+	assert.Greater(t, openaiResponse.Usage.CompletionTokens, 0)
+	assert.Equal(t, openaiResponse.Usage.PromptTokens+openaiResponse.Usage.CompletionTokens, openaiResponse.Usage.TotalTokens)
+
+	// system 转为 user，并补充一条 model 消息
+	var roles []string
+	for _, content := range requestBody["contents"].([]any) {
+		roles = append(roles, content.(map[string]any)["role"].(string))
+	}
+	assert.Equal(t, []string{"user", "model", "user", "model", "user"}, roles)
+	assert.Equal(t, map[string]any{"temperature": 0.5, "maxOutputTokens": float64(100)}, requestBody["generation_config"])
+}
+
+func TestChatCompletionsImage(t *testing.T) {
+	common.ApproximateTokenEnabled = true
+	defer func() { common.ApproximateTokenEnabled = false }()
+
+	url, server, teardown := setupGeminiTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	response := `{"candidates":[{"content":{"parts":[{"text":"A single pixel."}],"role":"model"},"finishReason":"STOP","index":0}]}`
+	server.RegisterHandler("/v1/models/gemini-pro-vision:generateContent", handleGeminiEndpoint(&requestBody, response))
+
+	channel := getGeminiChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"gemini-pro-vision","messages":[{"role":"user","content":[
+		{"type":"text","text":"What is this?"},
+		{"type":"image_url","image_url":{"url":"data:image/png;base64,` + testPNG + `"}}
+	]}]}`)
+	openaiResponse, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "A single pixel.", openaiResponse.Choices[0].Message.Content)
+
+	parts := requestBody["contents"].([]any)[0].(map[string]any)["parts"].([]any)
+	assert.Equal(t, []any{
+		map[string]any{"text": "What is this?"},
+		map[string]any{"inlineData": map[string]any{"mimeType": "image/png", "data": testPNG}},
+	}, parts)
+}
+
+func TestChatCompletionsStream(t *testing.T) {
+	common.ApproximateTokenEnabled = true
+	defer func() { common.ApproximateTokenEnabled = false }()
+
+	url, server, teardown := setupGeminiTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	requestBody := map[string]any{}
+	events := []string{
+		`{"candidates":[{"content":{"parts":[{"text":"Once upon"}],"role":"model"},"finishReason":"STOP","index":0}]}`,
+		`{"candidates":[{"content":{"parts":[{"text":" a time"}],"role":"model"},"finishReason":"STOP","index":0}]}`,
+	}
+	server.RegisterHandler("/v1/models/gemini-pro:streamGenerateContent", handleGeminiStreamEndpoint(&requestBody, events))
+
+	channel := getGeminiChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	usage := &types.Usage{PromptTokens: 10}
+	chatProvider.SetUsage(usage)
+
+	chatRequest := getChatRequestFromJSON(`{"model":"gemini-pro","messages":[{"role":"user","content":"Tell me a story"}],"stream":true}`)
+	stream, errWithCode := chatProvider.CreateChatCompletionStream(chatRequest)
+	assert.Nil(t, errWithCode)
+
+	responses := readChatStream(t, stream)
+	assert.Len(t, responses, 2)
+	content := ""
+	for _, response := range responses {
+		assert.Equal(t, "chat.completion.chunk", response.Object)
+		assert.Equal(t, "gemini-pro", response.Model)
+		content += response.Choices[0].Delta.Content
+	}
+	assert.Equal(t, "Once upon a time", content)
+
+	assert.Equal(t, "Tell me a story", requestBody["contents"].([]any)[0].(map[string]any)["parts"].([]any)[0].(map[string]any)["text"])
+	assert.Greater(t, usage.CompletionTokens, 0)
+	assert.Equal(t, usage.PromptTokens+usage.CompletionTokens, usage.TotalTokens)
+}
+
+func TestChatCompletionsError(t *testing.T) {
+	url, server, teardown := setupGeminiTestServer()
+	context, _ := test.GetContext("POST", "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	defer teardown()
+
+	server.RegisterHandler("/v1/models/gemini-pro:generateContent", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"code":"400","message":"API key not valid. Please pass a valid API key.","status":"INVALID_ARGUMENT"}}`)
+	})
+
+	channel := getGeminiChannel(url)
+	chatProvider := getChatProvider(&channel, context)
+	chatProvider.SetUsage(&types.Usage{})
+
+	chatRequest := getChatRequestFromJSON(`{"model":"gemini-pro","messages":[{"role":"user","content":"Hello!"}]}`)
+	_, errWithCode := chatProvider.CreateChatCompletion(chatRequest)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	assert.Equal(t, "gemini_error", errWithCode.Type)
+	assert.Contains(t, errWithCode.Message, "API key not valid")
+}
@@ -0,0 +1,35 @@
+package gemini_test
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/common/test"
+	"one-api/model"
+	"one-api/providers"
+	providers_base "one-api/providers/base"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupGeminiTestServer() (baseUrl string, server *test.ServerTest, teardown func()) {
+	server = test.NewTestServer()
+	ts := server.TestServer(func(w http.ResponseWriter, r *http.Request) bool {
+		return test.GeminiCheck(w, r)
+	})
+	ts.Start()
+	teardown = ts.Close
+
+	baseUrl = ts.URL
+	return
+}
+
+func getGeminiChannel(baseUrl string) model.Channel {
+	return test.GetChannel(common.ChannelTypeGemini, baseUrl, "", "", "")
+}
+
+func getChatProvider(channel *model.Channel, context *gin.Context) providers_base.ChatInterface {
+	provider := providers.GetProvider(channel, context)
+	chatProvider, _ := provider.(providers_base.ChatInterface)
+
+	return chatProvider
+}
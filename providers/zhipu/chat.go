@@ -116,7 +116,7 @@ func (p *ZhipuProvider) convertFromChatOpenai(request *types.ChatCompletionReque
 		Temperature: request.Temperature,
 		TopP:        convertTopP(request.TopP),
 		MaxTokens:   request.MaxTokens,
-		Stop:        request.Stop,
+		Stop:        request.GetStop(),
 		ToolChoice:  request.ToolChoice,
 	}
 
@@ -1,8 +1,11 @@
 package types
 
+import "encoding/json"
+
 const (
 	ContentTypeText     = "text"
 	ContentTypeImageURL = "image_url"
+	ContentTypeFile     = "file"
 )
 
 const (
@@ -35,12 +38,14 @@ type ChatCompletionToolCalls struct {
 }
 
 type ChatCompletionMessage struct {
-	Role         string                           `json:"role"`
-	Content      any                              `json:"content,omitempty"`
-	Name         *string                          `json:"name,omitempty"`
-	FunctionCall *ChatCompletionToolCallsFunction `json:"function_call,omitempty"`
-	ToolCalls    []*ChatCompletionToolCalls       `json:"tool_calls,omitempty"`
-	ToolCallID   string                           `json:"tool_call_id,omitempty"`
+	Role             string                           `json:"role"`
+	Content          any                              `json:"content,omitempty"`
+	ReasoningContent string                           `json:"reasoning_content,omitempty"`
+	Name             *string                          `json:"name,omitempty"`
+	FunctionCall     *ChatCompletionToolCallsFunction `json:"function_call,omitempty"`
+	ToolCalls        []*ChatCompletionToolCalls       `json:"tool_calls,omitempty"`
+	ToolCallID       string                           `json:"tool_call_id,omitempty"`
+	Annotations      any                              `json:"annotations,omitempty"`
 }
 
 func (m ChatCompletionMessage) StringContent() string {
@@ -91,10 +96,22 @@ func (m ChatCompletionMessage) ParseContent() []ChatMessagePart {
 					Text: subStr,
 				})
 			} else if subObj, ok := contentMap["image_url"].(map[string]any); ok {
+				detail, _ := subObj["detail"].(string)
 				contentList = append(contentList, ChatMessagePart{
 					Type: ContentTypeImageURL,
 					ImageURL: &ChatMessageImageURL{
-						URL: subObj["url"].(string),
+						URL:    subObj["url"].(string),
+						Detail: detail,
+					},
+				})
+			} else if subObj, ok := contentMap["file"].(map[string]any); ok {
+				fileData, _ := subObj["file_data"].(string)
+				filename, _ := subObj["filename"].(string)
+				contentList = append(contentList, ChatMessagePart{
+					Type: ContentTypeFile,
+					File: &ChatMessageFile{
+						FileData: fileData,
+						Filename: filename,
 					},
 				})
 			} else if subObj, ok := contentMap["image"].(string); ok {
@@ -116,37 +133,71 @@ type ChatMessageImageURL struct {
 	Detail string `json:"detail,omitempty"`
 }
 
+// file_data 可以是 data URI，也可以是文件的链接
+type ChatMessageFile struct {
+	FileData string `json:"file_data,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
 type ChatMessagePart struct {
 	Type     string               `json:"type,omitempty"`
 	Text     string               `json:"text,omitempty"`
 	ImageURL *ChatMessageImageURL `json:"image_url,omitempty"`
+	File     *ChatMessageFile     `json:"file,omitempty"`
 }
 
 type ChatCompletionResponseFormat struct {
-	Type string `json:"type,omitempty"`
+	Type       string                    `json:"type,omitempty"`
+	JsonSchema *ChatCompletionJsonSchema `json:"json_schema,omitempty"`
+}
+
+type ChatCompletionJsonSchema struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Schema      any    `json:"schema,omitempty"`
+	Strict      *bool  `json:"strict,omitempty"`
 }
 
 type ChatCompletionRequest struct {
-	Model            string                        `json:"model" binding:"required"`
-	Messages         []ChatCompletionMessage       `json:"messages" binding:"required"`
-	MaxTokens        int                           `json:"max_tokens,omitempty"`
-	Temperature      float64                       `json:"temperature,omitempty"`
-	TopP             float64                       `json:"top_p,omitempty"`
-	N                int                           `json:"n,omitempty"`
-	Stream           bool                          `json:"stream,omitempty"`
-	Stop             []string                      `json:"stop,omitempty"`
-	PresencePenalty  float64                       `json:"presence_penalty,omitempty"`
-	ResponseFormat   *ChatCompletionResponseFormat `json:"response_format,omitempty"`
-	Seed             *int                          `json:"seed,omitempty"`
-	FrequencyPenalty float64                       `json:"frequency_penalty,omitempty"`
-	LogitBias        any                           `json:"logit_bias,omitempty"`
-	LogProbs         *bool                         `json:"logprobs,omitempty"`
-	TopLogProbs      int                           `json:"top_logprobs,omitempty"`
-	User             string                        `json:"user,omitempty"`
-	Functions        []*ChatCompletionFunction     `json:"functions,omitempty"`
-	FunctionCall     any                           `json:"function_call,omitempty"`
-	Tools            []*ChatCompletionTool         `json:"tools,omitempty"`
-	ToolChoice       any                           `json:"tool_choice,omitempty"`
+	Model             string                        `json:"model" binding:"required"`
+	Messages          []ChatCompletionMessage       `json:"messages" binding:"required"`
+	MaxTokens         int                           `json:"max_tokens,omitempty"`
+	Temperature       float64                       `json:"temperature,omitempty"`
+	TopP              float64                       `json:"top_p,omitempty"`
+	TopK              int                           `json:"top_k,omitempty"`
+	N                 int                           `json:"n,omitempty"`
+	Stream            bool                          `json:"stream,omitempty"`
+	StreamOptions     *ChatCompletionStreamOptions  `json:"stream_options,omitempty"`
+	Stop              any                           `json:"stop,omitempty"`
+	PresencePenalty   float64                       `json:"presence_penalty,omitempty"`
+	ResponseFormat    *ChatCompletionResponseFormat `json:"response_format,omitempty"`
+	Seed              *int                          `json:"seed,omitempty"`
+	FrequencyPenalty  float64                       `json:"frequency_penalty,omitempty"`
+	LogitBias         any                           `json:"logit_bias,omitempty"`
+	LogProbs          *bool                         `json:"logprobs,omitempty"`
+	TopLogProbs       int                           `json:"top_logprobs,omitempty"`
+	User              string                        `json:"user,omitempty"`
+	Functions         []*ChatCompletionFunction     `json:"functions,omitempty"`
+	FunctionCall      any                           `json:"function_call,omitempty"`
+	Tools             []*ChatCompletionTool         `json:"tools,omitempty"`
+	ToolChoice        any                           `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool                         `json:"parallel_tool_calls,omitempty"`
+	ReasoningEffort   string                        `json:"reasoning_effort,omitempty"`
+	Thinking          *ChatCompletionThinking       `json:"thinking,omitempty"`
+	ServiceTier       string                        `json:"service_tier,omitempty"`
+
+	// 客户端是否传了 temperature 和 top_p，用于区分显式的 0 和未指定
+	hasTemperature bool
+	hasTopP        bool
+}
+
+type ChatCompletionStreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"`
+}
+
+type ChatCompletionThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
 }
 
 func (r ChatCompletionRequest) GetFunctionCate() string {
@@ -158,6 +209,71 @@ func (r ChatCompletionRequest) GetFunctionCate() string {
 	return ""
 }
 
+// 流式请求是否需要在最后返回 usage
+func (r ChatCompletionRequest) IncludeUsage() bool {
+	return r.StreamOptions != nil && r.StreamOptions.IncludeUsage
+}
+
+// stop 可以是字符串，也可以是字符串数组
+func (r ChatCompletionRequest) GetStop() []string {
+	switch stop := r.Stop.(type) {
+	case string:
+		if stop != "" {
+			return []string{stop}
+		}
+	case []string:
+		return stop
+	case []any:
+		stopList := make([]string, 0, len(stop))
+		for _, item := range stop {
+			if str, ok := item.(string); ok && str != "" {
+				stopList = append(stopList, str)
+			}
+		}
+		if len(stopList) > 0 {
+			return stopList
+		}
+	}
+
+	return nil
+}
+
+func (r *ChatCompletionRequest) UnmarshalJSON(data []byte) error {
+	type alias ChatCompletionRequest
+	if err := json.Unmarshal(data, (*alias)(r)); err != nil {
+		return err
+	}
+
+	var sampling struct {
+		Temperature *float64 `json:"temperature"`
+		TopP        *float64 `json:"top_p"`
+	}
+	if err := json.Unmarshal(data, &sampling); err != nil {
+		return err
+	}
+	r.hasTemperature = sampling.Temperature != nil
+	r.hasTopP = sampling.TopP != nil
+	return nil
+}
+
+// 客户端未指定 temperature 时返回 nil，显式传 0 时返回 0
+func (r ChatCompletionRequest) GetTemperature() *float64 {
+	if !r.hasTemperature && r.Temperature == 0 {
+		return nil
+	}
+	temperature := r.Temperature
+	return &temperature
+}
+
+// 客户端未指定 top_p 时返回 nil，显式传 0 时返回 0
+func (r ChatCompletionRequest) GetTopP() *float64 {
+	if !r.hasTopP && r.TopP == 0 {
+		return nil
+	}
+	topP := r.TopP
+	return &topP
+}
+
 type ChatCompletionFunction struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
@@ -187,6 +303,7 @@ type ChatCompletionResponse struct {
 	Usage               *Usage                 `json:"usage,omitempty"`
 	SystemFingerprint   string                 `json:"system_fingerprint,omitempty"`
 	PromptFilterResults any                    `json:"prompt_filter_results,omitempty"`
+	ServiceTier         string                 `json:"service_tier,omitempty"`
 }
 
 func (c ChatCompletionStreamChoice) ConvertOpenaiStream() []ChatCompletionStreamChoice {
@@ -257,10 +374,12 @@ func (c ChatCompletionStreamChoice) ConvertOpenaiStream() []ChatCompletionStream
 }
 
 type ChatCompletionStreamChoiceDelta struct {
-	Content      string                           `json:"content,omitempty"`
-	Role         string                           `json:"role,omitempty"`
-	FunctionCall *ChatCompletionToolCallsFunction `json:"function_call,omitempty"`
-	ToolCalls    []*ChatCompletionToolCalls       `json:"tool_calls,omitempty"`
+	Content          string                           `json:"content,omitempty"`
+	ReasoningContent string                           `json:"reasoning_content,omitempty"`
+	Role             string                           `json:"role,omitempty"`
+	FunctionCall     *ChatCompletionToolCallsFunction `json:"function_call,omitempty"`
+	ToolCalls        []*ChatCompletionToolCalls       `json:"tool_calls,omitempty"`
+	Annotations      any                              `json:"annotations,omitempty"`
 }
 
 type ChatCompletionStreamChoice struct {
@@ -268,6 +387,7 @@ type ChatCompletionStreamChoice struct {
 	Delta                ChatCompletionStreamChoiceDelta `json:"delta"`
 	FinishReason         any                             `json:"finish_reason"`
 	ContentFilterResults any                             `json:"content_filter_results,omitempty"`
+	FinishDetails        any                             `json:"finish_details,omitempty"`
 }
 
 type ChatCompletionStreamResponse struct {
@@ -277,4 +397,5 @@ type ChatCompletionStreamResponse struct {
 	Model             string                       `json:"model"`
 	Choices           []ChatCompletionStreamChoice `json:"choices"`
 	PromptAnnotations any                          `json:"prompt_annotations,omitempty"`
+	Usage             *Usage                       `json:"usage,omitempty"`
 }
@@ -1,11 +1,41 @@
 package types
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
+
+// 缓存读取和写入的 tokens 相对输入价格的倍率，与 Anthropic 的计费方式一致
+const (
+	CacheReadTokensRatio     = 0.1
+	CacheCreationTokensRatio = 1.25
+)
 
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens             int    `json:"prompt_tokens"`
+	CompletionTokens         int    `json:"completion_tokens"`
+	TotalTokens              int    `json:"total_tokens"`
+	CacheCreationInputTokens int    `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int    `json:"cache_read_input_tokens,omitempty"`
+	ServiceTier              string `json:"service_tier,omitempty"`
+
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+// 提示 tokens 的明细，CachedTokens 为读取缓存的 tokens，CacheCreationTokens 为写入缓存的 tokens
+type PromptTokensDetails struct {
+	CachedTokens        int `json:"cached_tokens"`
+	CacheCreationTokens int `json:"cache_creation_tokens,omitempty"`
+}
+
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// 未命中缓存的输入 tokens，PromptTokens 包含了缓存读取和写入的 tokens
+func (u *Usage) GetUncachedPromptTokens() int {
+	return u.PromptTokens - u.CacheReadInputTokens - u.CacheCreationInputTokens
 }
 
 type OpenAIError struct {
@@ -34,3 +64,10 @@ type OpenAIErrorWithStatusCode struct {
 type OpenAIErrorResponse struct {
 	Error OpenAIError `json:"error,omitempty"`
 }
+
+// 渠道健康检查结果，失败时 Error 为解析后的上游错误
+type ChannelTestResult struct {
+	Success bool                       `json:"success"`
+	Latency time.Duration              `json:"latency"`
+	Error   *OpenAIErrorWithStatusCode `json:"error,omitempty"`
+}